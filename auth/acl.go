@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Den3/mammoth/topic"
+)
+
+// Action is what a Client asks to do with a Topic
+type Action int
+
+const (
+	// Publish is sending a PUBLISH Packet to a Topic Name
+	Publish Action = iota + 1
+
+	// Subscribe is subscribing to a Topic Filter
+	Subscribe
+)
+
+func (a Action) String() string {
+	switch a {
+	case Publish:
+		return "publish"
+	case Subscribe:
+		return "subscribe"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Authorizer decides whether an authenticated Client may publish to a Topic Name
+// or subscribe to a Topic Filter.
+//
+// Authorize returns nil to allow the action and an error wrapping
+// ErrNotAuthorized to deny it. Any other error also denies it and is logged by
+// the Server. ctx is cancelled when the Server is closed. Authorize is called
+// concurrently for different Clients.
+type Authorizer interface {
+	Authorize(ctx context.Context, id *Identity, action Action, topic string) error
+}
+
+// Authorize implements Authorizer by allowing everything
+func (AllowAll) Authorize(ctx context.Context, id *Identity, action Action, topic string) error {
+	return nil
+}
+
+// ACL authorizes Clients with rules read from a file. Every line of the file
+// holds rules for the Clients named at its start:
+//
+//	# comment
+//	user alice: allow publish sensors/alice/#; allow subscribe sensors/#
+//	client backup-1: allow subscribe #
+//	claim role=admin: allow pubsub #
+//	addr 10.0.0.0/8: allow subscribe plant/#
+//	all: allow pubsub devices/%c/#; allow publish tenants/%{tenant}/%u; deny subscribe #
+//
+// "user" names Clients by User Name, "client" by ClientId, "claim" by a claim of
+// the token they authenticated with, which is the value or an array holding it,
+// "addr" by the IP address they connect from, within a network or equal to an
+// address, and "all" names every Client. A rule allows or denies publish, subscribe or
+// pubsub, which is both, on a Topic Filter where %u stands for the User Name, %c
+// for the ClientId and %{name} for the string claim name of the Client. A rule
+// whose placeholders have no value, or a value holding /, + or #, does not apply.
+//
+// The first rule of the file naming the Client and the action, and applying to
+// the Topic, decides. A publish rule applies to the Topic Names matching its
+// filter. An allow subscribe rule applies to the Topic Filters it covers
+// entirely, while a deny subscribe rule applies to any Topic Filter sharing a
+// Topic Name with it. Actions no rule applies to are denied.
+type ACL struct {
+	rules []aclRule
+}
+
+// aclRule is one allow or deny rule of an ACL
+type aclRule struct {
+	// scope is "user", "client", "claim", "addr" or "all", and name the User Name,
+	// ClientId or claim. value is the value of the claim, prefix the network of
+	// addr.
+	scope  string
+	name   string
+	value  string
+	prefix netip.Prefix
+
+	allow     bool
+	publish   bool
+	subscribe bool
+	filter    string
+}
+
+// LoadACL reads the ACL file at path
+func LoadACL(path string) (*ACL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseACL(path, f)
+}
+
+// ParseACL reads an ACL from r. Errors are prefixed with name and the line they
+// were found on.
+func ParseACL(name string, r io.Reader) (*ACL, error) {
+	acl := &ACL{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rules, err := parseACLLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		acl.rules = append(acl.rules, rules...)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// parseACLLine parses a scope and its rules
+func parseACLLine(line string) ([]aclRule, error) {
+	scope, list, ok := strings.Cut(line, ":")
+	if f := strings.Fields(line); len(f) > 1 && f[0] == "addr" {
+		// IPv6 addresses hold colons, the scope ends at the one after the address
+		addr := strings.TrimSuffix(f[1], ":")
+		end := strings.Index(line, addr) + len(addr)
+		rest := strings.TrimLeft(line[end:], " \t")
+		scope = line[:end]
+		list, ok = strings.CutPrefix(rest, ":")
+	}
+	if !ok {
+		return nil, fmt.Errorf("missing colon after user, client, claim, addr or all")
+	}
+
+	var r aclRule
+	fields := strings.Fields(scope)
+	switch {
+	case len(fields) == 1 && fields[0] == "all":
+	case len(fields) == 2 && (fields[0] == "user" || fields[0] == "client"):
+		r.name = fields[1]
+	case len(fields) == 2 && fields[0] == "claim" && strings.Contains(fields[1], "="):
+		r.name, r.value, _ = strings.Cut(fields[1], "=")
+	case len(fields) == 2 && fields[0] == "addr":
+		var err error
+		if r.prefix, err = ParseNetwork(fields[1]); err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or network", fields[1])
+		}
+	default:
+		return nil, fmt.Errorf("%q is not user <name>, client <id>, claim <name>=<value>, addr <network> or all", strings.TrimSpace(scope))
+	}
+	r.scope = fields[0]
+
+	var rules []aclRule
+	for _, text := range strings.Split(list, ";") {
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%q is not allow|deny publish|subscribe|pubsub <filter>", strings.TrimSpace(text))
+		}
+
+		switch fields[0] {
+		case "allow":
+			r.allow = true
+		case "deny":
+			r.allow = false
+		default:
+			return nil, fmt.Errorf("%q is neither allow nor deny", fields[0])
+		}
+		switch fields[1] {
+		case "publish":
+			r.publish, r.subscribe = true, false
+		case "subscribe":
+			r.publish, r.subscribe = false, true
+		case "pubsub":
+			r.publish, r.subscribe = true, true
+		default:
+			return nil, fmt.Errorf("%q is not publish, subscribe or pubsub", fields[1])
+		}
+
+		r.filter = fields[2]
+		sample, ok := substitute(r.filter, func(string) string { return "x" })
+		if !ok {
+			return nil, fmt.Errorf("%q: %%{ without }", r.filter)
+		}
+		if !topic.ValidFilter([]byte(sample)) {
+			return nil, fmt.Errorf("%q: %v", r.filter, topic.ErrTopicFilterInvalid)
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rule for %s", strings.TrimSpace(scope))
+	}
+	return rules, nil
+}
+
+// Authorize implements Authorizer
+func (acl *ACL) Authorize(ctx context.Context, id *Identity, action Action, name string) error {
+	for _, r := range acl.rules {
+		if !r.names(id) || (action == Publish && !r.publish) || (action == Subscribe && !r.subscribe) {
+			continue
+		}
+		filter, ok := r.expand(id)
+		if !ok {
+			continue
+		}
+
+		var applies bool
+		switch {
+		case action == Publish:
+			applies = topic.Match([]byte(filter), []byte(name))
+		case r.allow:
+			applies = topic.Covers([]byte(filter), []byte(name))
+		default:
+			applies = topic.Overlap([]byte(filter), []byte(name))
+		}
+		if !applies {
+			continue
+		}
+		if r.allow {
+			return nil
+		}
+		return ErrNotAuthorized
+	}
+	return ErrNotAuthorized
+}
+
+// names reports whether the rule is for the Client id
+func (r *aclRule) names(id *Identity) bool {
+	switch r.scope {
+	case "user":
+		return id.UserName != "" && id.UserName == r.name
+	case "client":
+		return id.ClientID == r.name
+	case "claim":
+		switch v := id.Claims[r.name].(type) {
+		case string:
+			return v == r.value
+		case []any:
+			return slices.Contains(v, any(r.value))
+		}
+		return false
+	case "addr":
+		ip, ok := remoteIP(id.RemoteAddr)
+		return ok && r.prefix.Contains(ip)
+	}
+	return true
+}
+
+// ParseNetwork parses an IP network such as 10.0.0.0/8, or an address standing
+// for a network of itself alone
+func ParseNetwork(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// remoteIP returns the IP address of addr, false if it has none
+func remoteIP(addr net.Addr) (netip.Addr, bool) {
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
+
+// expand substitutes the placeholders of the filter of the rule. It returns false
+// if a value is missing or holds characters which would change the levels of the
+// filter, so that the rule cannot apply.
+func (r *aclRule) expand(id *Identity) (string, bool) {
+	valid := true
+	filter, _ := substitute(r.filter, func(placeholder string) string {
+		var value string
+		switch placeholder {
+		case "%u":
+			value = id.UserName
+		case "%c":
+			value = id.ClientID
+		default:
+			value, _ = id.Claims[placeholder[2:len(placeholder)-1]].(string)
+		}
+		if value == "" || strings.ContainsAny(value, "/+#") {
+			valid = false
+		}
+		return value
+	})
+	return filter, valid
+}
+
+// substitute replaces the placeholders %u, %c and %{name} of filter with the
+// values returned by value. It returns false if a %{ is not closed.
+func substitute(filter string, value func(placeholder string) string) (string, bool) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(filter, '%')
+		if i < 0 || i == len(filter)-1 {
+			b.WriteString(filter)
+			return b.String(), true
+		}
+		b.WriteString(filter[:i])
+
+		n := 2
+		switch filter[i+1] {
+		case 'u', 'c':
+		case '{':
+			end := strings.IndexByte(filter[i:], '}')
+			if end < 0 {
+				return "", false
+			}
+			n = end + 1
+		default:
+			b.WriteByte('%')
+			filter = filter[i+1:]
+			continue
+		}
+		b.WriteString(value(filter[i : i+n]))
+		filter = filter[i+n:]
+	}
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	acl, err := ParseACL("acl", strings.NewReader(`
+# sensors
+user alice: allow publish sensors/alice/#; allow subscribe sensors/#
+client backup: allow subscribe #
+all: allow pubsub devices/%c/#; allow publish users/%u; deny subscribe secret/#
+all: allow subscribe public/#
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &Identity{UserName: "alice", ClientID: "a1"}
+	bob := &Identity{UserName: "bob", ClientID: "b1"}
+	anonymous := &Identity{ClientID: "x1"}
+	backup := &Identity{ClientID: "backup"}
+	tests := []struct {
+		id      *Identity
+		action  Action
+		topic   string
+		allowed bool
+	}{
+		{alice, Publish, "sensors/alice/temp", true},
+		{alice, Publish, "sensors/bob/temp", false},
+		{alice, Subscribe, "sensors/+/temp", true},
+		{alice, Subscribe, "#", false},
+		{bob, Subscribe, "sensors/#", false},
+		{bob, Publish, "devices/b1/state", true},
+		{bob, Subscribe, "devices/b1/#", true},
+		{bob, Subscribe, "devices/+/#", false},
+		{bob, Publish, "devices/a1/state", false},
+		{bob, Publish, "users/bob", true},
+		{anonymous, Publish, "users/", false},
+		{anonymous, Subscribe, "public/news", true},
+		{backup, Subscribe, "#", true},
+		{bob, Subscribe, "secret/#", false},
+		{&Identity{UserName: "a/#", ClientID: "c"}, Publish, "users/a/b", false},
+	}
+	for _, tt := range tests {
+		err := acl.Authorize(context.Background(), tt.id, tt.action, tt.topic)
+		if tt.allowed && err != nil || !tt.allowed && err != ErrNotAuthorized {
+			t.Errorf("Authorize(%+v, %v, %q) = %v, want allowed %v", tt.id, tt.action, tt.topic, err, tt.allowed)
+		}
+	}
+}
+
+func TestACLClaims(t *testing.T) {
+	acl, err := ParseACL("acl", strings.NewReader(`
+claim roles=admin: allow pubsub #
+claim tier=gold: allow subscribe premium/#
+all: allow pubsub tenants/%{tenant}/%u/#; allow subscribe 100%/%{tenant}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &Identity{UserName: "root", Claims: map[string]any{"roles": []any{"ops", "admin"}}}
+	gold := &Identity{UserName: "ann", Claims: map[string]any{"tier": "gold", "tenant": "acme"}}
+	numeric := &Identity{UserName: "bob", Claims: map[string]any{"tenant": 7.0}}
+	sneaky := &Identity{UserName: "eve", Claims: map[string]any{"tenant": "+"}}
+	tests := []struct {
+		id      *Identity
+		action  Action
+		topic   string
+		allowed bool
+	}{
+		{admin, Subscribe, "#", true},
+		{gold, Subscribe, "premium/news", true},
+		{gold, Publish, "premium/news", false},
+		{gold, Publish, "tenants/acme/ann/x", true},
+		{gold, Publish, "tenants/acme/bob/x", false},
+		{gold, Subscribe, "100%/acme", true},
+		{numeric, Publish, "tenants/7/bob/x", false},
+		{sneaky, Subscribe, "tenants/+/eve/#", false},
+		{&Identity{UserName: "carl"}, Subscribe, "tenants//carl/#", false},
+	}
+	for _, tt := range tests {
+		err := acl.Authorize(context.Background(), tt.id, tt.action, tt.topic)
+		if tt.allowed && err != nil || !tt.allowed && err != ErrNotAuthorized {
+			t.Errorf("Authorize(%+v, %v, %q) = %v, want allowed %v", tt.id, tt.action, tt.topic, err, tt.allowed)
+		}
+	}
+}
+
+func TestACLAddr(t *testing.T) {
+	acl, err := ParseACL("acl", strings.NewReader(`
+addr 10.0.0.0/8: allow subscribe plant/#
+addr 2001:db8::1: allow publish plant/commands
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := func(s string) *Identity {
+		a, _ := net.ResolveTCPAddr("tcp", s)
+		return &Identity{ClientID: "c", RemoteAddr: a}
+	}
+	tests := []struct {
+		id      *Identity
+		action  Action
+		topic   string
+		allowed bool
+	}{
+		{addr("10.1.2.3:51234"), Subscribe, "plant/#", true},
+		{addr("[::ffff:10.1.2.3]:51234"), Subscribe, "plant/#", true},
+		{addr("192.0.2.1:51234"), Subscribe, "plant/#", false},
+		{addr("[2001:db8::1]:51234"), Publish, "plant/commands", true},
+		{addr("[2001:db8::2]:51234"), Publish, "plant/commands", false},
+		{&Identity{ClientID: "c", RemoteAddr: &net.UnixAddr{Name: "/run/mammoth.sock", Net: "unix"}}, Subscribe, "plant/#", false},
+		{&Identity{ClientID: "c"}, Subscribe, "plant/#", false},
+	}
+	for _, tt := range tests {
+		err := acl.Authorize(context.Background(), tt.id, tt.action, tt.topic)
+		if tt.allowed && err != nil || !tt.allowed && err != ErrNotAuthorized {
+			t.Errorf("Authorize(%v, %v, %q) = %v, want allowed %v", tt.id.RemoteAddr, tt.action, tt.topic, err, tt.allowed)
+		}
+	}
+}
+
+func TestParseACLErrors(t *testing.T) {
+	tests := []struct {
+		text, err string
+	}{
+		{"user alice allow publish a", `acl:1: missing colon after user, client, claim, addr or all`},
+		{"\ngroup admins: allow publish a", `acl:2: "group admins" is not user <name>, client <id>, claim <name>=<value>, addr <network> or all`},
+		{"claim admin: allow publish a", `acl:1: "claim admin" is not user <name>, client <id>, claim <name>=<value>, addr <network> or all`},
+		{"addr 10.0.0.0/33: allow publish a", `acl:1: "10.0.0.0/33" is not an IP address or network`},
+		{"all: allow publish a/%{tenant", `acl:1: "a/%{tenant": %{ without }`},
+		{"all: allow publish", `acl:1: "allow publish" is not allow|deny publish|subscribe|pubsub <filter>`},
+		{"all: permit publish a", `acl:1: "permit" is neither allow nor deny`},
+		{"all: allow read a", `acl:1: "read" is not publish, subscribe or pubsub`},
+		{"all: allow subscribe a/#/b", `acl:1: "a/#/b": invalid Topic Filter`},
+		{"user alice:", `acl:1: no rule for user alice`},
+	}
+	for _, tt := range tests {
+		_, err := ParseACL("acl", strings.NewReader(tt.text))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("ParseACL(%q) error = %v, want %s", tt.text, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package auth authenticates the Clients connecting to the Server
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrBadCredentials indicates a User Name or Password which is not valid. The
+	// Server answers with CONNACK return code 0x04 (bad user name or password).
+	ErrBadCredentials = errors.New("bad user name or password")
+
+	// ErrNotAuthorized indicates a Client which is not allowed to connect, such as an
+	// anonymous one. The Server answers with CONNACK return code 0x05 (not
+	// authorized).
+	ErrNotAuthorized = errors.New("not authorized")
+)
+
+// Request is what a Client presents to connect
+type Request struct {
+	// ClientID is the ClientId of the Client, or the one the Server assigned to it
+	ClientID string
+
+	// UserName and Password are the ones of CONNECT. HasUserName and HasPassword
+	// tell whether the User Name Flag and Password Flag were set.
+	UserName    string
+	Password    []byte
+	HasUserName bool
+	HasPassword bool
+
+	// RemoteAddr is the address of the Network Connection
+	RemoteAddr net.Addr
+
+	// TLS is the state of the TLS connection, nil for a Network Connection without
+	// TLS
+	TLS *tls.ConnectionState
+
+	// Peer holds the credentials of the process at the other end of a unix socket,
+	// nil for other Network Connections or where the system does not tell them
+	Peer *PeerCred
+}
+
+// Identity is an authenticated Client
+type Identity struct {
+	// UserName is the User Name the Client is known by, empty for an anonymous one
+	UserName string
+
+	// ClientID is the ClientId of the Client
+	ClientID string
+
+	// RemoteAddr is the address of the Network Connection of the Client, set by the
+	// Server. Behind a proxy sending the PROXY protocol it is the address of the
+	// Client, not the proxy's.
+	RemoteAddr net.Addr
+
+	// Expires, if not zero, is when the credentials of the Client expire. The Server
+	// disconnects the Client then.
+	Expires time.Time
+
+	// Claims are the claims of the token the Client authenticated with, nil
+	// without one
+	Claims map[string]any
+}
+
+// Authenticator decides whether a Client may connect.
+//
+// Authenticate returns the Identity of the Client, or an error wrapping
+// ErrBadCredentials or ErrNotAuthorized to refuse it. Any other error means the
+// Client could not be authenticated, and the Server answers with CONNACK return
+// code 0x03 (Server unavailable). A nil Identity without an error refuses the
+// Client like ErrNotAuthorized. ctx is cancelled when the Server is closed.
+// Authenticate is called concurrently for different Clients.
+type Authenticator interface {
+	Authenticate(ctx context.Context, r *Request) (*Identity, error)
+}
+
+// AuthenticatorFunc is a function used as an Authenticator
+type AuthenticatorFunc func(ctx context.Context, r *Request) (*Identity, error)
+
+// Authenticate implements Authenticator by calling f
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, r *Request) (*Identity, error) {
+	return f(ctx, r)
+}
+
+// identityOf returns the Identity the Client of r claims
+func identityOf(r *Request) *Identity {
+	return &Identity{UserName: r.UserName, ClientID: r.ClientID}
+}
+
+// AllowAll accepts every Client under the User Name it presents
+type AllowAll struct{}
+
+// Authenticate implements Authenticator
+func (AllowAll) Authenticate(ctx context.Context, r *Request) (*Identity, error) {
+	return identityOf(r), nil
+}
+
+// Static accepts the Clients presenting one of a set of User Names and Passwords
+// kept in memory. It is meant for tests and development, a password file keeps
+// production credentials. The zero value accepts nobody.
+type Static struct {
+	// AllowAnonymous accepts Clients without a User Name
+	AllowAnonymous bool
+
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// NewStatic returns a Static accepting users, a map from User Name to Password
+func NewStatic(users map[string]string) *Static {
+	a := &Static{}
+	a.SetUsers(users)
+	return a
+}
+
+// SetUsers replaces the users accepted, a map from User Name to Password
+func (a *Static) SetUsers(users map[string]string) {
+	m := make(map[string]string, len(users))
+	for name, password := range users {
+		m[name] = password
+	}
+	a.mu.Lock()
+	a.users = m
+	a.mu.Unlock()
+}
+
+// Authenticate implements Authenticator
+func (a *Static) Authenticate(ctx context.Context, r *Request) (*Identity, error) {
+	if !r.HasUserName {
+		if a.AllowAnonymous {
+			return identityOf(r), nil
+		}
+		return nil, ErrNotAuthorized
+	}
+
+	a.mu.RLock()
+	password, ok := a.users[r.UserName]
+	a.mu.RUnlock()
+	match := subtle.ConstantTimeCompare([]byte(password), r.Password) == 1
+	if !ok || !match || !r.HasPassword {
+		return nil, ErrBadCredentials
+	}
+	return identityOf(r), nil
+}
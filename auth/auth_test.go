@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestStatic(t *testing.T) {
+	a := NewStatic(map[string]string{"alice": "secret"})
+	tests := []struct {
+		r   Request
+		id  *Identity
+		err error
+	}{
+		{Request{ClientID: "c", UserName: "alice", Password: []byte("secret"), HasUserName: true, HasPassword: true}, &Identity{UserName: "alice", ClientID: "c"}, nil},
+		{Request{ClientID: "c", UserName: "alice", Password: []byte("secreT"), HasUserName: true, HasPassword: true}, nil, ErrBadCredentials},
+		{Request{ClientID: "c", UserName: "alice", HasUserName: true}, nil, ErrBadCredentials},
+		{Request{ClientID: "c", UserName: "bob", Password: []byte(""), HasUserName: true, HasPassword: true}, nil, ErrBadCredentials},
+		{Request{ClientID: "c"}, nil, ErrNotAuthorized},
+	}
+	for _, tt := range tests {
+		id, err := a.Authenticate(context.Background(), &tt.r)
+		if !reflect.DeepEqual(id, tt.id) || err != tt.err {
+			t.Errorf("Authenticate(%+v) = %+v, %v, want %+v, %v", tt.r, id, err, tt.id, tt.err)
+		}
+	}
+
+	a.AllowAnonymous = true
+	if id, err := a.Authenticate(context.Background(), &Request{ClientID: "c"}); err != nil || id.UserName != "" {
+		t.Errorf("Authenticate(anonymous) = %+v, %v", id, err)
+	}
+
+	a.SetUsers(map[string]string{"bob": "other"})
+	r := &Request{ClientID: "c", UserName: "alice", Password: []byte("secret"), HasUserName: true, HasPassword: true}
+	if _, err := a.Authenticate(context.Background(), r); err != ErrBadCredentials {
+		t.Errorf("Authenticate() of a removed user error = %v, want %v", err, ErrBadCredentials)
+	}
+}
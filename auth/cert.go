@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+)
+
+// CertificateField names a part of a Client certificate identifying the Client
+type CertificateField string
+
+const (
+	// CommonName is the Common Name of the Subject of the certificate
+	CommonName CertificateField = "cn"
+
+	// DNSName, EmailAddress and URI are the first Subject Alternative Name of their
+	// type
+	DNSName      CertificateField = "dns"
+	EmailAddress CertificateField = "email"
+	URI          CertificateField = "uri"
+)
+
+// ValidCertificateField reports whether f names a part of a certificate
+func ValidCertificateField(f CertificateField) bool {
+	switch f {
+	case CommonName, DNSName, EmailAddress, URI:
+		return true
+	}
+	return false
+}
+
+// value returns the field f of cert, empty if cert has none
+func (f CertificateField) value(cert *x509.Certificate) string {
+	switch f {
+	case CommonName:
+		return cert.Subject.CommonName
+	case DNSName:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case EmailAddress:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case URI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	}
+	return ""
+}
+
+// Certificate authenticates the Clients presenting a verified TLS Client
+// certificate by the identity of the certificate, and the other Clients with Next.
+// A Client whose certificate lacks the field asked for is refused with
+// ErrNotAuthorized.
+type Certificate struct {
+	// UserName, if not empty, makes the field of the certificate the User Name of
+	// the Client, ignoring the User Name and Password of CONNECT.
+	UserName CertificateField
+
+	// MatchClientID refuses the Clients whose ClientId is not the Common Name of
+	// their certificate. Clients letting the Server assign their ClientId are
+	// refused too.
+	MatchClientID bool
+
+	// Next authenticates the Clients without certificate, and the ones with a
+	// certificate when UserName is empty. Nil means AllowAll.
+	Next Authenticator
+}
+
+// Authenticate implements Authenticator
+func (a *Certificate) Authenticate(ctx context.Context, r *Request) (*Identity, error) {
+	next := a.Next
+	if next == nil {
+		next = AllowAll{}
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return next.Authenticate(ctx, r)
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	if a.MatchClientID && (cert.Subject.CommonName == "" || r.ClientID != cert.Subject.CommonName) {
+		return nil, fmt.Errorf("ClientId %q is not the certificate Common Name %q: %w", r.ClientID, cert.Subject.CommonName, ErrNotAuthorized)
+	}
+	if a.UserName == "" {
+		return next.Authenticate(ctx, r)
+	}
+
+	name := a.UserName.value(cert)
+	if name == "" {
+		return nil, fmt.Errorf("no %s in the certificate of %q: %w", a.UserName, r.ClientID, ErrNotAuthorized)
+	}
+	return &Identity{UserName: name, ClientID: r.ClientID}, nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCertificate(t *testing.T) {
+	device := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "device-1"},
+		DNSNames:       []string{"device-1.example.com", "other.example.com"},
+		EmailAddresses: []string{"ops@example.com"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/device-1"}},
+	}
+	verified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{device}, VerifiedChains: [][]*x509.Certificate{{device}}}
+	unverified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{device}}
+	alice := Request{ClientID: "device-1", UserName: "alice", Password: []byte("secret"), HasUserName: true, HasPassword: true}
+	next := NewStatic(map[string]string{"alice": "secret"})
+
+	tests := []struct {
+		a    Certificate
+		r    Request
+		tls  *tls.ConnectionState
+		name string
+		err  error
+	}{
+		{Certificate{UserName: CommonName, Next: next}, Request{ClientID: "device-1"}, verified, "device-1", nil},
+		{Certificate{UserName: DNSName, Next: next}, Request{ClientID: "device-1", UserName: "mallory", HasUserName: true}, verified, "device-1.example.com", nil},
+		{Certificate{UserName: EmailAddress, Next: next}, Request{ClientID: "c"}, verified, "ops@example.com", nil},
+		{Certificate{UserName: URI, Next: next}, Request{ClientID: "c"}, verified, "spiffe://example.com/device-1", nil},
+		{Certificate{UserName: CommonName, MatchClientID: true, Next: next}, Request{ClientID: "device-2"}, verified, "", ErrNotAuthorized},
+		{Certificate{MatchClientID: true, Next: next}, alice, verified, "alice", nil},
+		{Certificate{MatchClientID: true, Next: next}, Request{ClientID: "device-1"}, verified, "", ErrNotAuthorized},
+
+		// Clients without a verified certificate are left to Next
+		{Certificate{UserName: CommonName, MatchClientID: true, Next: next}, alice, nil, "alice", nil},
+		{Certificate{UserName: CommonName, Next: next}, Request{ClientID: "device-1"}, unverified, "", ErrNotAuthorized},
+		{Certificate{UserName: CommonName}, Request{ClientID: "c"}, nil, "", nil},
+	}
+	for _, tt := range tests {
+		r := tt.r
+		r.TLS = tt.tls
+		id, err := tt.a.Authenticate(context.Background(), &r)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%+v.Authenticate(%+v) error = %v, want %v", tt.a, tt.r, err, tt.err)
+			continue
+		}
+		if want := (&Identity{UserName: tt.name, ClientID: r.ClientID}); err == nil && !reflect.DeepEqual(id, want) {
+			t.Errorf("%+v.Authenticate(%+v) = %+v, want %+v", tt.a, tt.r, id, want)
+		}
+	}
+
+	noSAN := &x509.Certificate{Subject: pkix.Name{CommonName: "device-1"}}
+	r := &Request{ClientID: "device-1", TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{noSAN}, VerifiedChains: [][]*x509.Certificate{{noSAN}}}}
+	if _, err := (&Certificate{UserName: DNSName}).Authenticate(context.Background(), r); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Authenticate() without a DNS name error = %v, want %v", err, ErrNotAuthorized)
+	}
+}
@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTokenInvalid indicates a token which is malformed, or whose signature
+	// cannot be verified with any key
+	ErrTokenInvalid = errors.New("invalid token")
+
+	// ErrTokenExpired indicates a token used after its exp claim or before its nbf
+	// claim
+	ErrTokenExpired = errors.New("token expired or not valid yet")
+
+	// ErrTokenClaims indicates a token whose claims are not accepted: a wrong
+	// audience or issuer, or a missing claim
+	ErrTokenClaims = errors.New("token claims not accepted")
+
+	// ErrKeyInvalid indicates a JSON Web Key which cannot be used to verify tokens
+	ErrKeyInvalid = errors.New("invalid key")
+)
+
+// KeySet holds the JSON Web Keys (RFC 7517) tokens are verified with
+type KeySet struct {
+	keys []*jwk
+}
+
+// jwk is a key of a KeySet
+type jwk struct {
+	// kid is the key ID, matched against the kid header of tokens when both are set
+	kid string
+
+	// alg is the one algorithm the key verifies, HS256, RS256 or ES256
+	alg string
+
+	// key is a []byte for HS256, a *rsa.PublicKey for RS256 and an
+	// *ecdsa.PublicKey for ES256
+	key any
+}
+
+// LoadJWKS reads the JSON Web Key Set at path
+func LoadJWKS(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ks, nil
+}
+
+// ParseJWKS decodes a JSON Web Key Set: {"keys": [...]}. It holds symmetric keys
+// of type oct for HS256, RSA public keys for RS256 and EC public keys on curve
+// P-256 for ES256. Keys whose use is not sig are skipped.
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			K   string `json:"k"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{}
+	enc := base64.RawURLEncoding
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key := &jwk{kid: k.Kid}
+		var err error
+		switch k.Kty {
+		case "oct":
+			key.alg = "HS256"
+			var secret []byte
+			if secret, err = enc.DecodeString(k.K); err == nil && len(secret) == 0 {
+				err = errors.New("empty k")
+			}
+			key.key = secret
+		case "RSA":
+			key.alg = "RS256"
+			var n, e []byte
+			if n, err = enc.DecodeString(k.N); err != nil {
+				break
+			}
+			if e, err = enc.DecodeString(k.E); err != nil {
+				break
+			}
+			exp := new(big.Int).SetBytes(e)
+			if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 || len(n) < 2048/8 {
+				err = errors.New("unsupported RSA key size or exponent")
+				break
+			}
+			key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+		case "EC":
+			key.alg = "ES256"
+			if k.Crv != "P-256" {
+				err = fmt.Errorf("unsupported curve %q", k.Crv)
+				break
+			}
+			var x, y []byte
+			if x, err = enc.DecodeString(k.X); err != nil {
+				break
+			}
+			if y, err = enc.DecodeString(k.Y); err != nil {
+				break
+			}
+			if len(x) != 32 || len(y) != 32 {
+				err = errors.New("x and y must be 32 bytes")
+				break
+			}
+			key.key, err = ecdsa.ParseUncompressedPublicKey(elliptic.P256(), slices.Concat([]byte{4}, x, y))
+		default:
+			err = fmt.Errorf("unsupported kty %q", k.Kty)
+		}
+		if err == nil && k.Alg != "" && k.Alg != key.alg {
+			err = fmt.Errorf("alg %q does not fit kty %q", k.Alg, k.Kty)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %w: %v", i, ErrKeyInvalid, err)
+		}
+		ks.keys = append(ks.keys, key)
+	}
+	return ks, nil
+}
+
+// Verify checks the signature of token, a JSON Web Token (RFC 7519) in compact
+// serialization signed with HS256, RS256 or ES256, and returns its claims. It does
+// not check the claims.
+func (ks *KeySet) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	enc := base64.RawURLEncoding
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	hash := sha256.Sum256(signed)
+	verified := false
+	for _, k := range ks.keys {
+		if k.alg != header.Alg || (header.Kid != "" && k.kid != "" && k.kid != header.Kid) {
+			continue
+		}
+		switch key := k.key.(type) {
+		case []byte:
+			mac := hmac.New(sha256.New, key)
+			mac.Write(signed)
+			verified = hmac.Equal(sig, mac.Sum(nil))
+		case *rsa.PublicKey:
+			verified = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil
+		case *ecdsa.PublicKey:
+			// The signature is R and S, 32 bytes each [RFC 7518 section 3.4]
+			verified = len(sig) == 64 &&
+				ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+		}
+		if verified {
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrTokenInvalid
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if claims == nil {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON object of a token into v
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// JWT authenticates the Clients whose Password is a JSON Web Token signed with a
+// key of Keys, known by the User Name of the UserNameClaim claim of the token.
+// The token must carry an exp claim, and the Server disconnects the Client when it
+// passes. The claims are kept in the Identity of the Client for the Authorizer.
+//
+// Tokens which cannot be verified or whose claims are not accepted are refused with
+// ErrBadCredentials.
+type JWT struct {
+	Keys *KeySet
+
+	// Audience, if not empty, must be the aud claim or one of its values
+	Audience string
+
+	// Issuer, if not empty, must be the iss claim
+	Issuer string
+
+	// UserNameClaim names the claim holding the User Name. Empty means "sub".
+	UserNameClaim string
+
+	// Leeway is the clock skew tolerated when checking exp and nbf
+	Leeway time.Duration
+
+	// Next authenticates the Clients without Password. Nil refuses them with
+	// ErrNotAuthorized.
+	Next Authenticator
+}
+
+// Authenticate implements Authenticator
+func (a *JWT) Authenticate(ctx context.Context, r *Request) (*Identity, error) {
+	if !r.HasPassword {
+		if a.Next == nil {
+			return nil, ErrNotAuthorized
+		}
+		return a.Next.Authenticate(ctx, r)
+	}
+
+	claims, err := a.Keys.Verify(string(r.Password))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadCredentials, err)
+	}
+	expires, err := a.check(claims, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadCredentials, err)
+	}
+
+	claim := a.UserNameClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	name, _ := claims[claim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: %w: no %s", ErrBadCredentials, ErrTokenClaims, claim)
+	}
+	return &Identity{UserName: name, ClientID: r.ClientID, Expires: expires, Claims: claims}, nil
+}
+
+// check checks the registered claims of a token at now and returns when it
+// expires
+func (a *JWT) check(claims map[string]any, now time.Time) (time.Time, error) {
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: no exp", ErrTokenClaims)
+	}
+	if !now.Before(exp.Add(a.Leeway)) {
+		return time.Time{}, ErrTokenExpired
+	}
+	if v, present := claims["nbf"]; present {
+		nbf, ok := numericDate(v)
+		if !ok {
+			return time.Time{}, fmt.Errorf("%w: invalid nbf", ErrTokenClaims)
+		}
+		if now.Add(a.Leeway).Before(nbf) {
+			return time.Time{}, ErrTokenExpired
+		}
+	}
+
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return time.Time{}, fmt.Errorf("%w: iss is not %q", ErrTokenClaims, a.Issuer)
+	}
+	if a.Audience != "" {
+		var ok bool
+		switch aud := claims["aud"].(type) {
+		case string:
+			ok = aud == a.Audience
+		case []any:
+			ok = slices.Contains(aud, any(a.Audience))
+		}
+		if !ok {
+			return time.Time{}, fmt.Errorf("%w: aud is not %q", ErrTokenClaims, a.Audience)
+		}
+	}
+	return exp.Add(a.Leeway), nil
+}
+
+// numericDate converts a NumericDate claim, seconds since the epoch
+func numericDate(v any) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
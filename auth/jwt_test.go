@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKeys are the keys of TestJWT, one for every algorithm
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	k := &testKeys{secret: []byte("0123456789abcdef0123456789abcdef")}
+	var err error
+	if k.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if k.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// jwks returns the JSON Web Key Set of the keys
+func (k *testKeys) jwks() []byte {
+	enc := base64.RawURLEncoding.EncodeToString
+	ecKey, _ := k.ec.PublicKey.Bytes()
+	b, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "k": enc(k.secret)},
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": enc(k.rsa.N.Bytes()), "e": enc(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": enc(ecKey[1:33]), "y": enc(ecKey[33:])},
+		{"kty": "RSA", "use": "enc"},
+	}})
+	return b
+}
+
+// sign returns a token of claims signed with alg
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := enc(header) + "." + enc(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, hash[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, hash[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + enc(sig)
+}
+
+func TestJWT(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, keys.jwks(), 0600)
+	ks, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &JWT{Keys: ks, Audience: "mqtt", Issuer: "https://id.example.com", Next: &Static{AllowAnonymous: true}}
+
+	now := time.Now()
+	exp := now.Add(time.Minute).Unix()
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{"sub": "app-1", "iss": "https://id.example.com", "aud": []string{"web", "mqtt"}, "exp": exp}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	other := newTestKeys(t)
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"HS256", keys.sign(t, "HS256", "hmac", claims(nil)), nil},
+		{"RS256", keys.sign(t, "RS256", "rsa", claims(nil)), nil},
+		{"ES256", keys.sign(t, "ES256", "", claims(nil)), nil},
+		{"aud string", keys.sign(t, "ES256", "ec", claims(map[string]any{"aud": "mqtt"})), nil},
+		{"nbf passed", keys.sign(t, "ES256", "ec", claims(map[string]any{"nbf": now.Unix() - 10})), nil},
+		{"unknown key", other.sign(t, "ES256", "ec", claims(nil)), ErrTokenInvalid},
+		{"kid of another key", keys.sign(t, "RS256", "hmac", claims(nil)), ErrTokenInvalid},
+		{"alg of another key", keys.sign(t, "HS256", "rsa", claims(nil)), ErrTokenInvalid},
+		{"alg none", strings.Join(strings.Split(keys.sign(t, "none", "", claims(nil)), ".")[:2], ".") + ".", ErrTokenInvalid},
+		{"expired", keys.sign(t, "HS256", "", claims(map[string]any{"exp": now.Unix() - 1})), ErrTokenExpired},
+		{"nbf ahead", keys.sign(t, "HS256", "", claims(map[string]any{"nbf": now.Unix() + 60})), ErrTokenExpired},
+		{"no exp", keys.sign(t, "HS256", "", claims(map[string]any{"exp": nil})), ErrTokenClaims},
+		{"wrong aud", keys.sign(t, "HS256", "", claims(map[string]any{"aud": "web"})), ErrTokenClaims},
+		{"wrong iss", keys.sign(t, "HS256", "", claims(map[string]any{"iss": "https://evil.example.com"})), ErrTokenClaims},
+		{"no sub", keys.sign(t, "HS256", "", claims(map[string]any{"sub": nil})), ErrTokenClaims},
+		{"not a token", "secret", ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		r := &Request{ClientID: "c", UserName: "ignored", Password: []byte(tt.token), HasUserName: true, HasPassword: true}
+		id, err := a.Authenticate(context.Background(), r)
+		if tt.err != nil {
+			if !errors.Is(err, ErrBadCredentials) || !errors.Is(err, tt.err) {
+				t.Errorf("%s: Authenticate() error = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Authenticate() error = %v", tt.name, err)
+			continue
+		}
+		if id.UserName != "app-1" || id.ClientID != "c" || id.Expires.Unix() != exp || id.Claims["iss"] != "https://id.example.com" {
+			t.Errorf("%s: Authenticate() = %+v", tt.name, id)
+		}
+	}
+
+	// A token which payload was changed is refused
+	parts := strings.Split(keys.sign(t, "RS256", "rsa", claims(nil)), ".")
+	parts[1] = strings.Split(keys.sign(t, "RS256", "rsa", claims(map[string]any{"sub": "admin"})), ".")[1]
+	r := &Request{ClientID: "c", Password: []byte(strings.Join(parts, ".")), HasPassword: true}
+	if _, err := a.Authenticate(context.Background(), r); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Authenticate() of a changed token error = %v, want %v", err, ErrTokenInvalid)
+	}
+
+	// Clients without Password are left to Next
+	id, err := a.Authenticate(context.Background(), &Request{ClientID: "c"})
+	if want := (&Identity{ClientID: "c"}); err != nil || !reflect.DeepEqual(id, want) {
+		t.Errorf("Authenticate(anonymous) = %+v, %v, want %+v", id, err, want)
+	}
+	a.Next = nil
+	if _, err := a.Authenticate(context.Background(), &Request{ClientID: "c"}); err != ErrNotAuthorized {
+		t.Errorf("Authenticate(anonymous) without Next error = %v, want %v", err, ErrNotAuthorized)
+	}
+}
+
+func TestParseJWKSErrors(t *testing.T) {
+	tests := []struct {
+		data, err string
+	}{
+		{`{"keys": [{"kty": "oct", "k": ""}]}`, "keys[0]: invalid key: empty k"},
+		{`{"keys": [{"kty": "oct", "k": "c2VjcmV0", "alg": "RS256"}]}`, `keys[0]: invalid key: alg "RS256" does not fit kty "oct"`},
+		{`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}, {"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`, "keys[1]: invalid key: unsupported RSA key size or exponent"},
+		{`{"keys": [{"kty": "EC", "crv": "P-384"}]}`, `keys[0]: invalid key: unsupported curve "P-384"`},
+		{`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQAB", "y": "AQAB"}]}`, "keys[0]: invalid key: x and y must be 32 bytes"},
+		{`{"keys": [{"kty": "OKP"}]}`, `keys[0]: invalid key: unsupported kty "OKP"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseJWKS([]byte(tt.data))
+		if err == nil || err.Error() != tt.err || !errors.Is(err, ErrKeyInvalid) {
+			t.Errorf("ParseJWKS(%s) error = %v, want %s", tt.data, err, tt.err)
+		}
+	}
+}
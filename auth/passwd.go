@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultIterations is the number of PBKDF2 iterations of a password hash
+	DefaultIterations = 600000
+
+	// hashScheme prefixes a password hash with the algorithm it was made with
+	hashScheme = "pbkdf2-sha256"
+
+	saltLen = 16
+	keyLen  = 32
+)
+
+var (
+	// ErrHashInvalid indicates a password hash which is not in the format written by
+	// HashPassword
+	ErrHashInvalid = errors.New("invalid password hash")
+
+	// ErrUserNameInvalid indicates a User Name which cannot be kept in a password
+	// file: an empty one, or one holding a colon or a line break
+	ErrUserNameInvalid = errors.New("invalid user name")
+)
+
+// HashPassword returns a salted PBKDF2-SHA256 hash of password in the form
+// pbkdf2-sha256$iterations$salt$key, salt and key being base64 encoded. Zero
+// iterations means DefaultIterations.
+func HashPassword(password string, iterations int) (string, error) {
+	if iterations <= 0 {
+		iterations = DefaultIterations
+	}
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, keyLen)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, iterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// CheckPassword reports whether password matches hash, a hash returned by
+// HashPassword
+func CheckPassword(hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return false, ErrHashInvalid
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false, ErrHashInvalid
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false, ErrHashInvalid
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false, ErrHashInvalid
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
+
+// ValidUserName reports whether name can be kept in a password file
+func ValidUserName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ":\r\n")
+}
+
+// ReadPasswordFile reads a password file of username:hash lines into a map from
+// User Name to hash. Empty lines and lines starting with # are skipped. Errors
+// name the line they were found on.
+func ReadPasswordFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := map[string]string{}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, ErrUserNameInvalid)
+		}
+		if strings.Count(hash, "$") != 3 || !strings.HasPrefix(hash, hashScheme+"$") {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, ErrHashInvalid)
+		}
+		if _, ok := users[name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate user %q", path, n, name)
+		}
+		users[name] = hash
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// WritePasswordFile writes users, a map from User Name to hash, to a password file
+// readable by its owner only. The file is replaced at once, so that a Server
+// reading it never sees it half written.
+func WritePasswordFile(path string, users map[string]string) error {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		if !ValidUserName(name) {
+			return fmt.Errorf("%q: %w", name, ErrUserNameInvalid)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s:%s\n", name, users[name])
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
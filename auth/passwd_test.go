@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("verysecret", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Errorf("HashPassword() = %q, want pbkdf2-sha256$1000$ prefix", hash)
+	}
+	if again, _ := HashPassword("verysecret", 1000); again == hash {
+		t.Error("HashPassword() returned the same hash twice, salt is not random")
+	}
+
+	tests := []struct {
+		hash, password string
+		ok             bool
+		err            error
+	}{
+		{hash, "verysecret", true, nil},
+		{hash, "verysecreT", false, nil},
+		{hash, "", false, nil},
+		{"md5$1000$c2FsdA$a2V5", "verysecret", false, ErrHashInvalid},
+		{"pbkdf2-sha256$0$c2FsdA$a2V5", "verysecret", false, ErrHashInvalid},
+		{"pbkdf2-sha256$1000$!!$a2V5", "verysecret", false, ErrHashInvalid},
+		{"pbkdf2-sha256$1000$c2FsdA", "verysecret", false, ErrHashInvalid},
+	}
+	for _, tt := range tests {
+		ok, err := CheckPassword(tt.hash, tt.password)
+		if ok != tt.ok || err != tt.err {
+			t.Errorf("CheckPassword(%q, %q) = %v, %v, want %v, %v", tt.hash, tt.password, ok, err, tt.ok, tt.err)
+		}
+	}
+}
+
+func TestWritePasswordFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	users := map[string]string{
+		"bob":   "pbkdf2-sha256$1000$c2FsdA$a2V5",
+		"alice": "pbkdf2-sha256$1000$c2FsdA$a2V6",
+	}
+	if err := WritePasswordFile(path, users); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if want := "alice:pbkdf2-sha256$1000$c2FsdA$a2V6\nbob:pbkdf2-sha256$1000$c2FsdA$a2V5\n"; string(b) != want {
+		t.Errorf("password file = %q, want %q", b, want)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Errorf("password file mode = %v, want 0600", fi.Mode().Perm())
+	}
+
+	got, err := ReadPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("ReadPasswordFile() = %v, want %v", got, users)
+	}
+
+	if err := WritePasswordFile(path, map[string]string{"a:b": "x"}); !errors.Is(err, ErrUserNameInvalid) {
+		t.Errorf("WritePasswordFile() error = %v, want %v", err, ErrUserNameInvalid)
+	}
+}
+
+func TestReadPasswordFileErrors(t *testing.T) {
+	tests := []struct {
+		contents string
+		err      string
+	}{
+		{"# users\n\nalice:pbkdf2-sha256$1$c2FsdA$a2V5\n", ""},
+		{"alice:pbkdf2-sha256$1$c2FsdA$a2V5\nbob\n", "passwd:2: invalid user name"},
+		{"\n:pbkdf2-sha256$1$c2FsdA$a2V5\n", "passwd:2: invalid user name"},
+		{"alice:secret\n", "passwd:1: invalid password hash"},
+		{"alice:pbkdf2-sha256$1$c2FsdA$a2V5\nalice:pbkdf2-sha256$1$c2FsdA$a2V5\n", `passwd:2: duplicate user "alice"`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "passwd")
+		os.WriteFile(path, []byte(tt.contents), 0600)
+		_, err := ReadPasswordFile(path)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("ReadPasswordFile(%q) error = %v", tt.contents, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+			t.Errorf("ReadPasswordFile(%q) error = %v, want %s", tt.contents, err, tt.err)
+		}
+	}
+}
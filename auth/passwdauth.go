@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultCheckInterval is how often a PasswordFile looks for changes of its file
+const DefaultCheckInterval = time.Second
+
+// ErrBusy indicates a PasswordFile already checking MaxChecks passwords. The
+// Server answers with CONNACK return code 0x03 (Server unavailable).
+var ErrBusy = errors.New("too many password checks at a time")
+
+// PasswordFile accepts the Clients presenting a User Name and Password of a
+// password file written by WritePasswordFile, such as by mammoth passwd. Changes of
+// the file are picked up by the next Authenticate call after CheckInterval.
+type PasswordFile struct {
+	// AllowAnonymous accepts Clients without a User Name
+	AllowAnonymous bool
+
+	// CheckInterval is how often the file is checked for changes. Zero means
+	// DefaultCheckInterval.
+	CheckInterval time.Duration
+
+	// OnError, if not nil, is called when the file changed but cannot be read. The
+	// users read before are kept until it can.
+	OnError func(err error)
+
+	// MaxChecks is the number of passwords checked at a time, as each check
+	// takes the iterations of its hash. Clients beyond it fail with ErrBusy
+	// rather than waiting, so that a flood of CONNECT Packets cannot take every
+	// CPU. Zero means GOMAXPROCS.
+	MaxChecks int
+
+	path string
+
+	// checks holds a token for every password being checked
+	checksOnce sync.Once
+	checks     chan struct{}
+
+	mu    sync.RWMutex
+	users map[string]string
+
+	// file describes the file the users were read from, checked the last time it
+	// was compared to the file on disk
+	file    os.FileInfo
+	checked time.Time
+}
+
+// NewPasswordFile returns a PasswordFile reading the file at path
+func NewPasswordFile(path string) (*PasswordFile, error) {
+	a := &PasswordFile{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the file again. The users read before are kept if it fails.
+func (a *PasswordFile) Reload() error {
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	users, err := ReadPasswordFile(a.path)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = users
+	a.file = fi
+	a.checked = time.Now()
+	return nil
+}
+
+// refresh reloads the file if CheckInterval passed and it changed since it was read
+func (a *PasswordFile) refresh() {
+	interval := a.CheckInterval
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+
+	a.mu.Lock()
+	if time.Since(a.checked) < interval {
+		a.mu.Unlock()
+		return
+	}
+	a.checked = time.Now()
+	old := a.file
+	a.mu.Unlock()
+
+	// Replacing the file, as WritePasswordFile does, changes its inode
+	fi, err := os.Stat(a.path)
+	if err == nil && os.SameFile(fi, old) && fi.ModTime().Equal(old.ModTime()) && fi.Size() == old.Size() {
+		return
+	}
+	if err == nil {
+		err = a.Reload()
+	}
+	if err != nil && a.OnError != nil {
+		a.OnError(err)
+	}
+}
+
+// Authenticate implements Authenticator
+func (a *PasswordFile) Authenticate(ctx context.Context, r *Request) (*Identity, error) {
+	if !r.HasUserName {
+		if a.AllowAnonymous {
+			return identityOf(r), nil
+		}
+		return nil, ErrNotAuthorized
+	}
+
+	a.refresh()
+	a.mu.RLock()
+	hash, ok := a.users[r.UserName]
+	if !ok {
+		// Hashing anyway keeps unknown User Names from answering faster than known
+		// ones
+		for _, h := range a.users {
+			hash = h
+			break
+		}
+	}
+	a.mu.RUnlock()
+
+	if hash == "" || !r.HasPassword {
+		return nil, ErrBadCredentials
+	}
+
+	a.checksOnce.Do(func() {
+		n := a.MaxChecks
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		a.checks = make(chan struct{}, n)
+	})
+	select {
+	case a.checks <- struct{}{}:
+	default:
+		return nil, ErrBusy
+	}
+	match, err := CheckPassword(hash, string(r.Password))
+	<-a.checks
+	if err != nil {
+		return nil, err
+	}
+	if !ok || !match {
+		return nil, ErrBadCredentials
+	}
+	return identityOf(r), nil
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeUsers writes a password file holding users, a map from User Name to
+// Password, hashed with few iterations
+func writeUsers(t *testing.T, path string, users map[string]string) {
+	hashes := map[string]string{}
+	for name, password := range users {
+		hash, err := HashPassword(password, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[name] = hash
+	}
+	if err := WritePasswordFile(path, hashes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func login(name, password string) *Request {
+	return &Request{ClientID: "c", UserName: name, Password: []byte(password), HasUserName: true, HasPassword: true}
+}
+
+func TestPasswordFileAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	writeUsers(t, path, map[string]string{"alice": "secret", "bob": "other"})
+
+	a, err := NewPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		r   *Request
+		err error
+	}{
+		{login("alice", "secret"), nil},
+		{login("bob", "other"), nil},
+		{login("alice", "other"), ErrBadCredentials},
+		{login("mallory", "secret"), ErrBadCredentials},
+		{&Request{ClientID: "c", UserName: "alice", HasUserName: true}, ErrBadCredentials},
+		{&Request{ClientID: "c"}, ErrNotAuthorized},
+	}
+	for _, tt := range tests {
+		id, err := a.Authenticate(context.Background(), tt.r)
+		if err != tt.err {
+			t.Errorf("Authenticate(%q, %q) error = %v, want %v", tt.r.UserName, tt.r.Password, err, tt.err)
+		}
+		if err == nil && id.UserName != tt.r.UserName {
+			t.Errorf("Authenticate(%q) UserName = %q", tt.r.UserName, id.UserName)
+		}
+	}
+
+	a.AllowAnonymous = true
+	if _, err := a.Authenticate(context.Background(), &Request{ClientID: "c"}); err != nil {
+		t.Errorf("Authenticate(anonymous) error = %v", err)
+	}
+}
+
+func TestPasswordFileMaxChecks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	writeUsers(t, path, map[string]string{"alice": "secret"})
+	a, err := NewPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.MaxChecks = 1
+	if _, err := a.Authenticate(context.Background(), login("alice", "secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another password is being checked
+	a.checks <- struct{}{}
+	for _, r := range []*Request{login("alice", "secret"), login("mallory", "secret")} {
+		if _, err := a.Authenticate(context.Background(), r); err != ErrBusy {
+			t.Errorf("Authenticate(%q) error = %v, want %v", r.UserName, err, ErrBusy)
+		}
+	}
+	<-a.checks
+	if _, err := a.Authenticate(context.Background(), login("alice", "secret")); err != nil {
+		t.Errorf("Authenticate() after the check error = %v", err)
+	}
+}
+
+func TestPasswordFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	writeUsers(t, path, map[string]string{"alice": "secret"})
+
+	a, err := NewPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.CheckInterval = time.Nanosecond
+	errs := make(chan error, 1)
+	a.OnError = func(err error) { errs <- err }
+
+	writeUsers(t, path, map[string]string{"alice": "changed"})
+	if _, err := a.Authenticate(context.Background(), login("alice", "changed")); err != nil {
+		t.Errorf("Authenticate() with the new password error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("alice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(context.Background(), login("alice", "changed")); err != nil {
+		t.Errorf("Authenticate() after an invalid change error = %v, want the users kept", err)
+	}
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("OnError(nil)")
+		}
+	default:
+		t.Error("OnError was not called for an invalid file")
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+)
+
+// PeerCred holds the credentials of a process connected over a unix socket, as
+// they were when it connected
+type PeerCred struct {
+	PID int
+	UID int
+	GID int
+}
+
+// PeerUID authenticates the Clients connected over a unix socket by the user ID of
+// their process, which becomes their User Name in decimal, such as "1000". Their
+// User Name and Password are ignored, the filesystem permissions of the socket
+// deciding who may connect. The other Clients are authenticated with Next.
+type PeerUID struct {
+	// Next authenticates the Clients whose process credentials are unknown. Nil
+	// means AllowAll.
+	Next Authenticator
+}
+
+// Authenticate implements Authenticator
+func (a *PeerUID) Authenticate(ctx context.Context, r *Request) (*Identity, error) {
+	if r.Peer == nil {
+		next := a.Next
+		if next == nil {
+			next = AllowAll{}
+		}
+		return next.Authenticate(ctx, r)
+	}
+	return &Identity{UserName: strconv.Itoa(r.Peer.UID), ClientID: r.ClientID}, nil
+}
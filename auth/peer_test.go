@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPeerUID(t *testing.T) {
+	a := &PeerUID{Next: NewStatic(map[string]string{"alice": "secret"})}
+	tests := []struct {
+		r    Request
+		want *Identity
+		err  error
+	}{
+		{Request{ClientID: "agent", UserName: "alice", HasUserName: true, Peer: &PeerCred{PID: 42, UID: 1000, GID: 100}}, &Identity{UserName: "1000", ClientID: "agent"}, nil},
+		{Request{ClientID: "root", Peer: &PeerCred{}}, &Identity{UserName: "0", ClientID: "root"}, nil},
+		{Request{ClientID: "c", UserName: "alice", Password: []byte("secret"), HasUserName: true, HasPassword: true}, &Identity{UserName: "alice", ClientID: "c"}, nil},
+		{Request{ClientID: "c", UserName: "alice", Password: []byte("wrong"), HasUserName: true, HasPassword: true}, nil, ErrBadCredentials},
+	}
+	for _, tt := range tests {
+		id, err := a.Authenticate(context.Background(), &tt.r)
+		if err != tt.err || !reflect.DeepEqual(id, tt.want) {
+			t.Errorf("Authenticate(%+v) = %+v, %v, want %+v, %v", tt.r, id, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWebhookTimeout bounds a request of a Webhook without Timeout
+	DefaultWebhookTimeout = 5 * time.Second
+
+	// maxCacheEntries is the number of answers a Webhook keeps before dropping the
+	// expired ones
+	maxCacheEntries = 4096
+)
+
+// Webhook asks an HTTP endpoint whether Clients may connect, publish and
+// subscribe. It POSTs a JSON object describing the request:
+//
+//	{"action": "connect", "client_id": "c1", "username": "alice", "password": "secret", "remote_addr": "192.0.2.1:51234"}
+//	{"action": "publish", "client_id": "c1", "username": "alice", "remote_addr": "192.0.2.1:51234", "topic": "sensors/alice/temp"}
+//	{"action": "subscribe", "client_id": "c1", "username": "alice", "remote_addr": "192.0.2.1:51234", "topic": "sensors/#"}
+//
+// A 2xx status allows the request. 401 refuses it with ErrBadCredentials, 403
+// with ErrNotAuthorized. Any other status, or no answer within Timeout, is a
+// failure: the request is allowed if FailOpen is set and refused otherwise, a
+// connecting Client with CONNACK return code 0x03 (Server unavailable).
+type Webhook struct {
+	// URL is the endpoint the requests are POSTed to
+	URL string
+
+	// Client sends the requests. Nil means http.DefaultClient.
+	Client *http.Client
+
+	// Timeout bounds each request. Zero means DefaultWebhookTimeout.
+	Timeout time.Duration
+
+	// CacheTTL is how long an answer is reused for the same request, failures
+	// excepted. Zero disables caching.
+	CacheTTL time.Duration
+
+	// FailOpen allows the requests the endpoint failed to answer
+	FailOpen bool
+
+	// OnError, if not nil, is called with every failure FailOpen ignored
+	OnError func(err error)
+
+	// AllowAnonymous accepts Clients without a User Name without asking the
+	// endpoint. Otherwise they are refused with ErrNotAuthorized.
+	AllowAnonymous bool
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]webhookAnswer
+}
+
+// webhookRequest is the body POSTed to the endpoint
+type webhookRequest struct {
+	Action     string `json:"action"`
+	ClientID   string `json:"client_id"`
+	UserName   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Topic      string `json:"topic,omitempty"`
+}
+
+// webhookAnswer is a cached answer of the endpoint
+type webhookAnswer struct {
+	err     error
+	expires time.Time
+}
+
+// Authenticate implements Authenticator
+func (w *Webhook) Authenticate(ctx context.Context, r *Request) (*Identity, error) {
+	if !r.HasUserName {
+		if w.AllowAnonymous {
+			return identityOf(r), nil
+		}
+		return nil, ErrNotAuthorized
+	}
+
+	req := webhookRequest{Action: "connect", ClientID: r.ClientID, UserName: r.UserName, Password: string(r.Password)}
+	if r.RemoteAddr != nil {
+		req.RemoteAddr = r.RemoteAddr.String()
+	}
+	if err := w.ask(ctx, &req); err != nil {
+		return nil, err
+	}
+	return identityOf(r), nil
+}
+
+// Authorize implements Authorizer
+func (w *Webhook) Authorize(ctx context.Context, id *Identity, action Action, topic string) error {
+	req := webhookRequest{Action: action.String(), ClientID: id.ClientID, UserName: id.UserName, Topic: topic}
+	if id.RemoteAddr != nil {
+		req.RemoteAddr = id.RemoteAddr.String()
+	}
+	err := w.ask(ctx, &req)
+	if err == ErrBadCredentials {
+		return ErrNotAuthorized
+	}
+	return err
+}
+
+// ask POSTs req to the endpoint, or takes the answer from the cache. It returns
+// nil if req is allowed.
+func (w *Webhook) ask(ctx context.Context, req *webhookRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	// The key is hashed so that Passwords are not kept in memory
+	key := sha256.Sum256(body)
+	if w.CacheTTL > 0 {
+		w.mu.Lock()
+		a, ok := w.cache[key]
+		w.mu.Unlock()
+		if ok && time.Now().Before(a.expires) {
+			return a.err
+		}
+	}
+
+	err = w.post(ctx, body)
+	if err != nil && err != ErrBadCredentials && err != ErrNotAuthorized {
+		if w.FailOpen {
+			if w.OnError != nil {
+				w.OnError(err)
+			}
+			return nil
+		}
+		return err
+	}
+
+	if w.CacheTTL > 0 {
+		w.remember(key, err)
+	}
+	return err
+}
+
+// post sends body to the endpoint and converts its status to an error
+func (w *Webhook) post(ctx context.Context, body []byte) error {
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(hr)
+	if err != nil {
+		return err
+	}
+	// Reading the body lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		return ErrBadCredentials
+	case resp.StatusCode == http.StatusForbidden:
+		return ErrNotAuthorized
+	}
+	return fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+}
+
+// remember caches the answer err to the request hashed to key
+func (w *Webhook) remember(key [sha256.Size]byte, err error) {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cache == nil {
+		w.cache = map[[sha256.Size]byte]webhookAnswer{}
+	}
+	if len(w.cache) >= maxCacheEntries {
+		for k, a := range w.cache {
+			if !now.Before(a.expires) {
+				delete(w.cache, k)
+			}
+		}
+	}
+	if len(w.cache) < maxCacheEntries {
+		w.cache[key] = webhookAnswer{err: err, expires: now.Add(w.CacheTTL)}
+	}
+}
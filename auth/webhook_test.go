@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var last atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		last.Store(req)
+		switch {
+		case req["action"] == "connect" && req["username"] == "alice" && req["password"] != "secret":
+			w.WriteHeader(http.StatusUnauthorized)
+		case req["username"] == "mallory" || req["topic"] == "secret":
+			w.WriteHeader(http.StatusForbidden)
+		case req["username"] == "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	w := &Webhook{URL: srv.URL}
+	r := &Request{ClientID: "c", UserName: "alice", Password: []byte("secret"), HasUserName: true, HasPassword: true, RemoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}}
+	id, err := w.Authenticate(context.Background(), r)
+	if want := (&Identity{UserName: "alice", ClientID: "c"}); err != nil || !reflect.DeepEqual(id, want) {
+		t.Errorf("Authenticate() = %+v, %v, want %+v", id, err, want)
+	}
+	want := map[string]string{"action": "connect", "client_id": "c", "username": "alice", "password": "secret", "remote_addr": "192.0.2.1:1234"}
+	if got := last.Load(); !reflect.DeepEqual(got, want) {
+		t.Errorf("request = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		user, password string
+		err            error
+	}{
+		{"alice", "wrong", ErrBadCredentials},
+		{"mallory", "", ErrNotAuthorized},
+		{"broken", "", nil},
+	}
+	for _, tt := range tests {
+		r := &Request{ClientID: "c", UserName: tt.user, Password: []byte(tt.password), HasUserName: true, HasPassword: true}
+		_, err := w.Authenticate(context.Background(), r)
+		if tt.err != nil && err != tt.err || tt.err == nil && err == nil {
+			t.Errorf("Authenticate(%s) error = %v, want %v", tt.user, err, tt.err)
+		}
+	}
+	if _, err := w.Authenticate(context.Background(), &Request{ClientID: "c"}); err != ErrNotAuthorized {
+		t.Errorf("Authenticate(anonymous) error = %v, want %v", err, ErrNotAuthorized)
+	}
+
+	alice := &Identity{UserName: "alice", ClientID: "c"}
+	if err := w.Authorize(context.Background(), alice, Subscribe, "news/#"); err != nil {
+		t.Errorf("Authorize(news/#) = %v", err)
+	}
+	want = map[string]string{"action": "subscribe", "client_id": "c", "username": "alice", "topic": "news/#"}
+	if got := last.Load(); !reflect.DeepEqual(got, want) {
+		t.Errorf("request = %v, want %v", got, want)
+	}
+	if err := w.Authorize(context.Background(), alice, Publish, "secret"); err != ErrNotAuthorized {
+		t.Errorf("Authorize(secret) = %v, want %v", err, ErrNotAuthorized)
+	}
+
+	// Failures are refused unless FailOpen
+	broken := &Identity{UserName: "broken", ClientID: "c"}
+	if err := w.Authorize(context.Background(), broken, Publish, "a"); err == nil || errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Authorize() failing = %v", err)
+	}
+	var failures int
+	w.FailOpen = true
+	w.OnError = func(error) { failures++ }
+	if err := w.Authorize(context.Background(), broken, Publish, "a"); err != nil || failures != 1 {
+		t.Errorf("Authorize() failing open = %v, %d failures", err, failures)
+	}
+}
+
+func TestWebhookCache(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["topic"] == "secret" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	w := &Webhook{URL: srv.URL, CacheTTL: 100 * time.Millisecond}
+	id := &Identity{UserName: "alice", ClientID: "c"}
+	for i := 0; i < 3; i++ {
+		w.Authorize(context.Background(), id, Publish, "a")
+		if err := w.Authorize(context.Background(), id, Publish, "secret"); err != ErrNotAuthorized {
+			t.Errorf("Authorize(secret) from the cache = %v, want %v", err, ErrNotAuthorized)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	w.Authorize(context.Background(), id, Publish, "a")
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d requests after the TTL, want 3", n)
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	w := &Webhook{URL: srv.URL, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	err := w.Authorize(context.Background(), &Identity{ClientID: "c"}, Publish, "a")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Authorize() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Authorize() took %v", d)
+	}
+}
@@ -1,8 +1,882 @@
 package client
 
-// Client connects to server
-type Client struct{}
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
-func (c *Client) Connect() {
+	"github.com/Den3/mammoth/message"
+)
 
+// DefaultConnectTimeout is how long Connect waits for CONNACK when neither the
+// Client nor the context sets a limit
+const DefaultConnectTimeout = 10 * time.Second
+
+// DefaultMaxQueued is the number of QoS 1 and QoS 2 messages a disconnected Client
+// queues when MaxQueued is zero
+const DefaultMaxQueued = 1000
+
+var (
+	// ErrNotConnected indicates the Client has no Network Connection to a Server
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrAlreadyConnected indicates Connect was called on a connected Client
+	ErrAlreadyConnected = errors.New("already connected")
+
+	// ErrFirstPacketNotConnack indicates the Server sent something else than CONNACK
+	// in response to CONNECT
+	ErrFirstPacketNotConnack = errors.New("first packet is not CONNACK")
+
+	// ErrUnexpectedPacket indicates the Server sent a packet the Client must not receive
+	ErrUnexpectedPacket = errors.New("unexpected packet")
+
+	// ErrSchemeInvalid indicates the broker address has a scheme the Client cannot dial
+	ErrSchemeInvalid = errors.New("unsupported scheme")
+)
+
+// ReasonError is a failure reported by the Server: a non-zero Connect Return code
+// or CONNACK Reason Code of 0x80 or greater, or an MQTT 5.0 DISCONNECT
+type ReasonError struct {
+	// Type is the Control Packet type which carried Code
+	Type byte
+	Code byte
+}
+
+func (e *ReasonError) Error() string {
+	return fmt.Sprintf("%s reason code 0x%02x", message.PacketName(e.Type), e.Code)
+}
+
+// Client connects to a Server. The zero value is ready to Connect.
+//
+// QoS 1 and QoS 2 messages which are not acknowledged when the Network Connection
+// is lost are sent again once the Client connects with CleanSession set to 0, and
+// discarded when it connects with CleanSession set to 1. QoS 1 and QoS 2 messages
+// published while the Network Connection is lost are queued and sent in order
+// after reconnecting.
+type Client struct {
+	// TLSConfig is used to connect over TLS. It is also used for addresses without
+	// a scheme when it is set. If it is nil, tls:// addresses use the defaults of
+	// crypto/tls.
+	TLSConfig *tls.Config
+
+	// ConnectTimeout limits how long Connect waits for CONNACK. Zero means
+	// DefaultConnectTimeout unless the context passed to Connect has a deadline.
+	ConnectTimeout time.Duration
+
+	// OnPublish is called from the read loop with every Application Message the
+	// Server sends. It must not block for long, as no other packet is read meanwhile.
+	OnPublish func(p *message.PublishMessage)
+
+	// AutoReconnect makes the Client connect again, with the address and CONNECT
+	// Packet given to Connect, when the Network Connection is lost
+	AutoReconnect bool
+
+	// MinReconnectDelay and MaxReconnectDelay bound the exponential backoff between
+	// attempts to reconnect. Zero means DefaultMinReconnectDelay and
+	// DefaultMaxReconnectDelay.
+	MinReconnectDelay time.Duration
+	MaxReconnectDelay time.Duration
+
+	// OnConnectionLost is called with the error which ended the Network Connection,
+	// unless it was closed by Disconnect
+	OnConnectionLost func(err error)
+
+	// OnReconnect is called with the CONNACK once the Client reconnected and, if the
+	// Server did not resume the Session, subscribed again
+	OnReconnect func(connack *message.ConnackMessage)
+
+	// Store keeps QoS 1 and QoS 2 messages and their Packet Identifiers until their
+	// delivery completes. Messages found in it by Connect are sent as if they were
+	// published before the process restarted. If it is nil, messages are only kept
+	// in memory.
+	Store Store
+
+	// MaxQueued limits the number of QoS 1 and QoS 2 messages queued while the
+	// Network Connection is lost. Zero means DefaultMaxQueued.
+	MaxQueued int
+
+	// PingTimeout limits how long the Client waits for PINGRESP before it treats
+	// the Network Connection as lost. Zero means DefaultPingTimeout.
+	PingTimeout time.Duration
+
+	// lastSent is when a packet was last written, in Unix nanoseconds
+	lastSent int64
+
+	mu           sync.Mutex
+	conn         net.Conn
+	version      byte
+	cleanSession bool
+	done         chan struct{}
+	err          error
+
+	// pong receives a value for every PINGRESP
+	pong chan struct{}
+
+	// nextID is the last Packet Identifier used by the Client
+	nextID uint16
+
+	// seq counts the packets tracked by tokens
+	seq uint64
+
+	// tokens are the deliveries waiting for a response by Packet Identifier
+	tokens map[uint16]*Token
+
+	// received are Packet Identifiers of QoS 2 messages received from the Server
+	// and waiting for PUBREL
+	received map[uint16]bool
+
+	// subscriptions are the Topic Filters accepted by the Server with their
+	// Subscription Options
+	subscriptions map[string]byte
+
+	// loaded tells whether the entries of Store were loaded
+	loaded bool
+
+	// addr and connect are the arguments of Connect, used to reconnect
+	addr    string
+	connect *message.ConnectMessage
+
+	// stop is closed by Disconnect to end reconnecting
+	stop         chan struct{}
+	reconnecting bool
+
+	// wmu serializes writes to conn
+	wmu sync.Mutex
+}
+
+// Connect opens a Network Connection to addr, sends connect and waits for CONNACK.
+// addr is either host:port or a URL such as tcp://host:port or tls://host:port.
+func (c *Client) Connect(ctx context.Context, addr string, connect *message.ConnectMessage) (*message.ConnackMessage, error) {
+	c.mu.Lock()
+	if c.conn != nil || c.reconnecting {
+		c.mu.Unlock()
+		return nil, ErrAlreadyConnected
+	}
+	c.addr = addr
+	c.connect = connect
+	c.stop = make(chan struct{})
+	c.subscriptions = map[string]byte{}
+	c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c.connectTo(ctx, addr, connect)
+}
+
+// load tracks the entries of Store the first time the Client connects
+func (c *Client) load() error {
+	if c.Store == nil || c.loaded {
+		return nil
+	}
+	entries, err := c.Store.Entries()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens == nil {
+		c.tokens = map[uint16]*Token{}
+	}
+	for _, e := range entries {
+		var pid []byte
+		switch m := e.Packet.(type) {
+		case *message.PublishMessage:
+			pid = m.PacketID()
+		case *message.PubrelMessage:
+			pid = m.PacketID()
+		}
+		if len(pid) != 2 {
+			continue
+		}
+		id := binary.BigEndian.Uint16(pid)
+		if _, ok := c.tokens[id]; ok {
+			continue
+		}
+
+		t := newToken()
+		t.msg = e.Packet
+		t.seq = e.Seq
+		t.sent = e.Sent
+		c.tokens[id] = t
+		if e.Seq > c.seq {
+			c.seq = e.Seq
+		}
+	}
+	c.loaded = true
+	return nil
+}
+
+// connectTo opens a Network Connection to addr and starts the Session of connect
+func (c *Client) connectTo(ctx context.Context, addr string, connect *message.ConnectMessage) (*message.ConnackMessage, error) {
+	c.mu.Lock()
+	if c.conn != nil {
+		c.mu.Unlock()
+		return nil, ErrAlreadyConnected
+	}
+	c.mu.Unlock()
+
+	network, address, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok || c.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.connectTimeout())
+		defer cancel()
+	}
+
+	conn, err := c.dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	connack, err := handshake(ctx, conn, connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	resend, lost := c.resume(conn, connect, done)
+	for _, t := range lost {
+		c.forget(t)
+		t.complete(nil, ErrSessionLost)
+	}
+	go c.readLoop(conn, connect.Version(), done)
+	if keepAlive := keepAliveOf(connect, connack); keepAlive > 0 {
+		go c.pinger(conn, connect.Version(), keepAlive, c.pong, done)
+	}
+
+	// When a Client reconnects with CleanSession set to 0, it MUST re-send any
+	// unacknowledged PUBLISH Packets (where QoS > 0) and PUBREL Packets using their
+	// original Packet Identifiers [MQTT-4.4.0-1]. Messages queued while
+	// disconnected follow them.
+	for _, t := range resend {
+		if err := c.send(ctx, t); err != nil {
+			// conn is the Client's already, so that it must be closed for the failure
+			// to leave the Client disconnected
+			c.close(conn, err)
+			return nil, err
+		}
+	}
+	return connack, nil
+}
+
+// resume makes conn the Network Connection of the Client. It returns the packets
+// to send, which are the packets never sent and, if connect continues the Session,
+// the unacknowledged ones. The deliveries discarded with the Session are returned
+// as well.
+func (c *Client) resume(conn net.Conn, connect *message.ConnectMessage, done chan struct{}) ([]*Token, []*Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn = conn
+	c.version = connect.Version()
+	c.cleanSession = connect.CleanSession() == 1
+	c.done = done
+	c.err = nil
+	c.pong = make(chan struct{}, 1)
+	atomic.StoreInt64(&c.lastSent, time.Now().UnixNano())
+	if c.tokens == nil {
+		c.tokens = map[uint16]*Token{}
+	}
+
+	var lost []*Token
+	if c.cleanSession || c.received == nil {
+		c.received = map[uint16]bool{}
+	}
+	var resend []*Token
+	for id, t := range c.tokens {
+		if t.msg == nil || (c.cleanSession && t.sent) {
+			lost = append(lost, t)
+			delete(c.tokens, id)
+			continue
+		}
+		resend = append(resend, t)
+	}
+
+	sort.Slice(resend, func(i, j int) bool {
+		return resend[i].seq < resend[j].seq
+	})
+	return resend, lost
+}
+
+// connectTimeout returns how long to wait for CONNACK
+func (c *Client) connectTimeout() time.Duration {
+	if c.ConnectTimeout > 0 {
+		return c.ConnectTimeout
+	}
+	return DefaultConnectTimeout
+}
+
+// handshake writes connect and reads CONNACK before ctx is done
+func handshake(ctx context.Context, conn net.Conn, connect *message.ConnectMessage) (*message.ConnackMessage, error) {
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	if err := message.WritePacket(conn, connect); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	m, err := message.ReadPacket(conn, connect.Version())
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	connack, ok := m.(*message.ConnackMessage)
+	if !ok {
+		return nil, ErrFirstPacketNotConnack
+	}
+
+	// A 3.1.1 Connect Return code other than 0 and an MQTT 5.0 Reason Code of
+	// 0x80 or greater both refuse the connection
+	rc := connack.ConnectReturnCode()
+	if (connect.Version() == message.Version5 && rc >= 0x80) || (connect.Version() != message.Version5 && rc != 0) {
+		return nil, &ReasonError{Type: message.CONNACK, Code: rc}
+	}
+
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	return connack, nil
+}
+
+// ctxErr prefers the error of ctx to err once ctx is done, so that a timeout is
+// not reported as an I/O error on the Network Connection
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// parseAddr returns the network and address to dial for a broker address. The
+// network is "tls" for the schemes of MQTT over TLS.
+func parseAddr(addr string) (string, string, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		// host:port is parsed as a URL with scheme host
+		return "tcp", addr, nil
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		return "tcp", u.Host, nil
+	case "tls", "ssl", "mqtts":
+		return "tls", u.Host, nil
+	}
+	return "", "", ErrSchemeInvalid
+}
+
+// dial opens a Network Connection, using TLS for the "tls" network or when
+// TLSConfig is set
+func (c *Client) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if network == "tls" || c.TLSConfig != nil {
+		d := tls.Dialer{Config: c.TLSConfig}
+		return d.DialContext(ctx, "tcp", address)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+// Done returns a channel which is closed when the Network Connection is lost or closed
+func (c *Client) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// Err returns the error which ended the last Network Connection, or nil if it is
+// still open or was closed by Disconnect
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Publish sends an Application Message and waits until its delivery completes:
+// once written for QoS 0, on PUBACK for QoS 1 and on PUBCOMP for QoS 2.
+func (c *Client) Publish(ctx context.Context, p *message.PublishMessage) error {
+	return c.PublishAsync(ctx, p).Wait(ctx)
+}
+
+// PublishAsync sends an Application Message and returns a Token which completes
+// with its delivery. The Client assigns the Packet Identifier of QoS 1 and QoS 2
+// messages, and keeps them until they are acknowledged, so p must not be modified
+// until the Token is done. QoS 1 and QoS 2 messages published while the Network
+// Connection is lost are queued until the Client reconnects.
+func (c *Client) PublishAsync(ctx context.Context, p *message.PublishMessage) *Token {
+	if p.QoS() == 0 {
+		t := newToken()
+		t.complete(nil, c.write(ctx, p))
+		return t
+	}
+
+	t, id, err := c.track(p, p.SetPacketID, true)
+	if err == nil {
+		err = c.persist(t)
+	}
+	if err == nil {
+		err = c.send(ctx, t)
+		if err != nil && err != ctx.Err() {
+			// Unless the packet was not written because of ctx, the Network
+			// Connection is lost and the packet is sent after reconnecting
+			return t
+		}
+	}
+	if err != nil {
+		if t == nil {
+			t = newToken()
+		} else {
+			c.untrack(id, t)
+		}
+		t.complete(nil, err)
+	}
+	return t
+}
+
+// Subscribe sends SUBSCRIBE and waits for the SUBACK, whose return codes tell which
+// of the Topic Filters were accepted and with which maximum QoS. A return code of
+// 0x80 or greater means the Subscription failed.
+func (c *Client) Subscribe(ctx context.Context, m *message.SubscribeMessage) (*message.SubackMessage, error) {
+	resp, err := c.request(ctx, m, m.SetPacketID)
+	if err != nil {
+		return nil, err
+	}
+	suback, ok := resp.(*message.SubackMessage)
+	if !ok {
+		return nil, ErrUnexpectedPacket
+	}
+
+	c.mu.Lock()
+	for i, rc := range suback.ReturnCodes() {
+		if i >= len(m.Topics()) {
+			break
+		}
+		f := string(m.Topics()[i])
+		if rc < 0x80 {
+			c.subscriptions[f] = m.QoS()[i]
+		} else {
+			delete(c.subscriptions, f)
+		}
+	}
+	c.mu.Unlock()
+	return suback, nil
+}
+
+// Unsubscribe sends UNSUBSCRIBE and waits for the UNSUBACK
+func (c *Client) Unsubscribe(ctx context.Context, m *message.UnsubscribeMessage) (*message.UnsubackMessage, error) {
+	resp, err := c.request(ctx, m, m.SetPacketID)
+	if err != nil {
+		return nil, err
+	}
+	unsuback, ok := resp.(*message.UnsubackMessage)
+	if !ok {
+		return nil, ErrUnexpectedPacket
+	}
+
+	c.mu.Lock()
+	for _, f := range m.Topics() {
+		delete(c.subscriptions, string(f))
+	}
+	c.mu.Unlock()
+	return unsuback, nil
+}
+
+// Disconnect sends DISCONNECT and closes the Network Connection. It also stops
+// the Client from reconnecting.
+func (c *Client) Disconnect(ctx context.Context) error {
+	c.mu.Lock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.mu.Unlock()
+
+	err := c.write(ctx, message.NewDisconnectMessage())
+
+	c.mu.Lock()
+	conn, done := c.conn, c.done
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	// After sending a DISCONNECT Packet the Client MUST close the Network
+	// Connection [MQTT-3.14.4-1]
+	c.close(conn, nil)
+	<-done
+	return err
+}
+
+// request writes m with a new Packet Identifier and waits for the response
+// carrying the same Packet Identifier
+func (c *Client) request(ctx context.Context, m message.Message, setID func([]byte)) (message.Message, error) {
+	t, id, err := c.track(m, setID, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write(ctx, m); err != nil {
+		c.untrack(id, t)
+		return nil, err
+	}
+	if err := t.Wait(ctx); err != nil {
+		c.untrack(id, t)
+		return nil, err
+	}
+	return t.Response(), nil
+}
+
+// track assigns an unused Packet Identifier to m with setID and returns the Token
+// completed by the response carrying it. If resend is true, m is sent again when
+// the Client resumes the Session before the response arrives.
+func (c *Client) track(m message.Message, setID func([]byte), resend bool) (*Token, uint16, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		// Messages are queued between Connect and Disconnect
+		if !resend || c.stop == nil {
+			return nil, 0, ErrNotConnected
+		}
+		if c.queued() >= c.maxQueued() {
+			return nil, 0, ErrQueueFull
+		}
+	}
+	if c.tokens == nil {
+		c.tokens = map[uint16]*Token{}
+	}
+	if len(c.tokens) >= 0xFFFF {
+		return nil, 0, ErrQueueFull
+	}
+
+	for {
+		c.nextID++
+		if c.nextID == 0 {
+			continue
+		}
+		if _, ok := c.tokens[c.nextID]; !ok {
+			break
+		}
+	}
+	id := c.nextID
+
+	t := newToken()
+	if resend {
+		t.msg = m
+	}
+	c.seq++
+	t.seq = c.seq
+	c.tokens[id] = t
+
+	pid := make([]byte, 2)
+	binary.BigEndian.PutUint16(pid, id)
+	setID(pid)
+	return t, id, nil
+}
+
+// queued returns the number of messages which were never sent. It is called with
+// c.mu held.
+func (c *Client) queued() int {
+	n := 0
+	for _, t := range c.tokens {
+		if t.msg != nil && !t.sent {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Client) maxQueued() int {
+	if c.MaxQueued > 0 {
+		return c.MaxQueued
+	}
+	return DefaultMaxQueued
+}
+
+// untrack forgets the Token of Packet Identifier id
+func (c *Client) untrack(id uint16, t *Token) {
+	c.mu.Lock()
+	if c.tokens[id] == t {
+		delete(c.tokens, id)
+	}
+	c.mu.Unlock()
+	c.forget(t)
+}
+
+// finish completes the delivery tracked with Packet Identifier pid
+func (c *Client) finish(pid []byte, resp message.Message, err error) {
+	id := binary.BigEndian.Uint16(pid)
+	c.mu.Lock()
+	t := c.tokens[id]
+	delete(c.tokens, id)
+	c.mu.Unlock()
+
+	if t != nil {
+		c.forget(t)
+		t.complete(resp, err)
+	}
+}
+
+// persist puts the packet tracked by t in Store
+func (c *Client) persist(t *Token) error {
+	if c.Store == nil {
+		return nil
+	}
+	c.mu.Lock()
+	e := Entry{Seq: t.seq, Sent: t.sent, Packet: t.msg}
+	c.mu.Unlock()
+	if e.Packet == nil {
+		return nil
+	}
+	return c.Store.Put(e)
+}
+
+// forget removes the packet tracked by t from Store
+func (c *Client) forget(t *Token) {
+	if c.Store == nil || t.msg == nil {
+		return
+	}
+	// A failure leaves an entry which is sent again after a restart, which the
+	// Server treats as a duplicate
+	c.Store.Delete(t.seq)
+}
+
+// send writes the packet tracked by t, unless it was already written to the
+// current Network Connection
+func (c *Client) send(ctx context.Context, t *Token) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.mu.Lock()
+	conn, version := c.conn, c.version
+	m, sent, written := t.msg, t.sent, t.conn == conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	if written || m == nil {
+		return nil
+	}
+
+	if p, ok := m.(*message.PublishMessage); ok && sent {
+		p.SetDup(true)
+	}
+	if err := c.writeTo(ctx, conn, version, m); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	t.conn = conn
+	t.sent = true
+	c.mu.Unlock()
+	if !sent {
+		// A failure only makes the packet sent without DUP after a restart
+		c.persist(t)
+	}
+	return nil
+}
+
+// write sends m to the Server before ctx is done
+func (c *Client) write(ctx context.Context, m message.Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.mu.Lock()
+	conn, version := c.conn, c.version
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return c.writeTo(ctx, conn, version, m)
+}
+
+// writeTo writes m to conn. It is called with c.wmu held.
+func (c *Client) writeTo(ctx context.Context, conn net.Conn, version byte, m message.Message) error {
+	m.SetVersion(version)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(d)
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	if err := message.WritePacket(conn, m); err != nil {
+		c.close(conn, err)
+		return err
+	}
+	atomic.StoreInt64(&c.lastSent, time.Now().UnixNano())
+	return nil
+}
+
+// readLoop handles the packets sent by the Server until the Network Connection
+// is closed
+func (c *Client) readLoop(conn net.Conn, version byte, done chan struct{}) {
+	defer close(done)
+	for {
+		m, err := message.ReadPacket(conn, version)
+		if err != nil {
+			c.close(conn, err)
+			return
+		}
+		if err := c.handle(m); err != nil {
+			c.close(conn, err)
+			return
+		}
+	}
+}
+
+// handle processes one packet from the Server
+func (c *Client) handle(m message.Message) error {
+	ctx := context.Background()
+	switch m := m.(type) {
+	case *message.PublishMessage:
+		return c.handlePublish(m)
+	case *message.PubackMessage:
+		c.finish(m.PacketID(), m, reasonErr(message.PUBACK, m.ReasonCode()))
+	case *message.PubrecMessage:
+		// A PUBREC Reason Code of 0x80 or greater ends the delivery
+		if err := reasonErr(message.PUBREC, m.ReasonCode()); err != nil {
+			c.finish(m.PacketID(), m, err)
+			return nil
+		}
+		rel := message.NewPubrelMessage()
+		rel.SetPacketID(m.PacketID())
+		c.mu.Lock()
+		t := c.tokens[binary.BigEndian.Uint16(m.PacketID())]
+		if t != nil {
+			// From now on PUBREL is sent again instead of PUBLISH [MQTT-4.3.3-1]
+			t.msg = rel
+		}
+		c.mu.Unlock()
+		if t != nil {
+			c.persist(t)
+		}
+		return c.write(ctx, rel)
+	case *message.PubcompMessage:
+		c.finish(m.PacketID(), m, reasonErr(message.PUBCOMP, m.ReasonCode()))
+	case *message.PubrelMessage:
+		c.mu.Lock()
+		delete(c.received, binary.BigEndian.Uint16(m.PacketID()))
+		c.mu.Unlock()
+		comp := message.NewPubcompMessage()
+		comp.SetPacketID(m.PacketID())
+		return c.write(ctx, comp)
+	case *message.SubackMessage:
+		c.finish(m.PacketID(), m, nil)
+	case *message.UnsubackMessage:
+		c.finish(m.PacketID(), m, nil)
+	case *message.PingrespMessage:
+		c.mu.Lock()
+		pong := c.pong
+		c.mu.Unlock()
+		select {
+		case pong <- struct{}{}:
+		default:
+		}
+	case *message.DisconnectMessage:
+		return &ReasonError{Type: message.DISCONNECT, Code: m.ReasonCode()}
+	default:
+		return ErrUnexpectedPacket
+	}
+	return nil
+}
+
+// handlePublish passes an Application Message to OnPublish and acknowledges it
+func (c *Client) handlePublish(p *message.PublishMessage) error {
+	ctx := context.Background()
+	switch p.QoS() {
+	case 0:
+		c.onPublish(p)
+	case 1:
+		c.onPublish(p)
+		ack := message.NewPubackMessage()
+		ack.SetPacketID(p.PacketID())
+		return c.write(ctx, ack)
+	case 2:
+		// The receiver MUST NOT cause the message to be onward delivered to any
+		// subsequent recipients again until it has received the matching PUBREL
+		// [MQTT-4.3.3-2], so a message sent again with DUP set is only delivered
+		// once
+		id := binary.BigEndian.Uint16(p.PacketID())
+		c.mu.Lock()
+		dup := c.received[id]
+		c.received[id] = true
+		c.mu.Unlock()
+		if !dup {
+			c.onPublish(p)
+		}
+		rec := message.NewPubrecMessage()
+		rec.SetPacketID(p.PacketID())
+		return c.write(ctx, rec)
+	}
+	return nil
+}
+
+func (c *Client) onPublish(p *message.PublishMessage) {
+	if c.OnPublish != nil {
+		c.OnPublish(p)
+	}
+}
+
+// reasonErr returns a ReasonError for an MQTT 5.0 Reason Code indicating failure
+func reasonErr(t byte, rc byte) error {
+	if rc < 0x80 {
+		return nil
+	}
+	return &ReasonError{Type: t, Code: rc}
+}
+
+// close closes conn, recording err as the reason unless the Client already moved
+// on to another Network Connection. Deliveries which are not sent again when the
+// Session resumes fail. If err is not nil the Network Connection was lost rather
+// than closed by Disconnect.
+func (c *Client) close(conn net.Conn, err error) {
+	var failed []*Token
+	lost, reconnect := false, false
+	var stop chan struct{}
+
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+		c.err = err
+		for id, t := range c.tokens {
+			if t.msg == nil || (c.cleanSession && t.sent) {
+				failed = append(failed, t)
+				delete(c.tokens, id)
+			}
+		}
+
+		lost = err != nil
+		stop = c.stop
+		if lost && c.AutoReconnect && stop != nil && !c.reconnecting {
+			c.reconnecting = true
+			reconnect = true
+		}
+	}
+	c.mu.Unlock()
+	conn.Close()
+
+	if lost {
+		// Hooks run on their own goroutine as close can be called with wmu held
+		go c.connectionLost(err, reconnect, stop)
+	}
+
+	if err == nil {
+		err = ErrNotConnected
+	}
+	for _, t := range failed {
+		c.forget(t)
+		t.complete(nil, err)
+	}
 }
@@ -1 +1,230 @@
 package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/message"
+)
+
+// fakeServer accepts one Network Connection for each of answers in turn, reads
+// CONNECT and calls the answer with it
+func fakeServer(t *testing.T, answers ...func(c net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for _, answer := range answers {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := message.ReadPacket(c, message.Version311); err != nil {
+				c.Close()
+				return
+			}
+			answer(c)
+			c.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// expect reads the next packet from c and reports whether it is the expected one
+func expect(t *testing.T, c net.Conn, want string) message.Message {
+	m, err := message.ReadPacket(c, message.Version311)
+	if err != nil {
+		t.Errorf("reading %s: %v", want, err)
+		return nil
+	}
+	if s := m.(fmt.Stringer).String(); s != want {
+		t.Errorf("received %s, want %s", s, want)
+	}
+	return m
+}
+
+func newConnect() *message.ConnectMessage {
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("client"))
+	connect.SetCleanSession(true)
+	return connect
+}
+
+func TestConnectRefused(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		connack := message.NewConnackMessage()
+		connack.SetConnectReturnCode(0x05)
+		message.WritePacket(c, connack)
+	})
+
+	c := &Client{}
+	_, err := c.Connect(context.Background(), addr, newConnect())
+	var re *ReasonError
+	if !errors.As(err, &re) || re.Type != message.CONNACK || re.Code != 0x05 {
+		t.Errorf("Connect() error = %v, want CONNACK reason code 0x05", err)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		time.Sleep(time.Second)
+	})
+
+	c := &Client{ConnectTimeout: 50 * time.Millisecond}
+	_, err := c.Connect(context.Background(), addr, newConnect())
+	if err != context.DeadlineExceeded {
+		t.Errorf("Connect() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSubscribeConnectionLost(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+		// Read SUBSCRIBE and close without answering it
+		message.ReadPacket(c, message.Version311)
+	})
+
+	c := &Client{}
+	if _, err := c.Connect(context.Background(), addr, newConnect()); err != nil {
+		t.Fatal(err)
+	}
+	sub := message.NewSubscribeMessage()
+	sub.Add([]byte("a/#"), 0)
+	if _, err := c.Subscribe(context.Background(), sub); err == nil {
+		t.Error("Subscribe() succeeded on a lost connection")
+	}
+	if _, err := c.Subscribe(context.Background(), sub); err != ErrNotConnected {
+		t.Errorf("Subscribe() error = %v, want %v", err, ErrNotConnected)
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		addr, network, address string
+		err                    error
+	}{
+		{"127.0.0.1:1883", "tcp", "127.0.0.1:1883", nil},
+		{"localhost:1883", "tcp", "localhost:1883", nil},
+		{"tcp://broker:1883", "tcp", "broker:1883", nil},
+		{"mqtt://broker:1883", "tcp", "broker:1883", nil},
+		{"tls://broker:8883", "tls", "broker:8883", nil},
+		{"mqtts://broker:8883", "tls", "broker:8883", nil},
+		{"gopher://broker:1883", "", "", ErrSchemeInvalid},
+	}
+	for _, tt := range tests {
+		network, address, err := parseAddr(tt.addr)
+		if network != tt.network || address != tt.address || err != tt.err {
+			t.Errorf("parseAddr(%q) = %q, %q, %v, want %q, %q, %v", tt.addr, network, address, err, tt.network, tt.address, tt.err)
+		}
+	}
+}
+
+func TestPublishResendOnReconnect(t *testing.T) {
+	acked := make(chan struct{})
+	addr := fakeServer(t, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+		expect(t, c, `PUBLISH(q1, r0, d0, id=1, topic="a/b", 2B)`)
+	}, func(c net.Conn) {
+		connack := message.NewConnackMessage()
+		connack.SetSessionPresent(true)
+		message.WritePacket(c, connack)
+		expect(t, c, `PUBLISH(q1, r0, d1, id=1, topic="a/b", 2B)`)
+		ack := message.NewPubackMessage()
+		ack.SetPacketID([]byte{0, 1})
+		message.WritePacket(c, ack)
+		<-acked
+	})
+
+	connect := newConnect()
+	connect.SetCleanSession(false)
+	c := &Client{}
+	if _, err := c.Connect(context.Background(), addr, connect); err != nil {
+		t.Fatal(err)
+	}
+
+	p := message.NewPublishMessage()
+	p.SetTopicName([]byte("a/b"))
+	p.SetQoS(1)
+	p.SetPayload([]byte("hi"))
+	token := c.PublishAsync(context.Background(), p)
+
+	<-c.Done()
+	select {
+	case <-token.Done():
+		t.Fatalf("delivery ended with the connection: %v", token.Err())
+	default:
+	}
+
+	if _, err := c.Connect(context.Background(), addr, connect); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := token.Wait(ctx); err != nil {
+		t.Error(err)
+	}
+	if _, ok := token.Response().(*message.PubackMessage); !ok {
+		t.Errorf("Response() = %v, want PUBACK", token.Response())
+	}
+	close(acked)
+}
+
+func TestPublishCleanSessionLost(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+		expect(t, c, `PUBLISH(q2, r0, d0, id=1, topic="a/b", 2B)`)
+	})
+
+	c := &Client{}
+	if _, err := c.Connect(context.Background(), addr, newConnect()); err != nil {
+		t.Fatal(err)
+	}
+	p := message.NewPublishMessage()
+	p.SetTopicName([]byte("a/b"))
+	p.SetQoS(2)
+	p.SetPayload([]byte("hi"))
+	if err := c.Publish(context.Background(), p); err == nil {
+		t.Error("Publish() succeeded on a lost connection")
+	}
+}
+
+func TestReceiveQoS2Once(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+
+		p := message.NewPublishMessage()
+		p.SetTopicName([]byte("a/b"))
+		p.SetQoS(2)
+		p.SetPacketID([]byte{0, 7})
+		message.WritePacket(c, p)
+		expect(t, c, "PUBREC(id=7)")
+
+		p.SetDup(true)
+		message.WritePacket(c, p)
+		expect(t, c, "PUBREC(id=7)")
+
+		rel := message.NewPubrelMessage()
+		rel.SetPacketID([]byte{0, 7})
+		message.WritePacket(c, rel)
+		expect(t, c, "PUBCOMP(id=7)")
+	})
+
+	var received int
+	c := &Client{OnPublish: func(p *message.PublishMessage) {
+		received++
+	}}
+	if _, err := c.Connect(context.Background(), addr, newConnect()); err != nil {
+		t.Fatal(err)
+	}
+	<-c.Done()
+	if received != 1 {
+		t.Errorf("OnPublish called %d times, want 1", received)
+	}
+}
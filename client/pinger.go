@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Den3/mammoth/message"
+)
+
+// DefaultPingTimeout is how long the Client waits for PINGRESP when PingTimeout is zero
+const DefaultPingTimeout = 10 * time.Second
+
+var (
+	// ErrPingTimeout indicates the Server did not answer PINGREQ in time
+	ErrPingTimeout = errors.New("no PINGRESP received")
+)
+
+// keepAliveOf returns the Keep Alive of a Network Connection: the Server Keep Alive
+// of an MQTT 5.0 CONNACK if there is one, the Keep Alive of CONNECT otherwise
+func keepAliveOf(connect *message.ConnectMessage, connack *message.ConnackMessage) time.Duration {
+	keepAlive := uint32(connect.KeepAlive())
+	if v, ok := connack.Properties().Value(message.ServerKeepAlive); ok {
+		keepAlive = v
+	}
+	return time.Duration(keepAlive) * time.Second
+}
+
+// pinger keeps conn alive until done is closed. It is the responsibility of the
+// Client to ensure that the interval between Control Packets being sent does not
+// exceed the Keep Alive value. In the absence of sending any other Control Packets,
+// the Client MUST send a PINGREQ Packet [MQTT-3.1.2-23]. If the Client does not
+// receive a PINGRESP Packet within PingTimeout, it closes the Network Connection.
+func (c *Client) pinger(conn net.Conn, version byte, keepAlive time.Duration, pong chan struct{}, done chan struct{}) {
+	timer := time.NewTimer(keepAlive)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-done:
+			return
+		}
+
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastSent)))
+		if idle < keepAlive {
+			timer.Reset(keepAlive - idle)
+			continue
+		}
+
+		// Forget a PINGRESP which arrived after the previous timeout
+		select {
+		case <-pong:
+		default:
+		}
+
+		// A Network Connection which cannot take PINGREQ within PingTimeout is as
+		// dead as one which does not answer it
+		ctx, cancel := context.WithTimeout(context.Background(), c.pingTimeout())
+		c.wmu.Lock()
+		err := c.writeTo(ctx, conn, version, message.NewPingeqMessage())
+		c.wmu.Unlock()
+		cancel()
+		if err != nil {
+			c.close(conn, err)
+			return
+		}
+
+		timeout := time.NewTimer(c.pingTimeout())
+		select {
+		case <-pong:
+			timeout.Stop()
+		case <-done:
+			timeout.Stop()
+			return
+		case <-timeout.C:
+			c.close(conn, ErrPingTimeout)
+			return
+		}
+		timer.Reset(keepAlive)
+	}
+}
+
+func (c *Client) pingTimeout() time.Duration {
+	if c.PingTimeout > 0 {
+		return c.PingTimeout
+	}
+	return DefaultPingTimeout
+}
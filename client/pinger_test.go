@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/message"
+)
+
+func TestPingerKeepsAlive(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+		c.SetReadDeadline(time.Now().Add(3 * time.Second))
+		expect(t, c, "PINGREQ")
+		message.WritePacket(c, message.NewPingrespMessage())
+		message.ReadPacket(c, message.Version311)
+	})
+
+	connect := newConnect()
+	connect.SetKeepAlive(1)
+	c := &Client{PingTimeout: time.Second}
+	if _, err := c.Connect(context.Background(), addr, connect); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if err := c.Disconnect(context.Background()); err != nil {
+		t.Errorf("Disconnect() error = %v", err)
+	}
+}
+
+func TestPingerTimeout(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+		expect(t, c, "PINGREQ")
+		message.ReadPacket(c, message.Version311)
+	})
+
+	connect := newConnect()
+	connect.SetKeepAlive(1)
+	c := &Client{PingTimeout: 100 * time.Millisecond}
+	if _, err := c.Connect(context.Background(), addr, connect); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection still open without PINGRESP")
+	}
+	if err := c.Err(); err != ErrPingTimeout {
+		t.Errorf("Err() = %v, want %v", err, ErrPingTimeout)
+	}
+}
+
+func TestKeepAliveOf(t *testing.T) {
+	connect := newConnect()
+	connect.SetKeepAlive(60)
+	connack := message.NewConnackMessage()
+	if d := keepAliveOf(connect, connack); d != time.Minute {
+		t.Errorf("keepAliveOf() = %v, want 1m", d)
+	}
+
+	props := message.Properties{}
+	props.AddValue(message.ServerKeepAlive, 5)
+	connack.SetProperties(props)
+	if d := keepAliveOf(connect, connack); d != 5*time.Second {
+		t.Errorf("keepAliveOf() with Server Keep Alive = %v, want 5s", d)
+	}
+}
+
+func TestPingerWriteTimeout(t *testing.T) {
+	// Nothing reads the other end of the pipe, so that PINGREQ cannot be written
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := &Client{PingTimeout: 50 * time.Millisecond}
+	c.conn = conn
+	c.lastSent = time.Now().Add(-time.Hour).UnixNano()
+
+	returned := make(chan struct{})
+	go func() {
+		c.pinger(conn, message.Version311, 10*time.Millisecond, make(chan struct{}), make(chan struct{}))
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pinger blocked writing PINGREQ")
+	}
+	if c.Err() == nil {
+		t.Error("Err() = nil after PINGREQ could not be written")
+	}
+}
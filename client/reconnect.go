@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/Den3/mammoth/message"
+)
+
+const (
+	// DefaultMinReconnectDelay is the delay before the first attempt to reconnect
+	DefaultMinReconnectDelay = time.Second
+
+	// DefaultMaxReconnectDelay is the longest delay between attempts to reconnect
+	DefaultMaxReconnectDelay = 2 * time.Minute
+)
+
+// connectionLost reports err to OnConnectionLost and reconnects if reconnect is true
+func (c *Client) connectionLost(err error, reconnect bool, stop chan struct{}) {
+	if c.OnConnectionLost != nil {
+		c.OnConnectionLost(err)
+	}
+	if reconnect {
+		c.reconnect(stop)
+	}
+}
+
+// reconnect connects again until it succeeds or stop is closed. The delay before
+// each attempt doubles up to MaxReconnectDelay and is jittered so that many Clients
+// losing the same Server do not reconnect all at once.
+func (c *Client) reconnect(stop chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	delay, max := c.reconnectDelays()
+	for {
+		select {
+		case <-time.After(jitter(delay)):
+		case <-stop:
+			c.mu.Lock()
+			c.reconnecting = false
+			c.mu.Unlock()
+			return
+		}
+		if delay *= 2; delay > max {
+			delay = max
+		}
+
+		c.mu.Lock()
+		addr, connect := c.addr, c.connect
+		c.mu.Unlock()
+
+		connack, err := c.connectTo(ctx, addr, connect)
+		if err != nil {
+			continue
+		}
+		if c.abandon(stop) {
+			return
+		}
+		if connack.SessionPresent() == 0 {
+			if err := c.resubscribe(ctx); err != nil {
+				c.mu.Lock()
+				conn := c.conn
+				c.mu.Unlock()
+				if conn != nil {
+					c.close(conn, err)
+				}
+				continue
+			}
+		}
+
+		if c.abandon(stop) {
+			return
+		}
+		c.mu.Lock()
+		if c.conn == nil {
+			// Lost again before reconnecting completed
+			c.mu.Unlock()
+			continue
+		}
+		c.reconnecting = false
+		c.mu.Unlock()
+
+		if c.OnReconnect != nil {
+			c.OnReconnect(connack)
+		}
+		return
+	}
+}
+
+// abandon closes the Network Connection reconnect opened if stop was closed
+// meanwhile, as Disconnect then found no connection to close. It reports whether
+// reconnect must return.
+func (c *Client) abandon(stop chan struct{}) bool {
+	c.mu.Lock()
+	select {
+	case <-stop:
+	default:
+		c.mu.Unlock()
+		return false
+	}
+	conn := c.conn
+	c.reconnecting = false
+	c.mu.Unlock()
+	if conn != nil {
+		c.close(conn, nil)
+	}
+	return true
+}
+
+// reconnectDelays returns the first and the longest delay between attempts to reconnect
+func (c *Client) reconnectDelays() (time.Duration, time.Duration) {
+	min, max := c.MinReconnectDelay, c.MaxReconnectDelay
+	if min <= 0 {
+		min = DefaultMinReconnectDelay
+	}
+	if max <= 0 {
+		max = DefaultMaxReconnectDelay
+	}
+	if max < min {
+		max = min
+	}
+	return min, max
+}
+
+// jitter returns a random duration between d/2 and d
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// resubscribe subscribes again to the Topic Filters of the Client, as the Server
+// started a new Session
+func (c *Client) resubscribe(ctx context.Context) error {
+	c.mu.Lock()
+	filters := make([]string, 0, len(c.subscriptions))
+	for f := range c.subscriptions {
+		filters = append(filters, f)
+	}
+	sort.Strings(filters)
+	m := message.NewSubscribeMessage()
+	for _, f := range filters {
+		if err := m.AddWithOptions([]byte(f), c.subscriptions[f]); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+	}
+	c.mu.Unlock()
+
+	if len(filters) == 0 {
+		return ctx.Err()
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.connectTimeout())
+	defer cancel()
+	_, err := c.Subscribe(ctx, m)
+	return err
+}
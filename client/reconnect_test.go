@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/message"
+	"github.com/Den3/mammoth/server"
+)
+
+func startServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&server.Server{}).Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+func TestReconnectResubscribes(t *testing.T) {
+	addr := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lost := make(chan error, 1)
+	reconnected := make(chan *message.ConnackMessage, 1)
+	received := make(chan *message.PublishMessage, 1)
+	sub := &Client{
+		AutoReconnect:     true,
+		MinReconnectDelay: 10 * time.Millisecond,
+		OnConnectionLost:  func(err error) { lost <- err },
+		OnReconnect:       func(connack *message.ConnackMessage) { reconnected <- connack },
+		OnPublish:         func(p *message.PublishMessage) { received <- p },
+	}
+	connect := newConnect()
+	connect.SetClientId([]byte("sub"))
+	if _, err := sub.Connect(ctx, addr, connect); err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Disconnect(ctx)
+
+	m := message.NewSubscribeMessage()
+	m.Add([]byte("a/#"), 1)
+	if _, err := sub.Subscribe(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second Client with the same ClientId makes the Server close the first one
+	intruder := &Client{}
+	if _, err := intruder.Connect(ctx, addr, connect); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-lost:
+		if err == nil {
+			t.Error("OnConnectionLost called without an error")
+		}
+	case <-ctx.Done():
+		t.Fatal("OnConnectionLost not called")
+	}
+	select {
+	case connack := <-reconnected:
+		if connack.SessionPresent() != 0 {
+			t.Error("CONNACK has Session Present set for a clean session")
+		}
+	case <-ctx.Done():
+		t.Fatal("OnReconnect not called")
+	}
+
+	pub := &Client{}
+	connect = newConnect()
+	connect.SetClientId([]byte("pub"))
+	if _, err := pub.Connect(ctx, addr, connect); err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Disconnect(ctx)
+
+	p := message.NewPublishMessage()
+	p.SetTopicName([]byte("a/b"))
+	p.SetQoS(1)
+	if err := pub.Publish(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-received:
+		if string(p.TopicName()) != "a/b" {
+			t.Errorf("received %v", p)
+		}
+	case <-ctx.Done():
+		t.Fatal("PUBLISH not received after reconnecting")
+	}
+}
+
+func TestDisconnectStopsReconnecting(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+	})
+
+	lost := make(chan error, 1)
+	c := &Client{
+		AutoReconnect:     true,
+		MinReconnectDelay: time.Hour,
+		OnConnectionLost:  func(err error) { lost <- err },
+	}
+	if _, err := c.Connect(context.Background(), addr, newConnect()); err != nil {
+		t.Fatal(err)
+	}
+	<-lost
+	if err := c.Disconnect(context.Background()); err != ErrNotConnected {
+		t.Errorf("Disconnect() error = %v, want %v", err, ErrNotConnected)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		reconnecting := c.reconnecting
+		c.mu.Unlock()
+		if !reconnecting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("still reconnecting after Disconnect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDisconnectWhileReconnecting(t *testing.T) {
+	stop := make(chan struct{})
+	closed := make(chan struct{})
+	addr := fakeServer(t, func(conn net.Conn) {
+		// Disconnect runs while the Client waits for CONNACK
+		close(stop)
+		message.WritePacket(conn, message.NewConnackMessage())
+		message.ReadPacket(conn, message.Version311)
+		close(closed)
+	})
+
+	reconnected := false
+	c := &Client{
+		MinReconnectDelay: time.Millisecond,
+		OnReconnect:       func(*message.ConnackMessage) { reconnected = true },
+	}
+	c.addr, c.connect, c.reconnecting = addr, newConnect(), true
+	c.reconnect(stop)
+
+	c.mu.Lock()
+	conn, reconnecting := c.conn, c.reconnecting
+	c.mu.Unlock()
+	if conn != nil || reconnecting || reconnected {
+		t.Errorf("after Disconnect: connected %v, reconnecting %v, OnReconnect called %v", conn != nil, reconnecting, reconnected)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("Network Connection not closed")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Second); d < time.Second/2 || d > time.Second {
+			t.Fatalf("jitter(1s) = %v", d)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync"
+
+	"github.com/Den3/mammoth/message"
+	"github.com/Den3/mammoth/topic"
+)
+
+// DefaultQueueSize is the number of messages a Router holds for its workers
+const DefaultQueueSize = 256
+
+// Handler handles an Application Message
+type Handler func(p *message.PublishMessage)
+
+// Ordering tells which messages a Router passes to their handlers in the order in
+// which they arrived
+type Ordering int
+
+const (
+	// OrderNone lets every worker handle any message, so messages can be handled
+	// concurrently and in any order
+	OrderNone Ordering = iota
+
+	// OrderTopic handles the messages of a Topic Name one at a time in the order in
+	// which they arrived, while messages of different Topic Names are handled
+	// concurrently
+	OrderTopic
+
+	// OrderAll handles one message at a time in the order in which they arrived
+	OrderAll
+)
+
+// route is a Handler registered for a Topic Filter
+type route struct {
+	filter  string
+	handler Handler
+}
+
+// Router passes Application Messages to the handlers registered for the Topic
+// Filters matching their Topic Name, on a bounded pool of workers. Its Route method
+// is meant to be the OnPublish of a Client:
+//
+//	r := &client.Router{Ordering: client.OrderTopic}
+//	r.Handle("sensors/+/temp", onTemperature)
+//	r.Handle("cmd/#", onCommand)
+//	c := &client.Client{OnPublish: r.Route}
+//
+// The zero value is ready to use.
+type Router struct {
+	// Workers is the number of goroutines running handlers. Zero means one per CPU.
+	// It is 1 with OrderAll.
+	Workers int
+
+	// QueueSize is the number of messages waiting for a worker before Route blocks.
+	// Zero means DefaultQueueSize.
+	QueueSize int
+
+	// Ordering tells which messages are handled in the order in which they arrived
+	Ordering Ordering
+
+	// Default handles messages matching no Topic Filter. They are dropped if it is nil.
+	Default Handler
+
+	initOnce sync.Once
+	tree     *topic.Tree
+
+	mu     sync.RWMutex
+	routes map[string]*route
+	closed bool
+
+	// queues feed the workers. OrderNone has one queue shared by all workers,
+	// otherwise every worker has its own queue. They are never closed, done
+	// stops the workers and the Route calls waiting for room.
+	queues  []chan *message.PublishMessage
+	done    chan struct{}
+	workers sync.WaitGroup
+}
+
+// init starts the workers of the zero value of Router
+func (r *Router) init() {
+	r.initOnce.Do(func() {
+		r.tree = topic.NewTree()
+		r.routes = map[string]*route{}
+		r.done = make(chan struct{})
+
+		workers := r.Workers
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+		if r.Ordering == OrderAll {
+			workers = 1
+		}
+		size := r.QueueSize
+		if size <= 0 {
+			size = DefaultQueueSize
+		}
+
+		queues := workers
+		if r.Ordering == OrderNone {
+			queues = 1
+		}
+		r.queues = make([]chan *message.PublishMessage, queues)
+		for i := range r.queues {
+			r.queues[i] = make(chan *message.PublishMessage, size/queues+1)
+		}
+
+		r.workers.Add(workers)
+		for i := 0; i < workers; i++ {
+			go r.work(r.queues[i%queues])
+		}
+	})
+}
+
+// Handle registers h for messages whose Topic Name matches filter, replacing the
+// Handler registered for the same filter before. A message matching several
+// Topic Filters is passed to each of their handlers.
+func (r *Router) Handle(filter string, h Handler) error {
+	r.init()
+	if !topic.ValidFilter([]byte(filter)) {
+		return topic.ErrTopicFilterInvalid
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old := r.routes[filter]; old != nil {
+		r.tree.Unsubscribe([]byte(filter), old)
+	}
+	rt := &route{filter: filter, handler: h}
+	r.routes[filter] = rt
+	return r.tree.Subscribe([]byte(filter), rt, 0)
+}
+
+// Remove removes the Handler registered for filter
+func (r *Router) Remove(filter string) {
+	r.init()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rt := r.routes[filter]; rt != nil {
+		r.tree.Unsubscribe([]byte(filter), rt)
+		delete(r.routes, filter)
+	}
+}
+
+// Route queues p for the workers. It blocks while the queue is full, which holds
+// up reading from the Server rather than dropping messages. Messages routed after
+// Close are dropped.
+func (r *Router) Route(p *message.PublishMessage) {
+	r.init()
+
+	// The lock is not held while waiting for room, so that handlers may call
+	// Handle and Remove meanwhile
+	r.mu.RLock()
+	closed := r.closed
+	r.mu.RUnlock()
+	if closed {
+		return
+	}
+
+	q := r.queues[0]
+	if len(r.queues) > 1 {
+		h := fnv.New32a()
+		h.Write(p.TopicName())
+		q = r.queues[h.Sum32()%uint32(len(r.queues))]
+	}
+	select {
+	case q <- p:
+	case <-r.done:
+	}
+}
+
+// Close stops the workers once the queued messages are handled
+func (r *Router) Close() {
+	r.init()
+
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.done)
+	}
+	r.mu.Unlock()
+	r.workers.Wait()
+}
+
+// work handles the messages of q until the Router is closed, and then the ones
+// left in q
+func (r *Router) work(q chan *message.PublishMessage) {
+	defer r.workers.Done()
+
+	var subs []topic.Subscription
+	for {
+		select {
+		case p := <-q:
+			subs = r.handle(p, subs)
+		case <-r.done:
+			for {
+				select {
+				case p := <-q:
+					subs = r.handle(p, subs)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle passes p to the handlers of the Topic Filters matching its Topic Name,
+// using subs as scratch space, which it returns
+func (r *Router) handle(p *message.PublishMessage, subs []topic.Subscription) []topic.Subscription {
+	subs = r.tree.Match(p.TopicName(), subs[:0])
+	if len(subs) == 0 {
+		if r.Default != nil {
+			r.Default(p)
+		}
+		return subs
+	}
+	for _, sub := range subs {
+		sub.Subscriber.(*route).handler(p)
+	}
+	return subs
+}
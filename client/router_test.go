@@ -0,0 +1,181 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/message"
+)
+
+func newPublish(name string, payload string) *message.PublishMessage {
+	p := message.NewPublishMessage()
+	p.SetTopicName([]byte(name))
+	p.SetPayload([]byte(payload))
+	return p
+}
+
+func TestRouterHandle(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string][]string{}
+	record := func(key string) Handler {
+		return func(p *message.PublishMessage) {
+			mu.Lock()
+			got[key] = append(got[key], string(p.TopicName()))
+			mu.Unlock()
+		}
+	}
+
+	r := &Router{Ordering: OrderAll, Default: record("default")}
+	if err := r.Handle("sensors/+/temp", record("temp")); err != nil {
+		t.Fatal(err)
+	}
+	r.Handle("cmd/#", record("cmd"))
+	r.Handle("#", record("all"))
+	r.Handle("old", record("old"))
+	r.Remove("old")
+	if err := r.Handle("a/#/b", record("invalid")); err == nil {
+		t.Error("Handle() accepted an invalid Topic Filter")
+	}
+
+	for _, name := range []string{"sensors/1/temp", "cmd/reboot", "old", "$SYS/uptime"} {
+		r.Route(newPublish(name, ""))
+	}
+	r.Close()
+
+	want := map[string][]string{
+		"temp":    {"sensors/1/temp"},
+		"cmd":     {"cmd/reboot"},
+		"all":     {"sensors/1/temp", "cmd/reboot", "old"},
+		"default": {"$SYS/uptime"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+}
+
+func TestRouterOrderTopic(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string][]string{}
+
+	r := &Router{Workers: 4, QueueSize: 4, Ordering: OrderTopic}
+	r.Handle("#", func(p *message.PublishMessage) {
+		mu.Lock()
+		got[string(p.TopicName())] = append(got[string(p.TopicName())], string(p.Payload()))
+		mu.Unlock()
+	})
+
+	want := map[string][]string{}
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("t/%d", i%7)
+		payload := fmt.Sprint(i)
+		want[name] = append(want[name], payload)
+		r.Route(newPublish(name, payload))
+	}
+	r.Close()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+}
+
+func TestRouterClose(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	r := &Router{}
+	r.Handle("a", func(p *message.PublishMessage) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+	})
+	for i := 0; i < 10; i++ {
+		r.Route(newPublish("a", ""))
+	}
+	r.Close()
+	r.Route(newPublish("a", ""))
+	r.Close()
+
+	if n != 10 {
+		t.Errorf("handled %d messages, want 10", n)
+	}
+}
+
+func TestRouterHandleWhileFull(t *testing.T) {
+	r := &Router{Ordering: OrderAll, QueueSize: 1}
+	release := make(chan struct{})
+	var once sync.Once
+	handled := make(chan struct{}, 4)
+	r.Handle("a", func(p *message.PublishMessage) {
+		<-release
+		// Route is blocked on the full queue
+		once.Do(func() { r.Handle("b", func(*message.PublishMessage) {}) })
+		handled <- struct{}{}
+	})
+
+	// The worker holds the first message and the queue the next two
+	for i := 0; i < 3; i++ {
+		r.Route(newPublish("a", ""))
+	}
+	routed := make(chan struct{})
+	go func() {
+		r.Route(newPublish("a", ""))
+		close(routed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handled %d messages, want 4", i)
+		}
+	}
+	<-routed
+
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() did not return")
+	}
+}
+
+func TestRouterCloseWhileFull(t *testing.T) {
+	r := &Router{Ordering: OrderAll, QueueSize: 1}
+	release := make(chan struct{})
+	r.Handle("a", func(p *message.PublishMessage) { <-release })
+	for i := 0; i < 3; i++ {
+		r.Route(newPublish("a", ""))
+	}
+
+	// Route waiting for room returns once the Router is closed
+	routed := make(chan struct{})
+	go func() {
+		r.Route(newPublish("a", ""))
+		close(routed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-routed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Route() still blocked after Close()")
+	}
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() did not return")
+	}
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Den3/mammoth/message"
+)
+
+var (
+	// ErrEntryInvalid indicates a stored entry cannot be read back
+	ErrEntryInvalid = errors.New("invalid store entry")
+)
+
+// Entry is a QoS 1 or QoS 2 packet of a Session kept by a Store: a PUBLISH Packet
+// waiting to be sent or acknowledged, or the PUBREL Packet of a QoS 2 delivery
+// which got its PUBREC
+type Entry struct {
+	// Seq orders the entries in the order the Client published them
+	Seq uint64
+
+	// Sent tells whether Packet was written to a Network Connection. Packets which
+	// were not are still sent when the Client connects with CleanSession set to 1.
+	Sent bool
+
+	Packet message.Message
+}
+
+// Store keeps the packets of a Session so that they can be sent again after the
+// Client reconnects or the process restarts. Packet Identifiers are kept with the
+// packets.
+type Store interface {
+	// Put adds an entry or replaces the entry with the same Seq
+	Put(e Entry) error
+
+	// Delete removes the entry with Seq seq, if any
+	Delete(seq uint64) error
+
+	// Entries returns every entry ordered by Seq
+	Entries() ([]Entry, error)
+}
+
+// MemoryStore keeps entries in memory. It can carry a Session over from one
+// Client to another one in the same process.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[uint64]Entry
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[uint64]Entry{}}
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[e.Seq] = e
+	return nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, seq)
+	return nil
+}
+
+// Entries implements Store
+func (s *MemoryStore) Entries() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+	return entries, nil
+}
+
+// fileExt is the extension of the files of a FileStore
+const fileExt = ".pkt"
+
+// FileStore keeps every entry in a file of its own in a directory, so that a
+// Session survives a restart of the process. A file holds the Protocol Level the
+// packet is encoded with, the Sent flag and the encoded packet.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore keeping its files in dir, which is created if
+// it does not exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the name of the file of the entry with Seq seq. The fixed width
+// makes the files of a directory listing ordered by Seq.
+func (s *FileStore) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016x%s", seq, fileExt))
+}
+
+// Put implements Store. The entry is written to a temporary file first, so that
+// a crash leaves either the old or the new entry.
+func (s *FileStore) Put(e Entry) error {
+	buf := make([]byte, 2+e.Packet.Len())
+	buf[0] = e.Packet.Version()
+	if e.Sent {
+		buf[1] = 1
+	}
+	n, err := e.Packet.Encode(buf[2:])
+	if err != nil {
+		return err
+	}
+
+	path := s.path(e.Seq)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf[:2+n], 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(seq uint64) error {
+	err := os.Remove(s.path(seq))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Entries implements Store
+func (s *FileStore) Entries() ([]Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, fileExt), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 2 {
+			return nil, fmt.Errorf("%s: %w", name, ErrEntryInvalid)
+		}
+		m, err := message.ReadPacket(bytes.NewReader(b[2:]), b[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		entries = append(entries, Entry{Seq: seq, Sent: b[1] == 1, Packet: m})
+	}
+	return entries, nil
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/message"
+)
+
+func newStoredPublish(id byte, payload string) *message.PublishMessage {
+	p := newPublish("a/b", payload)
+	p.SetQoS(1)
+	p.SetPacketID([]byte{0, id})
+	return p
+}
+
+func TestFileStore(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rel := message.NewPubrelMessage()
+	rel.SetPacketID([]byte{0, 2})
+	want := []Entry{
+		{Seq: 1, Sent: true, Packet: newStoredPublish(1, "one")},
+		{Seq: 2, Sent: true, Packet: rel},
+		{Seq: 300, Packet: newStoredPublish(3, "three")},
+	}
+	for _, e := range []Entry{want[2], want[0], {Seq: 2, Packet: newStoredPublish(2, "two")}, want[1]} {
+		if err := s.Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(Entry{Seq: 4, Packet: newStoredPublish(4, "four")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(4); err != nil {
+		t.Error(err)
+	}
+	if err := s.Delete(5); err != nil {
+		t.Errorf("Delete() of a missing entry: %v", err)
+	}
+
+	got, err := s.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Entries() returned %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Seq != want[i].Seq || got[i].Sent != want[i].Sent {
+			t.Errorf("entry %d is %d/%v, want %d/%v", i, got[i].Seq, got[i].Sent, want[i].Seq, want[i].Sent)
+		}
+		gs, ws := got[i].Packet.(fmt.Stringer).String(), want[i].Packet.(fmt.Stringer).String()
+		if gs != ws {
+			t.Errorf("entry %d is %s, want %s", i, gs, ws)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	a := Entry{Seq: 2, Packet: newStoredPublish(2, "")}
+	b := Entry{Seq: 1, Packet: newStoredPublish(1, "")}
+	s.Put(a)
+	s.Put(b)
+	s.Put(Entry{Seq: 3, Packet: newStoredPublish(3, "")})
+	s.Delete(3)
+
+	got, _ := s.Entries()
+	if want := []Entry{b, a}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries() = %v, want %v", got, want)
+	}
+}
+
+func TestPublishQueuedWhileDisconnected(t *testing.T) {
+	addr := fakeServer(t, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+	}, func(c net.Conn) {
+		message.WritePacket(c, message.NewConnackMessage())
+		for _, want := range []string{
+			`PUBLISH(q1, r0, d0, id=1, topic="a/b", 3B)`,
+			`PUBLISH(q2, r0, d0, id=2, topic="a/b", 3B)`,
+		} {
+			m := expect(t, c, want)
+			if p, ok := m.(*message.PublishMessage); ok && p.QoS() == 1 {
+				ack := message.NewPubackMessage()
+				ack.SetPacketID(p.PacketID())
+				message.WritePacket(c, ack)
+			} else if ok {
+				rec := message.NewPubrecMessage()
+				rec.SetPacketID(p.PacketID())
+				message.WritePacket(c, rec)
+				expect(t, c, "PUBREL(id=2)")
+				comp := message.NewPubcompMessage()
+				comp.SetPacketID(p.PacketID())
+				message.WritePacket(c, comp)
+			}
+		}
+		message.ReadPacket(c, message.Version311)
+	})
+
+	store := NewMemoryStore()
+	c := &Client{Store: store, MaxQueued: 2}
+	if _, err := c.Connect(context.Background(), addr, newConnect()); err != nil {
+		t.Fatal(err)
+	}
+	<-c.Done()
+
+	var tokens []*Token
+	for qos := byte(1); qos <= 2; qos++ {
+		p := newPublish("a/b", "msg")
+		p.SetQoS(qos)
+		tokens = append(tokens, c.PublishAsync(context.Background(), p))
+	}
+	p := newPublish("a/b", "msg")
+	p.SetQoS(1)
+	if err := c.PublishAsync(context.Background(), p).Err(); err != ErrQueueFull {
+		t.Errorf("PublishAsync() error = %v, want %v", err, ErrQueueFull)
+	}
+	if entries, _ := store.Entries(); len(entries) != 2 {
+		t.Errorf("store holds %d entries, want 2", len(entries))
+	}
+
+	if _, err := c.Connect(context.Background(), addr, newConnect()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, token := range tokens {
+		if err := token.Wait(ctx); err != nil {
+			t.Error(err)
+		}
+	}
+	if entries, _ := store.Entries(); len(entries) != 0 {
+		t.Errorf("store holds %d entries after delivery", len(entries))
+	}
+	c.Disconnect(ctx)
+}
+
+func TestPublishRestoredFromStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(Entry{Seq: 7, Sent: true, Packet: newStoredPublish(5, "old")})
+
+	addr := fakeServer(t, func(c net.Conn) {
+		connack := message.NewConnackMessage()
+		connack.SetSessionPresent(true)
+		message.WritePacket(c, connack)
+		expect(t, c, `PUBLISH(q1, r0, d1, id=5, topic="a/b", 3B)`)
+		expect(t, c, `PUBLISH(q1, r0, d0, id=1, topic="a/b", 3B)`)
+		for _, id := range []byte{5, 1} {
+			ack := message.NewPubackMessage()
+			ack.SetPacketID([]byte{0, id})
+			message.WritePacket(c, ack)
+		}
+		message.ReadPacket(c, message.Version311)
+	})
+
+	connect := newConnect()
+	connect.SetCleanSession(false)
+	c := &Client{Store: store}
+	if _, err := c.Connect(context.Background(), addr, connect); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPublish("a/b", "new")
+	p.SetQoS(1)
+	if err := c.Publish(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := store.Entries(); len(entries) != 0 {
+		t.Errorf("store holds %d entries after delivery", len(entries))
+	}
+	c.Disconnect(ctx)
+}
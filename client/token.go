@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/Den3/mammoth/message"
+)
+
+var (
+	// ErrSessionLost indicates a packet was discarded with the Session it belonged
+	// to, because the Client connected with CleanSession set to 1
+	ErrSessionLost = errors.New("session lost")
+
+	// ErrQueueFull indicates the Client holds as many unsent messages or Packet
+	// Identifiers as it can
+	ErrQueueFull = errors.New("queue full")
+)
+
+// Token tracks a packet sent to the Server until its delivery completes: a QoS 0
+// PUBLISH once written, a QoS 1 PUBLISH on PUBACK, a QoS 2 PUBLISH on PUBCOMP,
+// SUBSCRIBE on SUBACK and UNSUBSCRIBE on UNSUBACK
+type Token struct {
+	done chan struct{}
+	once sync.Once
+	resp message.Message
+	err  error
+
+	// msg is the PUBLISH or PUBREL Packet the Client sends again when it resumes the
+	// Session. It is nil for SUBSCRIBE and UNSUBSCRIBE, which are not sent again.
+	msg message.Message
+
+	// seq orders the messages sent again, which MUST be in the order in which the
+	// original packets were sent [MQTT-4.6.0-1]
+	seq uint64
+
+	// conn is the Network Connection msg was last written to, and sent tells
+	// whether it was written at all. A PUBLISH Packet written before is sent again
+	// with DUP set.
+	conn net.Conn
+	sent bool
+}
+
+func newToken() *Token {
+	return &Token{done: make(chan struct{})}
+}
+
+// Done returns a channel which is closed once the delivery completes or fails
+func (t *Token) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait waits until the delivery completes and returns its error, or the error of
+// ctx if ctx is done first
+func (t *Token) Wait(ctx context.Context) error {
+	select {
+	case <-t.done:
+		return t.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Err returns the error of a completed delivery, or nil if it succeeded or has not
+// completed yet
+func (t *Token) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// Response returns the packet which completed the delivery: PUBACK, PUBCOMP, SUBACK
+// or UNSUBACK. It is nil for QoS 0 and until the delivery completes.
+func (t *Token) Response() message.Message {
+	select {
+	case <-t.done:
+		return t.resp
+	default:
+		return nil
+	}
+}
+
+// complete ends the delivery. Only the first call has an effect.
+func (t *Token) complete(resp message.Message, err error) {
+	t.once.Do(func() {
+		t.resp = resp
+		t.err = err
+		close(t.done)
+	})
+}
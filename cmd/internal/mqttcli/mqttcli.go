@@ -0,0 +1,159 @@
+// Package mqttcli holds the flags and the connection handling shared by the
+// mammoth-pub and mammoth-sub commands
+package mqttcli
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Den3/mammoth/client"
+	"github.com/Den3/mammoth/message"
+)
+
+var (
+	// ErrCAInvalid indicates the CA file holds no PEM encoded certificate
+	ErrCAInvalid = errors.New("no certificate found in CA file")
+
+	// ErrVersionInvalid indicates a protocol version other than 3, 4 and 5
+	ErrVersionInvalid = errors.New("protocol version must be 3, 4 or 5")
+)
+
+// Options are the flags describing how to connect to a broker
+type Options struct {
+	Host         string
+	Port         int
+	ClientID     string
+	Version      int
+	KeepAlive    int
+	CleanSession bool
+	UserName     string
+	Password     string
+	Timeout      time.Duration
+
+	TLS      bool
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+}
+
+// Register defines the flags of o in fs. id is the prefix of the default ClientId.
+func (o *Options) Register(fs *flag.FlagSet, id string) {
+	fs.StringVar(&o.Host, "h", "localhost", "broker host")
+	fs.IntVar(&o.Port, "p", 0, "broker port (default 1883, or 8883 with TLS)")
+	fs.StringVar(&o.ClientID, "i", fmt.Sprintf("%s%d", id, os.Getpid()), "client id")
+	fs.IntVar(&o.Version, "V", message.Version311, "protocol level: 3 (MQTT 3.1), 4 (MQTT 3.1.1) or 5 (MQTT 5.0)")
+	fs.IntVar(&o.KeepAlive, "k", 60, "keep alive in seconds, 0 to turn it off")
+	fs.BoolVar(&o.CleanSession, "clean", true, "start a clean session")
+	fs.StringVar(&o.UserName, "u", "", "user name")
+	fs.StringVar(&o.Password, "P", "", "password")
+	fs.DurationVar(&o.Timeout, "timeout", client.DefaultConnectTimeout, "time to wait for CONNACK")
+	fs.BoolVar(&o.TLS, "tls", false, "connect over TLS")
+	fs.StringVar(&o.CAFile, "cafile", "", "PEM encoded CA certificates to verify the broker with, implies -tls")
+	fs.StringVar(&o.CertFile, "cert", "", "PEM encoded client certificate, implies -tls")
+	fs.StringVar(&o.KeyFile, "key", "", "PEM encoded private key of the client certificate")
+	fs.BoolVar(&o.Insecure, "insecure", false, "do not verify the broker certificate, implies -tls")
+}
+
+// secure reports whether the connection uses TLS
+func (o *Options) secure() bool {
+	return o.TLS || o.CAFile != "" || o.CertFile != "" || o.Insecure
+}
+
+// Address returns the URL of the broker
+func (o *Options) Address() string {
+	scheme, port := "tcp", o.Port
+	if o.secure() {
+		scheme = "tls"
+	}
+	if port == 0 {
+		port = 1883
+		if o.secure() {
+			port = 8883
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(o.Host, strconv.Itoa(port))
+}
+
+// TLSConfig returns the TLS configuration given by the flags, or nil without TLS
+func (o *Options) TLSConfig() (*tls.Config, error) {
+	if !o.secure() {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         o.Host,
+		InsecureSkipVerify: o.Insecure,
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, ErrCAInvalid
+		}
+	}
+	if o.CertFile != "" {
+		keyFile := o.KeyFile
+		if keyFile == "" {
+			keyFile = o.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// ConnectMessage returns the CONNECT Packet given by the flags
+func (o *Options) ConnectMessage() (*message.ConnectMessage, error) {
+	if o.Version < message.Version31 || o.Version > message.Version5 {
+		return nil, ErrVersionInvalid
+	}
+
+	connect := message.NewConnectMessage()
+	connect.SetVersion(byte(o.Version))
+	connect.SetCleanSession(o.CleanSession)
+	connect.SetKeepAlive(uint16(o.KeepAlive))
+	if o.ClientID != "" {
+		if err := connect.SetClientId([]byte(o.ClientID)); err != nil {
+			return nil, fmt.Errorf("client id %q: %w", o.ClientID, err)
+		}
+	}
+	if o.UserName != "" {
+		connect.SetUserNameFlag(true)
+		connect.SetUserName([]byte(o.UserName))
+	}
+	if o.Password != "" {
+		connect.SetPasswordFlag(true)
+		connect.SetPassword([]byte(o.Password))
+	}
+	return connect, nil
+}
+
+// Connect configures c with the flags and connects it to the broker
+func (o *Options) Connect(ctx context.Context, c *client.Client) error {
+	connect, err := o.ConnectMessage()
+	if err != nil {
+		return err
+	}
+	if c.TLSConfig, err = o.TLSConfig(); err != nil {
+		return err
+	}
+	c.ConnectTimeout = o.Timeout
+
+	_, err = c.Connect(ctx, o.Address(), connect)
+	return err
+}
@@ -0,0 +1,122 @@
+// Command mammoth-pub publishes messages to an MQTT broker.
+//
+// Usage:
+//
+//	mammoth-pub [flags] -t topic (-m message | -f file | -s | -l | -n)
+//
+// The payload is the -m argument, the contents of a file, the whole of stdin, or
+// an empty payload. With -l every line read from stdin is published as a message
+// of its own.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/Den3/mammoth/client"
+	"github.com/Den3/mammoth/cmd/internal/mqttcli"
+	"github.com/Den3/mammoth/message"
+)
+
+var errPayloadSource = errors.New("exactly one of -m, -f, -s, -l and -n is required")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stderr))
+}
+
+// run publishes as told by args and returns the exit code: 0 on success, 1 if
+// publishing failed and 2 if args are invalid
+func run(ctx context.Context, args []string, stdin io.Reader, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mammoth-pub", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts mqttcli.Options
+	opts.Register(fs, "mammothpub")
+	topic := fs.String("t", "", "topic to publish to")
+	msg := fs.String("m", "", "message payload")
+	file := fs.String("f", "", "publish the contents of a file")
+	whole := fs.Bool("s", false, "publish the whole of stdin as one message")
+	lines := fs.Bool("l", false, "publish every line of stdin as a message")
+	null := fs.Bool("n", false, "publish a zero-length message")
+	qos := fs.Int("q", 0, "QoS of the messages: 0, 1 or 2")
+	retain := fs.Bool("r", false, "ask the broker to retain the message")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	sources := 0
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "m", "f", "s", "l", "n":
+			sources++
+		}
+	})
+	switch {
+	case *topic == "":
+		fmt.Fprintln(stderr, "mammoth-pub: -t is required")
+		return 2
+	case sources != 1:
+		fmt.Fprintln(stderr, "mammoth-pub:", errPayloadSource)
+		return 2
+	case *qos < 0 || *qos > 2:
+		fmt.Fprintln(stderr, "mammoth-pub: -q must be 0, 1 or 2")
+		return 2
+	}
+
+	var payload []byte
+	var err error
+	switch {
+	case *file != "":
+		payload, err = os.ReadFile(*file)
+	case *whole:
+		payload, err = io.ReadAll(stdin)
+	case !*null:
+		payload = []byte(*msg)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "mammoth-pub:", err)
+		return 1
+	}
+
+	c := &client.Client{}
+	if err := opts.Connect(ctx, c); err != nil {
+		fmt.Fprintln(stderr, "mammoth-pub:", err)
+		return 1
+	}
+	defer c.Disconnect(context.Background())
+
+	publish := func(payload []byte) error {
+		p := message.NewPublishMessage()
+		p.SetTopicName([]byte(*topic))
+		p.SetQoS(byte(*qos))
+		p.SetRetain(*retain)
+		p.SetPayload(payload)
+		return c.Publish(ctx, p)
+	}
+
+	if *lines {
+		s := bufio.NewScanner(stdin)
+		for s.Scan() {
+			if err = publish(append([]byte(nil), s.Bytes()...)); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = s.Err()
+		}
+	} else {
+		err = publish(payload)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "mammoth-pub:", err)
+		return 1
+	}
+	return 0
+}
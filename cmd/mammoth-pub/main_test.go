@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/client"
+	"github.com/Den3/mammoth/message"
+	"github.com/Den3/mammoth/server"
+)
+
+func TestRun(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go (&server.Server{}).Serve(ln)
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := make(chan string, 3)
+	c := &client.Client{OnPublish: func(p *message.PublishMessage) {
+		received <- string(p.TopicName()) + " " + string(p.Payload())
+	}}
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("watcher"))
+	if _, err := c.Connect(ctx, ln.Addr().String(), connect); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(ctx)
+	sub := message.NewSubscribeMessage()
+	sub.Add([]byte("cli/#"), 1)
+	if _, err := c.Subscribe(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	args := []string{"-h", host, "-p", port, "-q", "1", "-t", "cli/one", "-m", "hello"}
+	if code := run(ctx, args, nil, &stderr); code != 0 {
+		t.Fatalf("run(%v) = %d: %s", args, code, stderr.String())
+	}
+	args = []string{"-h", host, "-p", port, "-q", "2", "-t", "cli/lines", "-l"}
+	if code := run(ctx, args, strings.NewReader("a\nb\n"), &stderr); code != 0 {
+		t.Fatalf("run(%v) = %d: %s", args, code, stderr.String())
+	}
+
+	for _, want := range []string{"cli/one hello", "cli/lines a", "cli/lines b"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("received %q, want %q", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("%q not received", want)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"-m", "hello"},
+		{"-t", "a"},
+		{"-t", "a", "-m", "hello", "-n"},
+		{"-t", "a", "-m", "hello", "-q", "3"},
+		{"-unknown"},
+	} {
+		var stderr bytes.Buffer
+		if code := run(context.Background(), args, nil, &stderr); code != 2 {
+			t.Errorf("run(%v) = %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Command mammoth-sub subscribes to Topic Filters of an MQTT broker and prints
+// the messages it receives.
+//
+// Usage:
+//
+//	mammoth-sub [flags] -t filter [-t filter ...]
+//
+// Every message is printed with the text/template given by -F followed by a
+// newline. The template is executed with a Message, so that for example
+//
+//	mammoth-sub -t 'sensors/#' -F '{{.Topic}} q{{.QoS}} {{.Payload}}'
+//
+// prints the Topic Name, the QoS and the payload of every message.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/template"
+
+	"github.com/Den3/mammoth/client"
+	"github.com/Den3/mammoth/cmd/internal/mqttcli"
+	"github.com/Den3/mammoth/message"
+)
+
+// Message is the data of the -F template
+type Message struct {
+	Topic    string
+	Payload  string
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	PacketID uint16
+}
+
+// filters collects the values of a repeated flag
+type filters []string
+
+func (f *filters) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *filters) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// packetID returns a Packet Identifier as a number, 0 for QoS 0 messages
+func packetID(pid []byte) uint16 {
+	if len(pid) != 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(pid)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run subscribes as told by args and prints messages until ctx is done or the
+// count given by -C is reached. It returns the exit code: 0 on success, 1 if
+// subscribing failed or the connection was lost, and 2 if args are invalid.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mammoth-sub", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts mqttcli.Options
+	opts.Register(fs, "mammothsub")
+	var topics filters
+	fs.Var(&topics, "t", "Topic Filter to subscribe to, can be repeated")
+	qos := fs.Int("q", 0, "maximum QoS of the subscriptions: 0, 1 or 2")
+	count := fs.Int("C", 0, "exit after receiving this many messages, 0 to never exit")
+	format := fs.String("F", "{{.Payload}}", "text/template to print every message with")
+	verbose := fs.Bool("v", false, "print the Topic Name before the payload, same as -F '{{.Topic}} {{.Payload}}'")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	switch {
+	case len(topics) == 0:
+		fmt.Fprintln(stderr, "mammoth-sub: -t is required")
+		return 2
+	case *qos < 0 || *qos > 2:
+		fmt.Fprintln(stderr, "mammoth-sub: -q must be 0, 1 or 2")
+		return 2
+	}
+	if *verbose {
+		*format = "{{.Topic}} {{.Payload}}"
+	}
+	tmpl, err := template.New("format").Parse(*format + "\n")
+	if err != nil {
+		fmt.Fprintln(stderr, "mammoth-sub: -F:", err)
+		return 2
+	}
+
+	received := make(chan *message.PublishMessage, 64)
+	c := &client.Client{
+		OnPublish: func(p *message.PublishMessage) {
+			select {
+			case received <- p:
+			case <-ctx.Done():
+			}
+		},
+	}
+	if err := opts.Connect(ctx, c); err != nil {
+		fmt.Fprintln(stderr, "mammoth-sub:", err)
+		return 1
+	}
+	defer c.Disconnect(context.Background())
+
+	sub := message.NewSubscribeMessage()
+	for _, t := range topics {
+		if err := sub.Add([]byte(t), byte(*qos)); err != nil {
+			fmt.Fprintf(stderr, "mammoth-sub: %s: %v\n", t, err)
+			return 2
+		}
+	}
+	suback, err := c.Subscribe(ctx, sub)
+	if err != nil {
+		fmt.Fprintln(stderr, "mammoth-sub:", err)
+		return 1
+	}
+	for i, rc := range suback.ReturnCodes() {
+		if rc >= 0x80 && i < len(topics) {
+			fmt.Fprintf(stderr, "mammoth-sub: %s: subscription refused with 0x%02x\n", topics[i], rc)
+			return 1
+		}
+	}
+
+	for n := 0; *count == 0 || n < *count; n++ {
+		select {
+		case p := <-received:
+			err := tmpl.Execute(stdout, Message{
+				Topic:    string(p.TopicName()),
+				Payload:  string(p.Payload()),
+				QoS:      p.QoS(),
+				Retain:   p.Retain() == 1,
+				Dup:      p.Dup() == 1,
+				PacketID: packetID(p.PacketID()),
+			})
+			if err != nil {
+				fmt.Fprintln(stderr, "mammoth-sub:", err)
+				return 1
+			}
+		case <-c.Done():
+			fmt.Fprintln(stderr, "mammoth-sub:", c.Err())
+			return 1
+		case <-ctx.Done():
+			return 0
+		}
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/client"
+	"github.com/Den3/mammoth/message"
+	"github.com/Den3/mammoth/server"
+)
+
+func TestRun(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go (&server.Server{}).Serve(ln)
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	code := make(chan int, 1)
+	go func() {
+		code <- run(ctx, []string{"-h", host, "-p", port, "-t", "cli/#", "-q", "1", "-C", "2",
+			"-F", "{{.Topic}} q{{.QoS}} {{.Payload}}"}, &stdout, &stderr)
+	}()
+
+	c := &client.Client{}
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("publisher"))
+	if _, err := c.Connect(ctx, ln.Addr().String(), connect); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(ctx)
+
+	// Publish until mammoth-sub, which may not have subscribed yet, got two messages
+	for {
+		p := message.NewPublishMessage()
+		p.SetTopicName([]byte("cli/temp"))
+		p.SetQoS(1)
+		p.SetPayload([]byte("21.5"))
+		if err := c.Publish(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case n := <-code:
+			if n != 0 {
+				t.Fatalf("run() = %d: %s", n, stderr.String())
+			}
+			if want := "cli/temp q1 21.5\ncli/temp q1 21.5\n"; stdout.String() != want {
+				t.Errorf("printed %q, want %q", stdout.String(), want)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("mammoth-sub did not exit")
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-t", "a", "-q", "3"},
+		{"-t", "a", "-F", "{{"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := run(context.Background(), args, &stdout, &stderr); code != 2 {
+			t.Errorf("run(%v) = %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,560 @@
+// Package config reads the configuration of the mammoth broker from a JSON file.
+// A file only needs the settings differing from Default:
+//
+//	{
+//		"listeners": [
+//			{"type": "tcp", "address": ":1883", "proxy_protocol": true, "proxy_trusted": ["10.0.0.0/8"]},
+//			{"type": "tls", "address": ":8883", "tls": {
+//				"cert_file": "/etc/mammoth/server.crt",
+//				"key_file": "/etc/mammoth/server.key",
+//				"client_auth": "optional",
+//				"client_ca_file": "/etc/mammoth/ca.crt"
+//			}},
+//			{"type": "ws", "address": ":8080", "path": "/mqtt"},
+//			{"type": "unix", "address": "/run/mammoth/mqtt.sock", "mode": "0660"}
+//		],
+//		"auth": {
+//			"allow_anonymous": false,
+//			"password_file": "/etc/mammoth/passwd",
+//			"acl_file": "/etc/mammoth/acl"
+//		},
+//		"limits": {"max_connections": 10000, "max_packet_size": 65536},
+//		"log": {"level": "debug"}
+//	}
+//
+// Settings of the sections other than listeners can be overridden by environment
+// variables named MAMMOTH_<SECTION>_<SETTING>, such as MAMMOTH_LOG_LEVEL.
+//
+// The broker keeps Sessions in memory only and writes nothing to disk, so there is
+// no persistence section yet.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Den3/mammoth/auth"
+)
+
+// EnvPrefix prefixes the environment variables overriding settings
+const EnvPrefix = "MAMMOTH_"
+
+// maxPacketSize is the size of the largest Control Packet, one with a Remaining
+// Length of 268,435,455 bytes
+const maxPacketSize = 268435455 + 5
+
+var (
+	// ErrNoListener indicates a configuration without listeners
+	ErrNoListener = errors.New("at least one listener is required")
+)
+
+// Config is the configuration of the broker
+type Config struct {
+	Listeners []Listener `json:"listeners"`
+	Auth      Auth       `json:"auth"`
+	Limits    Limits     `json:"limits"`
+	Log       Log        `json:"log"`
+}
+
+// Listener is an address the broker accepts Network Connections on
+type Listener struct {
+	// Type is the kind of listener: "tcp", "tls" for MQTT over TLS, "ws" for MQTT
+	// over WebSocket, "wss" for MQTT over WebSocket over TLS or "unix" for a unix
+	// socket
+	Type string `json:"type"`
+
+	// Address is the host:port to listen on, or the path of a "unix" socket
+	Address string `json:"address"`
+
+	// Mode is the octal permissions of a "unix" socket, such as "0660". Empty
+	// leaves them to the umask.
+	Mode string `json:"mode,omitempty"`
+
+	// Path is the HTTP path of a "ws" or "wss" listener. Empty means
+	// server.DefaultWebSocketPath.
+	Path string `json:"path,omitempty"`
+
+	// TLS configures a "tls" or "wss" listener
+	TLS *TLS `json:"tls,omitempty"`
+
+	// ProxyProtocol requires the connections to start with a PROXY protocol header
+	// telling the address of the Client, as described by server.ProxyListener
+	ProxyProtocol bool `json:"proxy_protocol,omitempty"`
+
+	// ProxyTrusted are the IP addresses or networks, such as 10.0.0.0/8, of the
+	// proxies allowed to connect. Empty allows every address.
+	ProxyTrusted []string `json:"proxy_trusted,omitempty"`
+}
+
+// Secure reports whether the listener runs over TLS
+func (l *Listener) Secure() bool {
+	return l.Type == "tls" || l.Type == "wss"
+}
+
+// WebSocket reports whether the listener carries MQTT over WebSocket
+func (l *Listener) WebSocket() bool {
+	return l.Type == "ws" || l.Type == "wss"
+}
+
+// Network returns the network of the listener for net.Listen
+func (l *Listener) Network() string {
+	if l.Type == "unix" {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// TrustedProxies returns the networks of ProxyTrusted
+func (l *Listener) TrustedProxies() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range l.ProxyTrusted {
+		if p, err := auth.ParseNetwork(s); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// FileMode returns the permissions of Mode, zero if it is empty
+func (l *Listener) FileMode() os.FileMode {
+	m, _ := strconv.ParseUint(l.Mode, 8, 32)
+	return os.FileMode(m)
+}
+
+// TLS configures a TLS listener, as described by the fields of server.TLSOptions
+// with the same names
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// MinVersion is 1.0, 1.1, 1.2 or 1.3. Empty means 1.2.
+	MinVersion string `json:"min_version"`
+
+	// CipherSuites are names of crypto/tls, such as
+	// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. Empty means the defaults of
+	// crypto/tls.
+	CipherSuites []string `json:"cipher_suites"`
+
+	// ClientAuth is none, optional, which verifies the certificates Clients
+	// present, or required. Empty means none.
+	ClientAuth   string `json:"client_auth"`
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":         tls.NoClientCert,
+		"none":     tls.NoClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+		"required": tls.RequireAndVerifyClientCert,
+	}
+)
+
+// Version returns the TLS version of MinVersion, zero if it is empty
+func (t *TLS) Version() uint16 {
+	return tlsVersions[t.MinVersion]
+}
+
+// CipherSuiteIDs returns the IDs of CipherSuites, nil if it is empty
+func (t *TLS) CipherSuiteIDs() []uint16 {
+	var ids []uint16
+	for _, name := range t.CipherSuites {
+		id, _ := cipherSuite(name)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// ClientAuthType returns the tls.ClientAuthType of ClientAuth
+func (t *TLS) ClientAuthType() tls.ClientAuthType {
+	return clientAuthTypes[t.ClientAuth]
+}
+
+// cipherSuite returns the ID of the cipher suite called name
+func cipherSuite(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, s := range suites {
+			if s.Name == name {
+				return s.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Auth configures how Clients are authenticated
+type Auth struct {
+	// AllowAnonymous accepts Clients without a User Name
+	AllowAnonymous bool `json:"allow_anonymous"`
+
+	// PasswordFile is a file written by mammoth passwd holding the User Names and
+	// Passwords accepted. Without it every User Name is accepted, with any Password.
+	PasswordFile string `json:"password_file"`
+
+	// CertUserName, if not empty, makes a field of the certificate of the Clients
+	// presenting one the User Name they are known by: cn, or the first Subject
+	// Alternative Name of type dns, email or uri. Their User Name and Password are
+	// ignored.
+	CertUserName string `json:"cert_user_name"`
+
+	// UnixPeerUID makes the user ID of the process of the Clients connected over a
+	// unix socket the User Name they are known by, in decimal. Their User Name and
+	// Password are ignored. It cannot be used with a unix listener with
+	// proxy_protocol, whose process is the proxy.
+	UnixPeerUID bool `json:"unix_peer_uid"`
+
+	// CertClientID refuses the Clients presenting a certificate whose ClientId is
+	// not its Common Name
+	CertClientID bool `json:"cert_client_id"`
+
+	// JWTKeysFile is a JSON Web Key Set holding the keys of the JSON Web Tokens
+	// Clients send as Password, described by auth.JWT. It cannot be used with
+	// PasswordFile.
+	JWTKeysFile string `json:"jwt_keys_file"`
+
+	// JWTAudience and JWTIssuer, if not empty, are the aud and iss claims tokens
+	// must carry
+	JWTAudience string `json:"jwt_audience"`
+	JWTIssuer   string `json:"jwt_issuer"`
+
+	// JWTUserNameClaim is the claim holding the User Name. Empty means sub.
+	JWTUserNameClaim string `json:"jwt_user_name_claim"`
+
+	// WebhookURL is an HTTP endpoint asked whether Clients may connect, and
+	// WebhookACLURL one asked whether they may publish and subscribe, as described
+	// by auth.Webhook. WebhookURL cannot be used with PasswordFile or JWTKeysFile,
+	// nor WebhookACLURL with ACLFile.
+	WebhookURL    string `json:"webhook_url"`
+	WebhookACLURL string `json:"webhook_acl_url"`
+
+	// WebhookTimeout bounds each request, such as "2s". Empty means 5s.
+	WebhookTimeout string `json:"webhook_timeout"`
+
+	// WebhookCacheTTL is how long answers are reused, such as "1m". Empty means no
+	// caching.
+	WebhookCacheTTL string `json:"webhook_cache_ttl"`
+
+	// WebhookFailOpen allows what the endpoints failed to answer about instead of
+	// refusing it
+	WebhookFailOpen bool `json:"webhook_fail_open"`
+
+	// ACLFile is a file of rules telling which Topics Clients may publish and
+	// subscribe to, described by auth.ACL. Without it Clients may use every Topic.
+	ACLFile string `json:"acl_file"`
+}
+
+// Limits bound the resources used by Clients, as described by the fields of
+// server.Server with the same names. Zero means no limit, or the default queue
+// size for QueueSize.
+type Limits struct {
+	MaxConnections int `json:"max_connections"`
+	MaxPacketSize  int `json:"max_packet_size"`
+	QueueSize      int `json:"queue_size"`
+}
+
+// Log configures logging
+type Log struct {
+	// Level is debug, info, warn or error
+	Level string `json:"level"`
+
+	// Format is text or json
+	Format string `json:"format"`
+}
+
+// Default returns the configuration used when there is no file: a TCP listener on
+// port 1883 accepting every Client, and info logging as text
+func Default() *Config {
+	return &Config{
+		Listeners: []Listener{{Type: "tcp", Address: ":1883"}},
+		Auth:      Auth{AllowAnonymous: true},
+		Log:       Log{Level: "info", Format: "text"},
+	}
+}
+
+// Load reads the file at path over Default, applies the environment variables and
+// validates the result
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Parse decodes data, the contents of the file called name, over Default and
+// validates the result. Errors are prefixed with name and the line they refer to.
+func Parse(name string, data []byte) (*Config, error) {
+	lines, err := index(data)
+	if err != nil {
+		return nil, locate(name, data, lines, err)
+	}
+
+	c := Default()
+	// The listeners of the file replace the default ones instead of being merged
+	// into them
+	if _, ok := lines["listeners"]; ok {
+		c.Listeners = nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, locate(name, data, lines, err)
+	}
+
+	if errs := c.validate(); len(errs) > 0 {
+		for i, err := range errs {
+			errs[i] = locate(name, data, lines, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+	return c, nil
+}
+
+// ApplyEnv overrides settings with the environment variables returned by lookup,
+// os.LookupEnv for instance, and validates the result
+func (c *Config) ApplyEnv(lookup func(key string) (string, bool)) error {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		section := v.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		prefix := EnvPrefix + strings.ToUpper(jsonName(v.Type().Field(i))) + "_"
+		for j := 0; j < section.NumField(); j++ {
+			key := prefix + strings.ToUpper(jsonName(section.Type().Field(j)))
+			s, ok := lookup(key)
+			if !ok {
+				continue
+			}
+			if err := setString(section.Field(j), s); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	return c.Validate()
+}
+
+// Validate returns the invalid settings of c, each one as a *SettingError
+func (c *Config) Validate() error {
+	return errors.Join(c.validate()...)
+}
+
+// setString sets a string, int or bool setting from its text
+func setString(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.Unwrap(err)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.Unwrap(err)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+// jsonName returns the name of a field in the file
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// SettingError is an invalid setting
+type SettingError struct {
+	// Path names the setting like listeners[0].address
+	Path string
+	Err  error
+}
+
+func (e *SettingError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *SettingError) Unwrap() error {
+	return e.Err
+}
+
+// invalid returns a SettingError
+func invalid(path string, format string, a ...any) error {
+	return &SettingError{Path: path, Err: fmt.Errorf(format, a...)}
+}
+
+// validate returns every invalid setting
+func (c *Config) validate() []error {
+	var errs []error
+	if len(c.Listeners) == 0 {
+		errs = append(errs, &SettingError{Path: "listeners", Err: ErrNoListener})
+	}
+	addrs := map[string]int{}
+	for i, l := range c.Listeners {
+		path := fmt.Sprintf("listeners[%d]", i)
+		switch {
+		case l.Type != "tcp" && l.Type != "unix" && !l.Secure() && !l.WebSocket():
+			errs = append(errs, invalid(path+".type", "unknown listener type %q", l.Type))
+		case l.Secure() && l.TLS == nil:
+			errs = append(errs, invalid(path+".tls", "required by a %s listener", l.Type))
+		case l.Secure():
+			errs = append(errs, l.TLS.validate(path+".tls")...)
+		case l.TLS != nil:
+			errs = append(errs, invalid(path+".tls", "only allowed for a tls listener"))
+		}
+		if l.Path != "" && (!l.WebSocket() || !strings.HasPrefix(l.Path, "/")) {
+			errs = append(errs, invalid(path+".path", "must start with / and is only allowed for a ws or wss listener"))
+		}
+		if m, err := strconv.ParseUint(l.Mode, 8, 32); l.Mode != "" && (l.Type != "unix" || err != nil || m > 0777) {
+			errs = append(errs, invalid(path+".mode", "must be octal permissions such as 0660 and is only allowed for a unix listener"))
+		}
+		if len(l.ProxyTrusted) > 0 && !l.ProxyProtocol {
+			errs = append(errs, invalid(path+".proxy_trusted", "requires %s.proxy_protocol", path))
+		}
+		if l.ProxyProtocol && l.Type == "unix" && c.Auth.UnixPeerUID {
+			// The process at the other end of the socket is the proxy
+			errs = append(errs, invalid(path+".proxy_protocol", "cannot be used on a unix listener with auth.unix_peer_uid"))
+		}
+		for j, s := range l.ProxyTrusted {
+			if _, err := auth.ParseNetwork(s); err != nil {
+				errs = append(errs, invalid(fmt.Sprintf("%s.proxy_trusted[%d]", path, j), "%q is not an IP address or network", s))
+			}
+		}
+		if l.Type == "unix" {
+			if l.Address == "" {
+				errs = append(errs, invalid(path+".address", "required by a unix listener"))
+			}
+		} else if _, port, err := net.SplitHostPort(l.Address); err != nil {
+			errs = append(errs, invalid(path+".address", "%v", err))
+		} else if _, err := net.LookupPort("tcp", port); err != nil {
+			errs = append(errs, invalid(path+".address", "%v", err))
+		}
+		if j, ok := addrs[l.Address]; ok {
+			errs = append(errs, invalid(path+".address", "%q is used by listeners[%d] too", l.Address, j))
+		}
+		addrs[l.Address] = i
+	}
+
+	if !c.Auth.AllowAnonymous && c.Auth.PasswordFile == "" && c.Auth.CertUserName == "" && !c.Auth.UnixPeerUID && c.Auth.JWTKeysFile == "" && c.Auth.WebhookURL == "" {
+		errs = append(errs, invalid("auth.allow_anonymous", "false requires auth.password_file, auth.cert_user_name, auth.unix_peer_uid, auth.jwt_keys_file or auth.webhook_url"))
+	}
+	if c.Auth.PasswordFile != "" && c.Auth.JWTKeysFile != "" {
+		errs = append(errs, invalid("auth.jwt_keys_file", "cannot be used with auth.password_file"))
+	}
+	if c.Auth.WebhookURL != "" && (c.Auth.PasswordFile != "" || c.Auth.JWTKeysFile != "") {
+		errs = append(errs, invalid("auth.webhook_url", "cannot be used with auth.password_file or auth.jwt_keys_file"))
+	}
+	if c.Auth.WebhookACLURL != "" && c.Auth.ACLFile != "" {
+		errs = append(errs, invalid("auth.webhook_acl_url", "cannot be used with auth.acl_file"))
+	}
+	for _, s := range []struct{ path, url string }{{"auth.webhook_url", c.Auth.WebhookURL}, {"auth.webhook_acl_url", c.Auth.WebhookACLURL}} {
+		if u, err := url.Parse(s.url); s.url != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+			errs = append(errs, invalid(s.path, "must be an http or https URL"))
+		}
+	}
+	for _, s := range []struct{ path, d string }{{"auth.webhook_timeout", c.Auth.WebhookTimeout}, {"auth.webhook_cache_ttl", c.Auth.WebhookCacheTTL}} {
+		if d, err := time.ParseDuration(s.d); s.d != "" && (err != nil || d < 0) {
+			errs = append(errs, invalid(s.path, "must be a duration such as 1m30s"))
+		}
+	}
+	if c.Auth.CertUserName != "" && !auth.ValidCertificateField(auth.CertificateField(c.Auth.CertUserName)) {
+		errs = append(errs, invalid("auth.cert_user_name", "must be cn, dns, email or uri"))
+	}
+
+	if c.Limits.MaxConnections < 0 {
+		errs = append(errs, invalid("limits.max_connections", "must not be negative"))
+	}
+	if c.Limits.MaxPacketSize < 0 || c.Limits.MaxPacketSize > maxPacketSize {
+		errs = append(errs, invalid("limits.max_packet_size", "must be between 0 and %d", maxPacketSize))
+	}
+	if c.Limits.QueueSize < 0 {
+		errs = append(errs, invalid("limits.queue_size", "must not be negative"))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, invalid("log.level", "must be debug, info, warn or error"))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, invalid("log.format", "must be text or json"))
+	}
+	return errs
+}
+
+// validate returns every invalid setting of a TLS section at path
+func (t *TLS) validate(path string) []error {
+	var errs []error
+	if t.CertFile == "" {
+		errs = append(errs, invalid(path+".cert_file", "required by a tls listener"))
+	}
+	if _, ok := tlsVersions[t.MinVersion]; !ok && t.MinVersion != "" {
+		errs = append(errs, invalid(path+".min_version", "must be 1.0, 1.1, 1.2 or 1.3"))
+	}
+	for i, name := range t.CipherSuites {
+		if _, ok := cipherSuite(name); !ok {
+			errs = append(errs, invalid(fmt.Sprintf("%s.cipher_suites[%d]", path, i), "unknown cipher suite %q", name))
+		}
+	}
+	auth, ok := clientAuthTypes[t.ClientAuth]
+	if !ok {
+		errs = append(errs, invalid(path+".client_auth", "must be none, optional or required"))
+	}
+	if auth != tls.NoClientCert && t.ClientCAFile == "" {
+		errs = append(errs, invalid(path+".client_auth", "%s requires %s.client_ca_file", t.ClientAuth, path))
+	}
+	return errs
+}
+
+// LogLevel returns the level of Log.Level
+func (c *Config) LogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Log.Level))
+	return level
+}
+
+// Duration returns the value of a valid duration setting, zero if it is empty
+func Duration(s string) time.Duration {
+	d, _ := time.ParseDuration(s)
+	return d
+}
+
+// String returns the configuration as indented JSON
+func (c *Config) String() string {
+	b, _ := json.MarshalIndent(c, "", "\t")
+	return string(b)
+}
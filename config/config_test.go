@@ -0,0 +1,148 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`{
+	"listeners": [
+		{"type": "tcp", "address": "127.0.0.1:1883"},
+		{"type": "tcp", "address": ":1884", "proxy_protocol": true, "proxy_trusted": ["10.0.0.0/8", "192.0.2.1"]},
+		{"type": "ws", "address": ":8080", "path": "/dashboard/mqtt"},
+		{"type": "unix", "address": "/run/mammoth.sock", "mode": "0660"}
+	],
+	"limits": {"max_connections": 100},
+	"log": {"level": "debug"}
+}`)
+	c, err := Parse("mammoth.json", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Listeners: []Listener{{Type: "tcp", Address: "127.0.0.1:1883"}, {Type: "tcp", Address: ":1884", ProxyProtocol: true, ProxyTrusted: []string{"10.0.0.0/8", "192.0.2.1"}},
+			{Type: "ws", Address: ":8080", Path: "/dashboard/mqtt"}, {Type: "unix", Address: "/run/mammoth.sock", Mode: "0660"}},
+		Auth:   Auth{AllowAnonymous: true},
+		Limits: Limits{MaxConnections: 100},
+		Log:    Log{Level: "debug", Format: "text"},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Parse() = %v, want %v", c, want)
+	}
+
+	c, err = Parse("tls.json", []byte(`{"listeners": [{"type": "tls", "address": ":8883", "tls": {
+	"cert_file": "server.crt",
+	"min_version": "1.3",
+	"cipher_suites": ["TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"],
+	"client_auth": "optional",
+	"client_ca_file": "ca.crt"
+}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := c.Listeners[0]
+	if l.TLS.Version() != tls.VersionTLS13 || l.TLS.ClientAuthType() != tls.VerifyClientCertIfGiven ||
+		!reflect.DeepEqual(l.TLS.CipherSuiteIDs(), []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}) {
+		t.Errorf("Parse() TLS = %+v", l.TLS)
+	}
+
+	c, err = Parse("empty.json", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, Default()) {
+		t.Errorf("Parse({}) = %v, want %v", c, Default())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"{\n\t\"limits\": {\n\t\t\"max_connections\": 1,\n\t}\n}", `c.json:4: invalid character '}' looking for beginning of object key string`},
+		{"{\n\t\"limits\": {\"max_connections\": \"many\"}\n}", `c.json:2: limits.max_connections: cannot be a JSON string`},
+		{"{\n\t\"log\": {\n\t\t\"colour\": true\n\t}\n}", `c.json:3: unknown setting "colour"`},
+		{"{\n\t\"log\": {\n\t\t\"level\": \"loud\"\n\t}\n}", `c.json:3: log.level: must be debug, info, warn or error`},
+		{"{\n\t\"listeners\": []\n}", `c.json:2: listeners: at least one listener is required`},
+		{"{\"listeners\": [\n\t{\"type\": \"tcp\", \"address\": \":1883\"},\n\t{\"type\": \"udp\", \"address\": \":1883\"}\n]}",
+			"c.json:3: listeners[1].type: unknown listener type \"udp\"\nc.json:3: listeners[1].address: \":1883\" is used by listeners[0] too"},
+		{"{\"listeners\": [{\"type\": \"tcp\"}]}", `c.json:1: listeners[0].address: missing port in address`},
+		{"{\n\t\"auth\": {\"allow_anonymous\": false}\n}", `c.json:2: auth.allow_anonymous: false requires auth.password_file, auth.cert_user_name, auth.unix_peer_uid, auth.jwt_keys_file or auth.webhook_url`},
+		{"{\"auth\": {\n\t\"webhook_url\": \"ftp://auth\",\n\t\"webhook_acl_url\": \"http://auth/acl\",\n\t\"acl_file\": \"acl\",\n\t\"webhook_cache_ttl\": \"-1s\"\n}}",
+			"c.json:3: auth.webhook_acl_url: cannot be used with auth.acl_file\nc.json:2: auth.webhook_url: must be an http or https URL\nc.json:5: auth.webhook_cache_ttl: must be a duration such as 1m30s"},
+		{"{\"auth\": {\n\t\"password_file\": \"passwd\",\n\t\"jwt_keys_file\": \"jwks.json\"\n}}", `c.json:3: auth.jwt_keys_file: cannot be used with auth.password_file`},
+		{"{\n\t\"auth\": {\"cert_user_name\": \"serial\"}\n}", `c.json:2: auth.cert_user_name: must be cn, dns, email or uri`},
+		{"{}\n{}", `c.json:2: invalid character '{' after top-level value`},
+		{"{\"listeners\": [\n\t{\"type\": \"tls\", \"address\": \":8883\"}\n]}", `c.json:2: listeners[0].tls: required by a tls listener`},
+		{"{\"listeners\": [\n\t{\"type\": \"tcp\", \"address\": \":1883\", \"tls\": {}}\n]}", `c.json:2: listeners[0].tls: only allowed for a tls listener`},
+		{"{\"listeners\": [\n\t{\"type\": \"tcp\", \"address\": \":1883\", \"proxy_trusted\": [\"10.0.0.0/8\"]},\n\t{\"type\": \"tcp\", \"address\": \":1884\", \"proxy_protocol\": true, \"proxy_trusted\": [\"10.0.0.0/33\"]}\n]}",
+			"c.json:2: listeners[0].proxy_trusted: requires listeners[0].proxy_protocol\nc.json:3: listeners[1].proxy_trusted[0]: \"10.0.0.0/33\" is not an IP address or network"},
+		{"{\"listeners\": [\n\t{\"type\": \"unix\", \"address\": \"/run/mqtt.sock\", \"proxy_protocol\": true}\n],\n\"auth\": {\"unix_peer_uid\": true}}",
+			`c.json:2: listeners[0].proxy_protocol: cannot be used on a unix listener with auth.unix_peer_uid`},
+		{"{\"listeners\": [\n\t{\"type\": \"unix\", \"mode\": \"0999\"},\n\t{\"type\": \"tcp\", \"address\": \":1883\", \"mode\": \"0600\"}\n]}",
+			"c.json:2: listeners[0].mode: must be octal permissions such as 0660 and is only allowed for a unix listener\nc.json:2: listeners[0].address: required by a unix listener\nc.json:3: listeners[1].mode: must be octal permissions such as 0660 and is only allowed for a unix listener"},
+		{"{\"listeners\": [\n\t{\"type\": \"wss\", \"address\": \":8443\"},\n\t{\"type\": \"tcp\", \"address\": \":1883\", \"path\": \"/mqtt\"},\n\t{\"type\": \"ws\", \"address\": \":8080\", \"path\": \"mqtt\"}\n]}",
+			"c.json:2: listeners[0].tls: required by a wss listener\nc.json:3: listeners[1].path: must start with / and is only allowed for a ws or wss listener\nc.json:4: listeners[2].path: must start with / and is only allowed for a ws or wss listener"},
+		{"{\"listeners\": [{\"type\": \"tls\", \"address\": \":8883\", \"tls\": {\n\t\"cert_file\": \"s.crt\",\n\t\"min_version\": \"1.4\",\n\t\"cipher_suites\": [\"TLS_NULL\"],\n\t\"client_auth\": \"required\"\n}}]}",
+			"c.json:3: listeners[0].tls.min_version: must be 1.0, 1.1, 1.2 or 1.3\nc.json:4: listeners[0].tls.cipher_suites[0]: unknown cipher suite \"TLS_NULL\"\nc.json:5: listeners[0].tls.client_auth: required requires listeners[0].tls.client_ca_file"},
+	}
+	for _, tt := range tests {
+		_, err := Parse("c.json", []byte(tt.data))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Parse(%q) error = %v, want %s", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"MAMMOTH_LOG_LEVEL":              "warn",
+		"MAMMOTH_LIMITS_MAX_PACKET_SIZE": "1024",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	c := Default()
+	if err := c.ApplyEnv(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if c.Log.Level != "warn" || c.Limits.MaxPacketSize != 1024 {
+		t.Errorf("ApplyEnv() = %v", c)
+	}
+
+	env["MAMMOTH_LIMITS_QUEUE_SIZE"] = "lots"
+	if err := Default().ApplyEnv(lookup); err == nil || err.Error() != "MAMMOTH_LIMITS_QUEUE_SIZE: invalid syntax" {
+		t.Errorf("ApplyEnv() error = %v", err)
+	}
+	env["MAMMOTH_LIMITS_QUEUE_SIZE"] = "-1"
+	var se *SettingError
+	if err := Default().ApplyEnv(lookup); !errors.As(err, &se) || se.Path != "limits.queue_size" {
+		t.Errorf("ApplyEnv() error = %v, want limits.queue_size", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mammoth.json")
+	os.WriteFile(path, []byte(`{"log": {"format": "json"}}`), 0600)
+	t.Setenv("MAMMOTH_LOG_LEVEL", "error")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Log.Format != "json" || c.Log.Level != "error" {
+		t.Errorf("Load() = %v", c)
+	}
+	if !strings.Contains(c.String(), `"level": "error"`) {
+		t.Errorf("String() = %s", c)
+	}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// index walks the JSON document data and returns the line of every setting by its
+// path, like listeners[0].address. It returns a *json.SyntaxError if data is not
+// valid JSON.
+func index(data []byte) (map[string]int, error) {
+	// Unmarshal describes syntax errors better than the tokenizer
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				off := dec.InputOffset()
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				p := key.(string)
+				if path != "" {
+					p = path + "." + p
+				}
+				lines[p] = lineOf(data, off)
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				lines[p] = lineOf(data, dec.InputOffset())
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+
+	return lines, walk("")
+}
+
+// lineOf returns the line of the first token at or after offset off of data
+func lineOf(data []byte, off int64) int {
+	off = min(max(off, 0), int64(len(data)))
+	for off < int64(len(data)) && strings.IndexByte(" \t\r\n:,", data[off]) >= 0 {
+		off++
+	}
+	return bytes.Count(data[:off], []byte("\n")) + 1
+}
+
+// locate prefixes err with the name of the file and, when it is known, the line
+// err refers to
+func locate(name string, data []byte, lines map[string]int, err error) error {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	var setting *SettingError
+	switch {
+	case errors.As(err, &syntax):
+		return fmt.Errorf("%s:%d: %v", name, lineOf(data, syntax.Offset-1), strings.TrimPrefix(err.Error(), "json: "))
+	case errors.As(err, &typ):
+		line := lineOf(data, typ.Offset-1)
+		return fmt.Errorf("%s:%d: %s: cannot be a JSON %s", name, line, typ.Field, typ.Value)
+	case errors.As(err, &setting):
+		path := setting.Path
+		for path != "" {
+			if line, ok := lines[path]; ok {
+				return fmt.Errorf("%s:%d: %w", name, line, err)
+			}
+			path = parent(path)
+		}
+	}
+
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		// The decoder does not tell where the field is, so the first setting of the
+		// file with that name is reported
+		key := strings.Trim(field, `"`)
+		line := 0
+		for path, l := range lines {
+			if (path == key || strings.HasSuffix(path, "."+key)) && (line == 0 || l < line) {
+				line = l
+			}
+		}
+		if line > 0 {
+			return fmt.Errorf("%s:%d: unknown setting %q", name, line, key)
+		}
+		return fmt.Errorf("%s: unknown setting %q", name, key)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// parent returns the path of the setting holding the setting at path
+func parent(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
@@ -0,0 +1,3 @@
+module github.com/Den3/mammoth
+
+go 1.25
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix listens on the unix socket at path, changed to mode unless it is
+// zero, after it was created. The socket cannot be bound with mode from the start.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	ln, err := net.Listen("unix", path)
+	if err != nil || mode == 0 {
+		return ln, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListenUnixMode(t *testing.T) {
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mqtt.sock")
+	ln, err := listenUnix(path, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0660 {
+		t.Errorf("socket mode = %v, want -rw-rw----", fi.Mode().Perm())
+	}
+	if umask := syscall.Umask(0); umask != 0 {
+		t.Errorf("umask = %#o after listenUnix(), want 0", umask)
+	}
+	if got := ln.Addr().String(); got != path {
+		t.Errorf("Addr() = %s, want %s", got, path)
+	}
+	if c, err := net.Dial("unix", path); err != nil {
+		t.Error(err)
+	} else {
+		c.Close()
+	}
+
+	// Only the socket is left in the directory, and closing removes it
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want the socket only", len(entries))
+	}
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Stat() after Close() error = %v, want not exist", err)
+	}
+}
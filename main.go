@@ -1,16 +1,91 @@
+// Command mammoth is an MQTT broker.
+//
+// Usage:
+//
+//	mammoth serve [flags]          run the broker
+//	mammoth check-config [flags]   check the configuration of serve and print it
+//	mammoth passwd [flags] file user
+//	                               add, change or delete a user of a password file
+//	mammoth version                print the version
+//
+// serve reloads its configuration on SIGHUP, applying the settings which can
+// change without dropping the connected Clients.
+//
+// The exit code is 0 on success, 1 on failure and 2 on a usage error.
 package main
 
 import (
-	"log"
-
-	"github.com/Den3/mammoth/server"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 )
 
+// version is the version of the broker, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3"
+var version = "devel"
+
+// command is a subcommand of mammoth returning its exit code
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int
+}
+
+var commands = []command{
+	{"serve", "run the broker", runServe},
+	{"check-config", "check the configuration of serve and print it", runCheckConfig},
+	{"passwd", "add, change or delete a user of a password file", runPasswd},
+	{"version", "print the version", runVersion},
+}
+
 func main() {
-	s := &server.Server{}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the subcommand named by args[0] and returns its exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(stdout)
+		return 0
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(ctx, args[1:], stdin, stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "mammoth: unknown command %q\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: mammoth <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "mammoth <command> -h" for the flags of a command.`)
+}
 
-	err := s.Listen()
-	if err != nil {
-		log.Fatal(err)
+func runVersion(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		fmt.Fprintln(stderr, "mammoth version: unexpected arguments")
+		return 2
 	}
+	fmt.Fprintf(stdout, "mammoth %s %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
 }
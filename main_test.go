@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/client"
+	"github.com/Den3/mammoth/config"
+	"github.com/Den3/mammoth/message"
+)
+
+// runArgs runs mammoth with args and returns its exit code and output
+func runArgs(ctx context.Context, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(ctx, args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, 2},
+		{[]string{"help"}, 0},
+		{[]string{"unknown"}, 2},
+		{[]string{"version", "extra"}, 2},
+		{[]string{"serve", "-unknown"}, 2},
+		{[]string{"serve", "extra"}, 2},
+		{[]string{"serve", "-h"}, 0},
+		{[]string{"check-config"}, 0},
+		{[]string{"check-config", "-addr", "127.0.0.1"}, 1},
+		{[]string{"check-config", "-addr", ":nonsense"}, 1},
+		{[]string{"passwd", "file"}, 2},
+		{[]string{"passwd", "file", "a:b"}, 2},
+	}
+	for _, tt := range tests {
+		if code, _, _ := runArgs(context.Background(), "", tt.args...); code != tt.code {
+			t.Errorf("run(%q) = %d, want %d", tt.args, code, tt.code)
+		}
+	}
+}
+
+func TestRunCheckConfig(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"limits": {"max_connections": 10}}`), 0600)
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte("{\n\t\"log\": {\"level\": \"loud\"}\n}"), 0600)
+	t.Setenv("MAMMOTH_LOG_FORMAT", "json")
+
+	code, stdout, stderr := runArgs(context.Background(), "", "check-config", "-config", valid, "-addr", "127.0.0.1:1999")
+	if code != 0 {
+		t.Fatalf("check-config = %d: %s", code, stderr)
+	}
+	for _, want := range []string{`"max_connections": 10`, `"format": "json"`, `"address": "127.0.0.1:1999"`} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("check-config printed %s, want %s in it", stdout, want)
+		}
+	}
+
+	t.Setenv("MAMMOTH_AUTH_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	if code, _, stderr := runArgs(context.Background(), "", "check-config", "-config", valid); code != 1 || !strings.Contains(stderr, "missing") {
+		t.Errorf("check-config with a missing password file = %d, %q", code, stderr)
+	}
+	t.Setenv("MAMMOTH_AUTH_PASSWORD_FILE", "")
+
+	tlsConfig := filepath.Join(dir, "tls.json")
+	os.WriteFile(tlsConfig, []byte(`{"listeners": [{"type": "tls", "address": ":8883", "tls": {"cert_file": "`+filepath.Join(dir, "missing.crt")+`"}}]}`), 0600)
+	if code, _, stderr := runArgs(context.Background(), "", "check-config", "-config", tlsConfig); code != 1 || !strings.Contains(stderr, "listeners[0]: open") {
+		t.Errorf("check-config with a missing certificate = %d, %q", code, stderr)
+	}
+
+	code, _, stderr = runArgs(context.Background(), "", "check-config", "-config", invalid)
+	if want := invalid + ":2: log.level: must be debug, info, warn or error"; code != 1 || !strings.Contains(stderr, want) {
+		t.Errorf("check-config = %d, %q, want 1 and %s", code, stderr, want)
+	}
+}
+
+func TestRunVersion(t *testing.T) {
+	code, stdout, _ := runArgs(context.Background(), "", "version")
+	if code != 0 || !strings.HasPrefix(stdout, "mammoth devel go") {
+		t.Errorf("run(version) = %d, %q", code, stdout)
+	}
+}
+
+func TestRunPasswd(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "passwd")
+
+	if code, _, stderr := runArgs(ctx, "secret\n", "passwd", "-iterations", "10", path, "alice"); code != 1 {
+		t.Errorf("passwd without -c on a missing file = %d, %s", code, stderr)
+	}
+	if code, _, stderr := runArgs(ctx, "secret\n", "passwd", "-c", "-iterations", "10", path, "alice"); code != 0 {
+		t.Fatalf("passwd -c = %d: %s", code, stderr)
+	}
+	if code, _, stderr := runArgs(ctx, "", "passwd", "-b", "other", "-iterations", "10", path, "bob"); code != 0 {
+		t.Fatalf("passwd -b = %d: %s", code, stderr)
+	}
+
+	users, err := auth.ReadPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := auth.CheckPassword(users["alice"], "secret"); !ok {
+		t.Error("password of alice was not read from stdin")
+	}
+	if ok, _ := auth.CheckPassword(users["bob"], "other"); !ok {
+		t.Error("password of bob was not taken from -b")
+	}
+
+	if code, _, stderr := runArgs(ctx, "", "passwd", "-D", path, "alice"); code != 0 {
+		t.Fatalf("passwd -D = %d: %s", code, stderr)
+	}
+	if code, _, _ := runArgs(ctx, "", "passwd", "-D", path, "alice"); code != 1 {
+		t.Errorf("passwd -D of a missing user = %d, want 1", code)
+	}
+	users, _ = auth.ReadPasswordFile(path)
+	if _, ok := users["alice"]; ok || len(users) != 1 {
+		t.Errorf("users after delete = %v, want bob only", users)
+	}
+}
+
+func TestRunServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan int, 1)
+	go func() {
+		code, _, _ := runArgs(ctx, "", "serve", "-addr", addr)
+		exited <- code
+	}()
+
+	// Connect once the broker listens
+	c := &client.Client{ConnectTimeout: time.Second}
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("served"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := c.Connect(context.Background(), addr, connect)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Errorf("serve exited with %d, want 0", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not stop")
+	}
+	<-c.Done()
+}
+
+func TestListenUnix(t *testing.T) {
+	l := config.Listener{Type: "unix", Address: filepath.Join(t.TempDir(), "mqtt.sock"), Mode: "0600"}
+
+	// The socket of a broker which did not stop cleanly is replaced
+	stale, err := net.Listen("unix", l.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := listen(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if fi, err := os.Stat(l.Address); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, want -rw-------", fi.Mode().Perm())
+	}
+
+	// The socket of a running broker is kept
+	if _, err := listen(l); err == nil {
+		t.Error("listen() on the socket of a running broker succeeded")
+	}
+	if c, err := net.Dial("unix", l.Address); err != nil {
+		t.Error(err)
+	} else {
+		c.Close()
+	}
+}
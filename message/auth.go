@@ -0,0 +1,96 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AuthMessage is that an AUTH Packet is sent from Client to Server or Server to Client
+// as part of an extended authentication exchange, such as challenge / response
+// authentication. It is available since MQTT 5.0 and it is a Protocol Error for the
+// Client or Server to send an AUTH Packet if the CONNECT Packet did not contain the
+// same Authentication Method.
+type AuthMessage struct {
+	// Bits 3, 2, 1 and 0 of the fixed header of the AUTH Packet are reserved and MUST
+	// all be set to 0. The Client or Server MUST treat any other value as malformed and
+	// close the Network Connection [MQTT-3.15.1-1].
+	fixedHeader
+
+	// The Authenticate Reason Code is one of 0x00 (Success), 0x18 (Continue
+	// authentication) and 0x19 (Re-authenticate). The Reason Code and Properties
+	// can be omitted if the Reason Code is 0x00 and there are no Properties.
+	reasonCode byte
+	properties Properties
+}
+
+// NewAuthMessage returns a pointer of AuthMessage
+func NewAuthMessage() *AuthMessage {
+	a := &AuthMessage{}
+	a.SetControlPacketType(AUTH)
+	a.SetVersion(Version5)
+	return a
+}
+
+// SetReasonCode sets Reason Code
+func (a *AuthMessage) SetReasonCode(v byte) {
+	a.reasonCode = v
+}
+
+// ReasonCode returns Reason Code
+func (a *AuthMessage) ReasonCode() byte {
+	return a.reasonCode
+}
+
+// SetProperties sets Properties
+func (a *AuthMessage) SetProperties(v Properties) {
+	a.properties = v
+}
+
+// Properties returns Properties
+func (a *AuthMessage) Properties() Properties {
+	return a.properties
+}
+
+// Len returns the length of the encoded packet
+func (a *AuthMessage) Len() int {
+	ml := reasonLen(a.reasonCode, a.properties)
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (a *AuthMessage) Encode(dest []byte) (int, error) {
+	if !a.v5() {
+		return 0, ErrPacketTypeInvalid
+	}
+	return encodeReason(&a.fixedHeader, dest, a.reasonCode, a.properties)
+}
+
+// Decode reads the packet from src
+func (a *AuthMessage) Decode(src []byte) (int, error) {
+	if !a.v5() {
+		return 0, ErrPacketTypeInvalid
+	}
+	rc, props, n, err := decodeReason(&a.fixedHeader, src, AUTH)
+	if err != nil {
+		return 0, err
+	}
+	a.reasonCode, a.properties = rc, props
+	return n, nil
+}
+
+// String returns a short description of the packet
+func (a *AuthMessage) String() string {
+	if !a.v5() || (a.reasonCode == Success && len(a.properties) == 0) {
+		return "AUTH"
+	}
+	return fmt.Sprintf("AUTH(rc=0x%02x%s)", a.reasonCode, propsString(a.properties))
+}
+
+// MarshalJSON implements json.Marshaler
+func (a *AuthMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		ReasonCode byte       `json:"reasonCode"`
+		Properties Properties `json:"properties,omitempty"`
+	}{"AUTH", a.reasonCode, a.properties})
+}
@@ -0,0 +1,60 @@
+package message
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer is the largest capacity kept in the pool. Larger packets get a
+// Buffer of their own which is left to the garbage collector once released.
+const maxPooledBuffer = 64 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &Buffer{}
+	},
+}
+
+// Buffer is a reference counted byte slice taken from a pool. Messages decoded by
+// ReadPacketBuffer refer to the bytes of a Buffer, so it can only go back to the
+// pool after the last user of those messages is done with them.
+//
+// Every holder of a reference calls Release exactly once. A Buffer which is never
+// released is not leaked, it is just garbage collected instead of being reused.
+type Buffer struct {
+	b    []byte
+	refs int32
+}
+
+// NewBuffer returns a Buffer of n bytes holding one reference
+func NewBuffer(n int) *Buffer {
+	buf := bufferPool.Get().(*Buffer)
+	if cap(buf.b) < n {
+		buf.b = make([]byte, n)
+	}
+	buf.b = buf.b[:n]
+	buf.refs = 1
+	return buf
+}
+
+// Bytes returns the bytes of the Buffer
+func (buf *Buffer) Bytes() []byte {
+	return buf.b
+}
+
+// Retain adds a reference to the Buffer
+func (buf *Buffer) Retain() {
+	atomic.AddInt32(&buf.refs, 1)
+}
+
+// Release drops a reference to the Buffer and puts it back to the pool when it was
+// the last one
+func (buf *Buffer) Release() {
+	refs := atomic.AddInt32(&buf.refs, -1)
+	if refs < 0 {
+		panic("message: Buffer released more times than retained")
+	}
+	if refs == 0 && cap(buf.b) <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
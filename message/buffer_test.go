@@ -0,0 +1,84 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferRelease(t *testing.T) {
+	buf := NewBuffer(16)
+	if len(buf.Bytes()) != 16 {
+		t.Error("Buffer length should be 16")
+	}
+
+	buf.Retain()
+	buf.Release()
+	buf.Release()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Buffer released too many times should panic")
+		}
+	}()
+	buf.Release()
+}
+
+func TestReadPacketBufferZeroCopy(t *testing.T) {
+	p := NewPublishMessage()
+	p.SetTopicName([]byte("a/b"))
+	p.SetPayload(make([]byte, 1024))
+	src := make([]byte, p.Len())
+	p.Encode(src)
+
+	m, buf, err := ReadPacketBuffer(bytes.NewReader(src), Version311)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Release()
+
+	payload := m.(*PublishMessage).Payload()
+	if len(payload) != 1024 {
+		t.Fatalf("expected 1024 bytes payload, got %d", len(payload))
+	}
+	if &payload[0] != &buf.Bytes()[len(src)-1024] {
+		t.Error("Payload should refer to the Buffer")
+	}
+}
+
+func BenchmarkReadPacketBuffer(b *testing.B) {
+	p := NewPublishMessage()
+	p.SetTopicName([]byte("sensors/room1/temp"))
+	p.SetPayload(make([]byte, 1024))
+	src := make([]byte, p.Len())
+	p.Encode(src)
+	r := bytes.NewReader(src)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		r.Reset(src)
+		_, buf, err := ReadPacketBuffer(r, Version311)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf.Release()
+	}
+}
+
+func BenchmarkReadPacket(b *testing.B) {
+	p := NewPublishMessage()
+	p.SetTopicName([]byte("sensors/room1/temp"))
+	p.SetPayload(make([]byte, 1024))
+	src := make([]byte, p.Len())
+	p.Encode(src)
+	r := bytes.NewReader(src)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		r.Reset(src)
+		if _, err := ReadPacket(r, Version311); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package message
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// maxVarint is the largest value a Variable Byte Integer can carry
+const maxVarint = 268435455
+
+// varintLen returns the number of bytes used to encode v as a Variable Byte Integer
+func varintLen(v uint32) int {
+	n := 1
+	for v >= 128 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// putVarint writes v as a Variable Byte Integer and returns the number of bytes written
+func putVarint(dest []byte, v uint32) int {
+	return binary.PutUvarint(dest, uint64(v))
+}
+
+// readVarint reads a Variable Byte Integer of at most 4 bytes. The encoded value
+// MUST use the minimum number of bytes necessary to represent the value [MQTT-1.5.5-1].
+func readVarint(src []byte) (uint32, int, error) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		if i >= len(src) {
+			return 0, 0, ErrMalformedPacket
+		}
+		v |= uint32(src[i]&0x7F) << (7 * uint(i))
+		if src[i]&0x80 == 0 {
+			if i > 0 && src[i] == 0 {
+				return 0, 0, ErrMalformedReaminingLength
+			}
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, ErrMalformedReaminingLength
+}
+
+// readUint16 reads a Two Byte Integer
+func readUint16(src []byte) (uint16, int, error) {
+	if len(src) < 2 {
+		return 0, 0, ErrMalformedPacket
+	}
+	return binary.BigEndian.Uint16(src), 2, nil
+}
+
+// readUint32 reads a Four Byte Integer
+func readUint32(src []byte) (uint32, int, error) {
+	if len(src) < 4 {
+		return 0, 0, ErrMalformedPacket
+	}
+	return binary.BigEndian.Uint32(src), 4, nil
+}
+
+// readBytes reads Binary Data, a Two Byte Integer length followed by that many
+// bytes. The returned slice refers to src.
+func readBytes(src []byte) ([]byte, int, error) {
+	l, n, err := readUint16(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(src)-n < int(l) {
+		return nil, 0, ErrMalformedPacket
+	}
+	return src[n : n+int(l) : n+int(l)], n + int(l), nil
+}
+
+// readString reads a UTF-8 Encoded String. The character data MUST NOT include
+// the null character U+0000 [MQTT-1.5.3-2].
+func readString(src []byte) ([]byte, int, error) {
+	s, n, err := readBytes(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !validString(s) {
+		return nil, 0, ErrMalformedPacket
+	}
+	return s, n, nil
+}
+
+// putBytes writes b prefixed with its Two Byte Integer length
+func putBytes(dest []byte, b []byte) int {
+	binary.BigEndian.PutUint16(dest, uint16(len(b)))
+	return 2 + copy(dest[2:], b)
+}
+
+// validString reports whether b is well-formed UTF-8 without U+0000
+func validString(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, c := range b {
+		if c == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// readPacketID reads a non-zero Packet Identifier [MQTT-2.3.1-1]
+func readPacketID(src []byte) ([]byte, int, error) {
+	if len(src) < 2 {
+		return nil, 0, ErrMalformedPacket
+	}
+	if src[0] == 0 && src[1] == 0 {
+		return nil, 0, ErrPacketIDInvalid
+	}
+	return src[:2:2], 2, nil
+}
+
+// putPacketID writes a Packet Identifier which must be 2 bytes long
+func putPacketID(dest []byte, id []byte) (int, error) {
+	if len(id) != 2 {
+		return 0, ErrPacketIDInvalid
+	}
+	return copy(dest, id), nil
+}
+
+// ackLen returns the Remaining Length of a PUBACK, PUBREC, PUBREL or PUBCOMP Packet.
+// In MQTT 5.0 the Reason Code and Properties can be omitted when the Reason Code is
+// 0x00 (Success) and there are no Properties.
+func ackLen(fh *fixedHeader, rc byte, props Properties) int {
+	if !fh.v5() || (rc == Success && len(props) == 0) {
+		return 2
+	}
+	if len(props) == 0 {
+		return 3
+	}
+	return 3 + props.encodedLen()
+}
+
+// encodeAck writes a PUBACK, PUBREC, PUBREL or PUBCOMP Packet
+func encodeAck(fh *fixedHeader, dest []byte, packetID []byte, rc byte, props Properties) (int, error) {
+	if fh.v5() && !validReasonCode(fh.ControlPacketType(), rc) {
+		return 0, ErrReasonCodeInvalid
+	}
+	ml := ackLen(fh, rc, props)
+	p, err := fh.encodeAs(dest, ml)
+	if err != nil {
+		return p, err
+	}
+
+	n, err := putPacketID(dest[p:], packetID)
+	if err != nil {
+		return p, err
+	}
+	p += n
+
+	if ml > 2 {
+		dest[p] = rc
+		p++
+	}
+	if ml > 3 {
+		p += props.encode(dest[p:])
+	}
+
+	return p, nil
+}
+
+// decodeAck reads a PUBACK, PUBREC, PUBREL or PUBCOMP Packet
+func decodeAck(fh *fixedHeader, src []byte, cpt byte, cptf byte) ([]byte, byte, Properties, int, error) {
+	p, err := fh.decodeAs(src, cpt, cptf)
+	if err != nil {
+		return nil, 0, nil, 0, err
+	}
+	end := p + int(fh.remainingLength)
+	if fh.remainingLength < 2 || (!fh.v5() && fh.remainingLength != 2) {
+		return nil, 0, nil, 0, ErrMalformedPacket
+	}
+
+	packetID, n, err := readPacketID(src[p:end])
+	if err != nil {
+		return nil, 0, nil, 0, err
+	}
+	p += n
+
+	rc := byte(Success)
+	if p < end {
+		rc = src[p]
+		p++
+		if !validReasonCode(cpt, rc) {
+			return nil, 0, nil, 0, ErrReasonCodeInvalid
+		}
+	}
+
+	var props Properties
+	if p < end {
+		props, n, err = decodeProperties(src[p:end], cpt)
+		if err != nil {
+			return nil, 0, nil, 0, err
+		}
+		p += n
+	}
+	if p != end {
+		return nil, 0, nil, 0, ErrMalformedPacket
+	}
+
+	return packetID, rc, props, p, nil
+}
+
+// encodeEmpty writes a packet which has no variable header and no payload
+func encodeEmpty(fh *fixedHeader, dest []byte) (int, error) {
+	return fh.encodeAs(dest, 0)
+}
+
+// decodeEmpty reads a packet which has no variable header and no payload
+func decodeEmpty(fh *fixedHeader, src []byte, cpt byte) (int, error) {
+	p, err := fh.decodeAs(src, cpt, 0)
+	if err != nil {
+		return 0, err
+	}
+	if fh.remainingLength != 0 {
+		return 0, ErrMalformedPacket
+	}
+	return p, nil
+}
+
+// reasonLen returns the Remaining Length of an MQTT 5.0 DISCONNECT or AUTH Packet,
+// which can omit the Reason Code and Properties when the Reason Code is 0x00 and
+// there are no Properties
+func reasonLen(rc byte, props Properties) int {
+	if rc == Success && len(props) == 0 {
+		return 0
+	}
+	if len(props) == 0 {
+		return 1
+	}
+	return 1 + props.encodedLen()
+}
+
+// encodeReason writes an MQTT 5.0 DISCONNECT or AUTH Packet
+func encodeReason(fh *fixedHeader, dest []byte, rc byte, props Properties) (int, error) {
+	if !validReasonCode(fh.ControlPacketType(), rc) {
+		return 0, ErrReasonCodeInvalid
+	}
+	ml := reasonLen(rc, props)
+	p, err := fh.encodeAs(dest, ml)
+	if err != nil {
+		return p, err
+	}
+
+	if ml > 0 {
+		dest[p] = rc
+		p++
+	}
+	if ml > 1 {
+		p += props.encode(dest[p:])
+	}
+
+	return p, nil
+}
+
+// decodeReason reads an MQTT 5.0 DISCONNECT or AUTH Packet
+func decodeReason(fh *fixedHeader, src []byte, cpt byte) (byte, Properties, int, error) {
+	p, err := fh.decodeAs(src, cpt, 0)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	end := p + int(fh.remainingLength)
+
+	rc := byte(Success)
+	if p < end {
+		rc = src[p]
+		p++
+		if !validReasonCode(cpt, rc) {
+			return 0, nil, 0, ErrReasonCodeInvalid
+		}
+	}
+
+	var props Properties
+	if p < end {
+		var n int
+		props, n, err = decodeProperties(src[p:end], cpt)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		p += n
+	}
+	if p != end {
+		return 0, nil, 0, ErrMalformedPacket
+	}
+
+	return rc, props, p, nil
+}
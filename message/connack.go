@@ -1,7 +1,9 @@
 package message
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 )
 
 var (
@@ -85,6 +87,10 @@ type ConnackMessage struct {
 	// are deemed applicable, then the Server MUST close the Network Connection
 	// without sending a CONNACK [MQTT-3.2.2-6]
 	connectReturnCode byte
+
+	// MQTT 5.0 only. In MQTT 5.0 the Connect Return code is called the Connect Reason
+	// Code and takes the values of Reason Codes, followed by Properties.
+	properties Properties
 }
 
 // NewConnackMessage returns a pointer of ConnackMessage
@@ -113,7 +119,11 @@ func (c *ConnackMessage) SessionPresent() byte {
 
 // SetConnectReturnCode sets Connect Return code
 func (c *ConnackMessage) SetConnectReturnCode(v byte) error {
-	if v > 0x05 {
+	if c.v5() {
+		if !validReasonCode(CONNACK, v) {
+			return ErrReasonCodeInvalid
+		}
+	} else if v > 0x05 {
 		return ErrConnectReturnCodeInvalid
 	}
 
@@ -125,3 +135,101 @@ func (c *ConnackMessage) SetConnectReturnCode(v byte) error {
 func (c *ConnackMessage) ConnectReturnCode() byte {
 	return c.connectReturnCode
 }
+
+// SetProperties sets Properties
+func (c *ConnackMessage) SetProperties(v Properties) {
+	c.properties = v
+}
+
+// Properties returns Properties
+func (c *ConnackMessage) Properties() Properties {
+	return c.properties
+}
+
+// msgLen returns Remaining Length
+func (c *ConnackMessage) msgLen() int {
+	if c.v5() {
+		return 2 + c.properties.encodedLen()
+	}
+	return 2
+}
+
+// Len returns the length of the encoded packet
+func (c *ConnackMessage) Len() int {
+	ml := c.msgLen()
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (c *ConnackMessage) Encode(dest []byte) (int, error) {
+	p, err := c.fixedHeader.encodeAs(dest, c.msgLen())
+	if err != nil {
+		return p, err
+	}
+
+	dest[p] = c.connectAckFlags
+	p++
+	dest[p] = c.connectReturnCode
+	p++
+
+	if c.v5() {
+		p += c.properties.encode(dest[p:])
+	}
+
+	return p, nil
+}
+
+// Decode reads the packet from src
+func (c *ConnackMessage) Decode(src []byte) (int, error) {
+	p, err := c.fixedHeader.decodeAs(src, CONNACK, 0)
+	if err != nil {
+		return 0, err
+	}
+	end := p + int(c.remainingLength)
+	if end-p < 2 || (!c.v5() && end-p != 2) {
+		return 0, ErrMalformedPacket
+	}
+
+	c.connectAckFlags = src[p]
+	p++
+	if c.connectAckFlags&0xFE != 0 {
+		return 0, ErrMalformedPacket
+	}
+
+	if err := c.SetConnectReturnCode(src[p]); err != nil {
+		return 0, err
+	}
+	p++
+	if c.connectReturnCode != 0 && c.SessionPresent() != 0 {
+		return 0, ErrMalformedPacket
+	}
+
+	if c.v5() {
+		props, n, err := decodeProperties(src[p:end], CONNACK)
+		if err != nil {
+			return 0, err
+		}
+		c.properties = props
+		p += n
+	}
+	if p != end {
+		return 0, ErrMalformedPacket
+	}
+
+	return p, nil
+}
+
+// String returns a short description of the packet
+func (c *ConnackMessage) String() string {
+	return fmt.Sprintf("CONNACK(sp=%d, rc=0x%02x%s)", c.SessionPresent(), c.connectReturnCode, propsString(c.properties))
+}
+
+// MarshalJSON implements json.Marshaler
+func (c *ConnackMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type           string     `json:"type"`
+		SessionPresent bool       `json:"sessionPresent"`
+		ReturnCode     byte       `json:"returnCode"`
+		Properties     Properties `json:"properties,omitempty"`
+	}{"CONNACK", c.SessionPresent() == 1, c.connectReturnCode, c.properties})
+}
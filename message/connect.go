@@ -1,16 +1,31 @@
 package message
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
+	"unicode/utf8"
 )
 
 var (
 	ErrQoSInvalid            = errors.New("invalid QoS value")
 	ErrClientIdLengthInvalid = errors.New("invalid ClientId length")
 	ErrClientIdInvalid       = errors.New("invalid ClientId")
+
+	// ErrProtocolNameInvalid indicates Protocol Name is neither "MQTT" nor "MQIsdp"
+	ErrProtocolNameInvalid = errors.New("invalid Protocol Name")
+
+	// ErrProtocolLevelInvalid indicates Protocol Level is not supported
+	ErrProtocolLevelInvalid = errors.New("unsupported Protocol Level")
+
+	// ErrConnectFlagsInvalid indicates a combination of Connect Flags is not allowed
+	ErrConnectFlagsInvalid = errors.New("invalid Connect Flags")
 )
 
+var clientIdPattern = regexp.MustCompile("^[0-9a-zA-Z]*$")
+
 // After a Network Connection is established by a Client to a Server, the first Packet
 // sent from the Client to the Server MUST be a CONNECT Packet [MQTT-3.1.0-1].
 //
@@ -23,12 +38,13 @@ type ConnectMessage struct {
 
 	// The Protocol Name is a UTF-8 encoded string that represents the protocol name
 	// “MQTT”, capitalized as shown. The string, its offset and length will not be
-	// changed by future versions of the MQTT specification.
+	// changed by future versions of the MQTT specification. MQTT 3.1 uses "MQIsdp"
+	// instead.
 	protocolName []byte
 
 	// The 8 bit unsigned value that represents the revision level of the protocol
 	// used by the Client. The value of the Protocol Level field for the version 3.1.1
-	// of the protocol is 4 (0x04).
+	// of the protocol is 4 (0x04). MQTT 3.1 uses 3 (0x03) and MQTT 5.0 uses 5 (0x05).
 	protocolLevel byte
 
 	// bit 7 for User Name Flag
@@ -46,6 +62,12 @@ type ConnectMessage struct {
 	// it starts sending the next
 	keepAlive uint16
 
+	// MQTT 5.0 only. The Properties follow the Keep Alive in the variable header, and
+	// the Will Properties come before the Will Topic in the payload when the Will
+	// Flag is set.
+	properties     Properties
+	willProperties Properties
+
 	// The payload of the CONNECT Packet contains one or more length-prefixed
 	// fields, whose presence is determined by the flags in the variable header.
 	// These fields, if present, MUST appear in the order Client Identifier, Will
@@ -70,24 +92,54 @@ type ConnectMessage struct {
 func NewConnectMessage() *ConnectMessage {
 	c := &ConnectMessage{}
 	c.SetControlPacketType(CONNECT)
+	c.SetProtocolName()
+	c.SetProtocolLevel()
 	return c
 }
 
-// SetProtocolName sets Protocol Name to "MQTT" by default
+// SetProtocolName sets Protocol Name to "MQTT" by default, or to "MQIsdp" when
+// Protocol Level is 3 (MQTT 3.1)
 func (c *ConnectMessage) SetProtocolName() {
 	content := "MQTT"
+	if c.protocolLevel == Version31 {
+		content = "MQIsdp"
+	}
 	c.protocolName = make([]byte, len(content)+2)
 	c.protocolName[1] = byte(len(content))
-	for i := 2; i < 6; i++ {
+	for i := 2; i < len(content)+2; i++ {
 		c.protocolName[i] = content[i-2]
 	}
 }
 
+// ProtocolName returns Protocol Name
+func (c *ConnectMessage) ProtocolName() []byte {
+	if len(c.protocolName) < 2 {
+		return nil
+	}
+	return c.protocolName[2:]
+}
+
 // SetProtocolLevel sets Protocol Level to 4 by default
 func (c *ConnectMessage) SetProtocolLevel() {
 	c.protocolLevel = 4
 }
 
+// ProtocolLevel returns Protocol Level
+func (c *ConnectMessage) ProtocolLevel() byte {
+	if c.protocolLevel == 0 {
+		return c.Version()
+	}
+	return c.protocolLevel
+}
+
+// SetVersion sets Protocol Level, which also decides how the packet is encoded, and
+// the Protocol Name that goes with it
+func (c *ConnectMessage) SetVersion(v byte) {
+	c.fixedHeader.SetVersion(v)
+	c.protocolLevel = v
+	c.SetProtocolName()
+}
+
 // SetUserNameFlag sets User Name Flag
 func (c *ConnectMessage) SetUserNameFlag(active bool) {
 	if active {
@@ -127,7 +179,7 @@ func (c *ConnectMessage) SetWillRetain(active bool) {
 		c.connectFlags |= 0x20
 	} else {
 		// 11011111
-		c.connectFlags &= 0xCF
+		c.connectFlags &= 0xDF
 	}
 }
 
@@ -174,7 +226,7 @@ func (c *ConnectMessage) SetCleanSession(active bool) {
 		c.connectFlags |= 0x02
 	} else {
 		// 11111101
-		c.connectFlags &= 0xFC
+		c.connectFlags &= 0xFD
 	}
 }
 
@@ -188,6 +240,31 @@ func (c *ConnectMessage) SetKeepAlive(v uint16) {
 	c.keepAlive = v
 }
 
+// KeepAlive returns Keep Alive
+func (c *ConnectMessage) KeepAlive() uint16 {
+	return c.keepAlive
+}
+
+// SetProperties sets Properties
+func (c *ConnectMessage) SetProperties(v Properties) {
+	c.properties = v
+}
+
+// Properties returns Properties
+func (c *ConnectMessage) Properties() Properties {
+	return c.properties
+}
+
+// SetWillProperties sets Will Properties
+func (c *ConnectMessage) SetWillProperties(v Properties) {
+	c.willProperties = v
+}
+
+// WillProperties returns Will Properties
+func (c *ConnectMessage) WillProperties() Properties {
+	return c.willProperties
+}
+
 // SetClientId sets ClientId and validates its correctness
 func (c *ConnectMessage) SetClientId(cid []byte) error {
 	// A Server MAY allow a Client to supply a ClientId that has a length of zero byte
@@ -198,13 +275,13 @@ func (c *ConnectMessage) SetClientId(cid []byte) error {
 	// If the Client supplies a zero-byte ClientId, the Client MUST also set CleanSession to 1
 	// [MQTT-3.1.3-7]
 	//
-	// TODO: handle condition when cid length is zero
-	if len(cid) == 0 || len(cid) > 23 {
+	// A Client which wants the Server to assign its ClientId does not call SetClientId
+	if len(cid) == 0 {
 		return ErrClientIdLengthInvalid
 	}
 
-	if !regexp.MustCompile("^[0-9a-zA-Z]*$").Match(cid) {
-		return ErrClientIdInvalid
+	if err := c.checkClientId(cid); err != nil {
+		return err
 	}
 
 	c.clientId = cid
@@ -212,6 +289,47 @@ func (c *ConnectMessage) SetClientId(cid []byte) error {
 	return nil
 }
 
+// ValidateClientId checks ClientId of a received CONNECT Packet. A zero-byte ClientId
+// is accepted since MQTT 3.1.1, and the Server then has to assign a unique
+// ClientId. MQTT 3.1.1 requires Clean Session to be set with it, while MQTT 5.0
+// accepts it with any Clean Start and answers with the Assigned Client Identifier.
+func (c *ConnectMessage) ValidateClientId() error {
+	if len(c.clientId) == 0 && c.ProtocolLevel() != Version31 {
+		if c.ProtocolLevel() == Version311 && c.CleanSession() == 0 {
+			return ErrClientIdLengthInvalid
+		}
+		return nil
+	}
+	return c.checkClientId(c.clientId)
+}
+
+// checkClientId checks cid against the ClientId rules of Protocol Level
+func (c *ConnectMessage) checkClientId(cid []byte) error {
+	// MQTT 3.1 requires a ClientId of 1 to 23 characters but does not restrict
+	// which characters can be used
+	if c.ProtocolLevel() == Version31 {
+		if n := utf8.RuneCount(cid); n == 0 || n > 23 {
+			return ErrClientIdLengthInvalid
+		}
+		return nil
+	}
+
+	if len(cid) == 0 || len(cid) > 23 {
+		return ErrClientIdLengthInvalid
+	}
+
+	if !clientIdPattern.Match(cid) {
+		return ErrClientIdInvalid
+	}
+
+	return nil
+}
+
+// ClientId returns ClientId
+func (c *ConnectMessage) ClientId() []byte {
+	return c.clientId
+}
+
 // SetWillTopic sets Will Topic and actives Will Flag
 func (c *ConnectMessage) SetWillTopic(wt []byte) {
 	// TODO: validate will topic format
@@ -224,6 +342,11 @@ func (c *ConnectMessage) SetWillTopic(wt []byte) {
 	c.willTopic = wt
 }
 
+// WillTopic returns Will Topic
+func (c *ConnectMessage) WillTopic() []byte {
+	return c.willTopic
+}
+
 // SetWillMessage sets Will Message and actives Will Flag
 func (c *ConnectMessage) SetWillMessage(wm []byte) {
 	// This field consists of a two byte length followed by the payload for the Will
@@ -240,6 +363,11 @@ func (c *ConnectMessage) SetWillMessage(wm []byte) {
 	c.willMessage = wm
 }
 
+// WillMessage returns Will Message
+func (c *ConnectMessage) WillMessage() []byte {
+	return c.willMessage
+}
+
 // SetUserName sets User Name and actives User Name Flag
 func (c *ConnectMessage) SetUserName(un []byte) {
 	if len(un) == 0 {
@@ -251,6 +379,11 @@ func (c *ConnectMessage) SetUserName(un []byte) {
 	c.userName = un
 }
 
+// UserName returns User Name
+func (c *ConnectMessage) UserName() []byte {
+	return c.userName
+}
+
 // SetPassword sets Password and actives Password Flag
 func (c *ConnectMessage) SetPassword(pw []byte) {
 	if len(pw) == 0 {
@@ -262,10 +395,54 @@ func (c *ConnectMessage) SetPassword(pw []byte) {
 	c.password = pw
 }
 
+// Password returns Password
+func (c *ConnectMessage) Password() []byte {
+	return c.password
+}
+
+// msgLen returns Remaining Length
+func (c *ConnectMessage) msgLen() int {
+	v5 := c.ProtocolLevel() == Version5
+	l := len(c.protocolName) + 1 + 1 + 2
+	if v5 {
+		l += c.properties.encodedLen()
+	}
+	l += 2 + len(c.clientId)
+	if c.WillFlag() == 1 {
+		if v5 {
+			l += c.willProperties.encodedLen()
+		}
+		l += 2 + len(c.willTopic) + 2 + len(c.willMessage)
+	}
+	if c.UserNameFlag() == 1 {
+		l += 2 + len(c.userName)
+	}
+	if c.PasswordFlag() == 1 {
+		l += 2 + len(c.password)
+	}
+	return l
+}
+
+// Len returns the length of the encoded packet
+func (c *ConnectMessage) Len() int {
+	if c.protocolName == nil {
+		c.SetProtocolName()
+	}
+	ml := c.msgLen()
+	return headerLen(ml) + ml
+}
+
 // Encode convert the struct to bytes
 func (c *ConnectMessage) Encode(dest []byte) (int, error) {
+	if c.protocolName == nil {
+		c.SetProtocolName()
+	}
+	if c.WillFlag() == 0 && (c.WillQoS() != 0 || c.WillRetain() != 0) {
+		return 0, ErrConnectFlagsInvalid
+	}
+
 	p := 0
-	n, err := c.fixedHeader.Encode(dest)
+	n, err := c.fixedHeader.encodeAs(dest, c.msgLen())
 	p += n
 	if err != nil {
 		return p, errors.New("failed to encode: " + err.Error())
@@ -274,8 +451,204 @@ func (c *ConnectMessage) Encode(dest []byte) (int, error) {
 	n = copy(dest[p:], c.protocolName)
 	p += n
 
-	dest[p] = c.protocolLevel
+	dest[p] = c.ProtocolLevel()
+	p++
+
+	dest[p] = c.connectFlags
+	p++
+
+	binary.BigEndian.PutUint16(dest[p:], c.keepAlive)
+	p += 2
+
+	v5 := c.ProtocolLevel() == Version5
+	if v5 {
+		p += c.properties.encode(dest[p:])
+	}
+
+	p += putBytes(dest[p:], c.clientId)
+
+	if c.WillFlag() == 1 {
+		if v5 {
+			p += c.willProperties.encode(dest[p:])
+		}
+		p += putBytes(dest[p:], c.willTopic)
+		p += putBytes(dest[p:], c.willMessage)
+	}
+	if c.UserNameFlag() == 1 {
+		p += putBytes(dest[p:], c.userName)
+	}
+	if c.PasswordFlag() == 1 {
+		p += putBytes(dest[p:], c.password)
+	}
+
+	return p, nil
+}
+
+// Decode reads the packet from src. Protocol Level in the packet decides how the
+// rest of it is decoded and becomes the version of the message.
+func (c *ConnectMessage) Decode(src []byte) (int, error) {
+	p, err := c.fixedHeader.decodeAs(src, CONNECT, 0)
+	if err != nil {
+		return 0, err
+	}
+	end := p + int(c.remainingLength)
+
+	name, n, err := readString(src[p:end])
+	if err != nil {
+		return 0, err
+	}
+	c.protocolName = src[p : p+n]
+	p += n
+
+	if p+4 > end {
+		return 0, ErrMalformedPacket
+	}
+	c.protocolLevel = src[p]
+	p++
+
+	// MQTT 3.1 uses "MQIsdp" as Protocol Name. Any other combination of Protocol
+	// Name and Protocol Level is not supported, and the Server answers it with
+	// CONNACK return code 0x01 as long as the Protocol Name is known.
+	switch string(name) {
+	case "MQIsdp":
+		if c.protocolLevel != Version31 {
+			return 0, ErrProtocolLevelInvalid
+		}
+	case "MQTT":
+		if c.protocolLevel != Version311 && c.protocolLevel != Version5 {
+			return 0, ErrProtocolLevelInvalid
+		}
+	default:
+		return 0, ErrProtocolNameInvalid
+	}
+	c.fixedHeader.SetVersion(c.protocolLevel)
+	v5 := c.protocolLevel == Version5
+
+	// The Server MUST validate that the reserved flag in the CONNECT Control Packet
+	// is set to zero and disconnect the Client if it is not zero [MQTT-3.1.2-3]
+	c.connectFlags = src[p]
 	p++
+	if c.connectFlags&0x01 != 0 || c.WillQoS() == 3 {
+		return 0, ErrConnectFlagsInvalid
+	}
+	if c.WillFlag() == 0 && (c.WillQoS() != 0 || c.WillRetain() != 0) {
+		return 0, ErrConnectFlagsInvalid
+	}
+	if !v5 && c.UserNameFlag() == 0 && c.PasswordFlag() == 1 {
+		return 0, ErrConnectFlagsInvalid
+	}
+
+	c.keepAlive = binary.BigEndian.Uint16(src[p:])
+	p += 2
+
+	if v5 {
+		c.properties, n, err = decodeProperties(src[p:end], CONNECT)
+		if err != nil {
+			return 0, err
+		}
+		p += n
+	}
+
+	c.clientId, n, err = readString(src[p:end])
+	if err != nil {
+		return 0, err
+	}
+	p += n
+
+	if c.WillFlag() == 1 {
+		if v5 {
+			c.willProperties, n, err = decodeProperties(src[p:end], willProperties)
+			if err != nil {
+				return 0, err
+			}
+			p += n
+		}
+		c.willTopic, n, err = readString(src[p:end])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+		c.willMessage, n, err = readBytes(src[p:end])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+	}
+	if c.UserNameFlag() == 1 {
+		c.userName, n, err = readString(src[p:end])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+	}
+	if c.PasswordFlag() == 1 {
+		c.password, n, err = readBytes(src[p:end])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+	}
+	if p != end {
+		return 0, ErrMalformedPacket
+	}
 
 	return p, nil
 }
+
+// String returns a short description of the packet. Password is never shown.
+func (c *ConnectMessage) String() string {
+	s := fmt.Sprintf("CONNECT(v%d, id=%q, c%d, k%d", c.ProtocolLevel(), c.clientId, c.CleanSession(), c.keepAlive)
+	if c.WillFlag() == 1 {
+		s += fmt.Sprintf(", will(q%d, r%d, topic=%q, %dB)", c.WillQoS(), c.WillRetain(), c.willTopic, len(c.willMessage))
+	}
+	if c.UserNameFlag() == 1 {
+		s += fmt.Sprintf(", user=%q", c.userName)
+	}
+	if c.PasswordFlag() == 1 {
+		s += ", password=" + redacted
+	}
+	return s + propsString(c.properties) + ")"
+}
+
+// MarshalJSON implements json.Marshaler. Password is redacted.
+func (c *ConnectMessage) MarshalJSON() ([]byte, error) {
+	type will struct {
+		QoS        byte       `json:"qos"`
+		Retain     bool       `json:"retain"`
+		Topic      string     `json:"topic"`
+		Properties Properties `json:"properties,omitempty"`
+		Message    binaryJSON `json:"message"`
+	}
+	v := struct {
+		Type          string     `json:"type"`
+		ProtocolName  string     `json:"protocolName"`
+		ProtocolLevel byte       `json:"protocolLevel"`
+		CleanSession  bool       `json:"cleanSession"`
+		KeepAlive     uint16     `json:"keepAlive"`
+		Properties    Properties `json:"properties,omitempty"`
+		ClientID      string     `json:"clientId"`
+		Will          *will      `json:"will,omitempty"`
+		UserName      *string    `json:"userName,omitempty"`
+		Password      *string    `json:"password,omitempty"`
+	}{
+		Type:          "CONNECT",
+		ProtocolName:  string(c.ProtocolName()),
+		ProtocolLevel: c.ProtocolLevel(),
+		CleanSession:  c.CleanSession() == 1,
+		KeepAlive:     c.keepAlive,
+		Properties:    c.properties,
+		ClientID:      string(c.clientId),
+	}
+	if c.WillFlag() == 1 {
+		v.Will = &will{c.WillQoS(), c.WillRetain() == 1, string(c.willTopic), c.willProperties, binaryJSON(c.willMessage)}
+	}
+	if c.UserNameFlag() == 1 {
+		un := string(c.userName)
+		v.UserName = &un
+	}
+	if c.PasswordFlag() == 1 {
+		pw := redacted
+		v.Password = &pw
+	}
+	return json.Marshal(v)
+}
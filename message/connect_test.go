@@ -208,6 +208,164 @@ func TestConnectSetPassword(t *testing.T) {
 
 func TestEncode(t *testing.T) {
 	c := NewConnectMessage()
-	buf := make([]byte, c.length())
-	c.Encode()
+	c.SetCleanSession(true)
+	c.SetKeepAlive(10)
+	c.SetClientId([]byte("mammoth"))
+	buf := make([]byte, c.Len())
+	n, err := c.Encode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		0x10, 19,
+		0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 10,
+		0, 7, 'm', 'a', 'm', 'm', 'o', 't', 'h',
+	}
+	if !reflect.DeepEqual(buf[:n], expected) {
+		t.Errorf("expected % x, got % x", expected, buf[:n])
+	}
+}
+
+func TestConnectDecode(t *testing.T) {
+	c := NewConnectMessage()
+	c.SetVersion(Version5)
+	c.SetKeepAlive(30)
+	c.SetClientId([]byte("mammoth"))
+	c.SetWillTopic([]byte("will"))
+	c.SetWillMessage([]byte("send me home"))
+	c.SetWillQoS(1)
+	c.SetUserName([]byte("mqtt"))
+	c.SetPassword([]byte("verysecret"))
+	props := Properties{}
+	props.AddValue(SessionExpiryInterval, 3600)
+	c.SetProperties(props)
+	willProps := Properties{}
+	willProps.AddValue(WillDelayInterval, 5)
+	c.SetWillProperties(willProps)
+
+	buf := make([]byte, c.Len())
+	if _, err := c.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &ConnectMessage{}
+	n, err := d.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(buf) {
+		t.Errorf("expected %d bytes decoded, got %d", len(buf), n)
+	}
+	if d.ProtocolLevel() != Version5 || d.Version() != Version5 {
+		t.Error("Protocol Level should be 5")
+	}
+	if string(d.ClientId()) != "mammoth" || string(d.WillTopic()) != "will" ||
+		string(d.WillMessage()) != "send me home" || string(d.UserName()) != "mqtt" ||
+		string(d.Password()) != "verysecret" || d.KeepAlive() != 30 || d.WillQoS() != 1 {
+		t.Error("Decoded fields should be same as encoded")
+	}
+	if v, ok := d.Properties().Value(SessionExpiryInterval); !ok || v != 3600 {
+		t.Error("Session Expiry Interval should be 3600")
+	}
+	if v, ok := d.WillProperties().Value(WillDelayInterval); !ok || v != 5 {
+		t.Error("Will Delay Interval should be 5")
+	}
+}
+
+func TestConnectDecodeInvalid(t *testing.T) {
+	testCases := []struct {
+		in  []byte
+		err error
+	}{
+		{in: []byte{0x10, 12, 0, 4, 'M', 'Q', 'T', 'X', 4, 0x02, 0, 10, 0, 0}, err: ErrProtocolNameInvalid},
+		{in: []byte{0x10, 12, 0, 4, 'M', 'Q', 'T', 'T', 6, 0x02, 0, 10, 0, 0}, err: ErrProtocolLevelInvalid},
+		{in: []byte{0x10, 12, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x03, 0, 10, 0, 0}, err: ErrConnectFlagsInvalid},
+		{in: []byte{0x10, 12, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x42, 0, 10, 0, 0}, err: ErrConnectFlagsInvalid},
+		{in: []byte{0x10, 12, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x08, 0, 10, 0, 0}, err: ErrConnectFlagsInvalid},
+		{in: []byte{0x10, 13, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 10, 0, 0, 0}, err: ErrMalformedPacket},
+	}
+
+	for _, tc := range testCases {
+		c := &ConnectMessage{}
+		if _, err := c.Decode(tc.in); err != tc.err {
+			t.Errorf("expected %v, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestConnectVersion31(t *testing.T) {
+	c := NewConnectMessage()
+	c.SetVersion(Version31)
+	if string(c.ProtocolName()) != "MQIsdp" {
+		t.Error("Protocol Name should be MQIsdp")
+	}
+
+	cid := []byte("device_01/gateway-east")
+	if err := c.SetClientId(cid); err != nil {
+		t.Error("MQTT 3.1 ClientId may contain any character")
+	}
+	if c.SetClientId([]byte("123456789012345678901234")) == nil {
+		t.Error("ClientId length should be less than or equal 23")
+	}
+
+	buf := make([]byte, c.Len())
+	if _, err := c.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &ConnectMessage{}
+	if _, err := d.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if d.ProtocolLevel() != Version31 || d.Version() != Version31 {
+		t.Error("Protocol Level should be 3")
+	}
+	if !reflect.DeepEqual(d.ClientId(), cid) {
+		t.Error("ClientId should be same as input")
+	}
+	if err := d.ValidateClientId(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConnectDecodeProtocolMismatch(t *testing.T) {
+	testCases := []struct {
+		in  []byte
+		err error
+	}{
+		{in: []byte{0x10, 14, 0, 6, 'M', 'Q', 'I', 's', 'd', 'p', 4, 0x02, 0, 10, 0, 0}, err: ErrProtocolLevelInvalid},
+		{in: []byte{0x10, 12, 0, 4, 'M', 'Q', 'T', 'T', 3, 0x02, 0, 10, 0, 0}, err: ErrProtocolLevelInvalid},
+	}
+
+	for _, tc := range testCases {
+		c := &ConnectMessage{}
+		if _, err := c.Decode(tc.in); err != tc.err {
+			t.Errorf("expected %v, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestConnectValidateClientId(t *testing.T) {
+	c := NewConnectMessage()
+	c.SetCleanSession(true)
+	if err := c.ValidateClientId(); err != nil {
+		t.Error("zero-byte ClientId should be accepted with Clean Session")
+	}
+
+	c.SetCleanSession(false)
+	if c.ValidateClientId() == nil {
+		t.Error("zero-byte ClientId should be rejected without Clean Session")
+	}
+
+	c.SetVersion(Version5)
+	if err := c.ValidateClientId(); err != nil {
+		t.Error("zero-byte ClientId should be accepted by MQTT 5.0 without Clean Start")
+	}
+
+	c.SetVersion(Version31)
+	c.SetCleanSession(true)
+	if c.ValidateClientId() == nil {
+		t.Error("zero-byte ClientId should be rejected by MQTT 3.1")
+	}
 }
@@ -1,9 +1,94 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // DisconnectMessage is that the DISCONNECT Packet is the final Control Packet sent
 // from the Client to the Server. It indicates that the Client is disconnecting cleanly.
 type DisconnectMessage struct {
 	// The Server MUST validate that reserved bits are set to zero and disconnect the
 	// Client if they are not zero[MQTT-3.14.1-1].
 	fixedHeader
+
+	// MQTT 5.0 only. A DISCONNECT Packet can be sent by either side and carries a
+	// Disconnect Reason Code followed by Properties. Both are omitted when the Reason
+	// Code is 0x00 (Normal disconnection) and there are no Properties.
+	reasonCode byte
+	properties Properties
+}
+
+// NewDisconnectMessage returns a pointer of DisconnectMessage
+func NewDisconnectMessage() *DisconnectMessage {
+	d := &DisconnectMessage{}
+	d.SetControlPacketType(DISCONNECT)
+	return d
+}
+
+// SetReasonCode sets Reason Code
+func (d *DisconnectMessage) SetReasonCode(v byte) {
+	d.reasonCode = v
+}
+
+// ReasonCode returns Reason Code
+func (d *DisconnectMessage) ReasonCode() byte {
+	return d.reasonCode
+}
+
+// SetProperties sets Properties
+func (d *DisconnectMessage) SetProperties(v Properties) {
+	d.properties = v
+}
+
+// Properties returns Properties
+func (d *DisconnectMessage) Properties() Properties {
+	return d.properties
+}
+
+// Len returns the length of the encoded packet
+func (d *DisconnectMessage) Len() int {
+	if !d.v5() {
+		return headerLen(0)
+	}
+	ml := reasonLen(d.reasonCode, d.properties)
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (d *DisconnectMessage) Encode(dest []byte) (int, error) {
+	if !d.v5() {
+		return encodeEmpty(&d.fixedHeader, dest)
+	}
+	return encodeReason(&d.fixedHeader, dest, d.reasonCode, d.properties)
+}
+
+// Decode reads the packet from src
+func (d *DisconnectMessage) Decode(src []byte) (int, error) {
+	if !d.v5() {
+		return decodeEmpty(&d.fixedHeader, src, DISCONNECT)
+	}
+	rc, props, n, err := decodeReason(&d.fixedHeader, src, DISCONNECT)
+	if err != nil {
+		return 0, err
+	}
+	d.reasonCode, d.properties = rc, props
+	return n, nil
+}
+
+// String returns a short description of the packet
+func (d *DisconnectMessage) String() string {
+	if !d.v5() || (d.reasonCode == Success && len(d.properties) == 0) {
+		return "DISCONNECT"
+	}
+	return fmt.Sprintf("DISCONNECT(rc=0x%02x%s)", d.reasonCode, propsString(d.properties))
+}
+
+// MarshalJSON implements json.Marshaler
+func (d *DisconnectMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		ReasonCode byte       `json:"reasonCode"`
+		Properties Properties `json:"properties,omitempty"`
+	}{"DISCONNECT", d.reasonCode, d.properties})
 }
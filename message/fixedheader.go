@@ -4,7 +4,6 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
-	"math"
 )
 
 var (
@@ -13,6 +12,16 @@ var (
 
 	// ErrRemainingLengthInvalid indicates Remaining Length is less than 1 or larger than 268435455
 	ErrRemainingLengthInvalid = errors.New("invalid Remaining Length")
+
+	// ErrPacketTooLarge indicates a Control Packet larger than the maximum the
+	// reader accepts
+	ErrPacketTooLarge = errors.New("packet too large")
+
+	// ErrPacketTypeInvalid indicates Control Packet type is reserved or not the expected one
+	ErrPacketTypeInvalid = errors.New("invalid Control Packet type")
+
+	// ErrPacketFlagsInvalid indicates Flags for Control Packet type are not the ones required
+	ErrPacketFlagsInvalid = errors.New("invalid Control Packet type flags")
 )
 
 const (
@@ -57,6 +66,20 @@ const (
 
 	// DISCONNECT is Client is disconnecting
 	DISCONNECT
+
+	// AUTH is Authentication exchange, available since MQTT 5.0
+	AUTH
+)
+
+const (
+	// Version31 is the Protocol Level of MQTT 3.1
+	Version31 = 3
+
+	// Version311 is the Protocol Level of MQTT 3.1.1
+	Version311 = 4
+
+	// Version5 is the Protocol Level of MQTT 5.0
+	Version5 = 5
 )
 
 type fixedHeader struct {
@@ -76,6 +99,11 @@ type fixedHeader struct {
 	// _ _ _ _ _ _ _ _ ( 8 bits)
 	// ↑ indicate if there are following bytes
 	remainingLength uint32
+
+	// The Protocol Level negotiated on the Network Connection. It is not part of the
+	// fixed header on the wire but it decides how the rest of the packet is encoded.
+	// Zero means MQTT 3.1.1.
+	version byte
 }
 
 // SetControlPacketType sets Control Packet Type
@@ -92,7 +120,7 @@ func (fh *fixedHeader) ControlPacketType() byte {
 // SetControlPacketTypeFlag sets Control Packet Type Flag
 func (fh *fixedHeader) SetControlPacketTypeFlag(cptf byte) {
 	// 00001111
-	fh.controlPacket = 0x0F & cptf
+	fh.controlPacket = (fh.controlPacket & 0xF0) | (0x0F & cptf)
 }
 
 // ControlPacketTypeFlag returns Conrol Packet Type Flag
@@ -103,15 +131,40 @@ func (fh *fixedHeader) ControlPacketTypeFlag() byte {
 
 // SetRemainingLength sets Remaining Length including Variable Header and Payload
 func (fh *fixedHeader) SetRemainingLength(l uint32) {
-	fh.remainingLength = fh.encodeLength(l)
+	fh.remainingLength = l
 }
 
-// SetRemainingLength gets Remaining Length including Variable Header and Payload
+// GetRemainingLength gets Remaining Length including Variable Header and Payload
 func (fh *fixedHeader) GetRemainingLength() uint32 {
 	return fh.remainingLength
 }
 
+// SetVersion sets the Protocol Level the packet is encoded and decoded with
+func (fh *fixedHeader) SetVersion(v byte) {
+	fh.version = v
+}
+
+// Version returns the Protocol Level the packet is encoded and decoded with
+func (fh *fixedHeader) Version() byte {
+	if fh.version == 0 {
+		return Version311
+	}
+	return fh.version
+}
+
+// v5 reports whether the packet uses the MQTT 5.0 encoding
+func (fh *fixedHeader) v5() bool {
+	return fh.version == Version5
+}
+
+// Encode writes the fixed header to dest
 func (fh *fixedHeader) Encode(dest []byte) (int, error) {
+	if fh.remainingLength > maxVarint {
+		return 0, ErrRemainingLengthInvalid
+	}
+	if len(dest) < int(fh.length()) {
+		return 0, ErrBufferInsufficient
+	}
 	dest[0] = fh.controlPacket
 	p := 1
 
@@ -121,6 +174,64 @@ func (fh *fixedHeader) Encode(dest []byte) (int, error) {
 	return p, nil
 }
 
+// Decode reads the fixed header from src and makes sure src holds the whole packet
+func (fh *fixedHeader) Decode(src []byte) (int, error) {
+	if len(src) < 2 {
+		return 0, ErrMalformedPacket
+	}
+	fh.controlPacket = src[0]
+	p := 1
+
+	l, n, err := readVarint(src[p:])
+	if err != nil {
+		return 0, err
+	}
+	p += n
+
+	if uint32(len(src)-p) < l {
+		return 0, ErrMalformedPacket
+	}
+	fh.remainingLength = l
+
+	return p, nil
+}
+
+// decodeAs decodes the fixed header and checks its type and flags
+func (fh *fixedHeader) decodeAs(src []byte, cpt byte, cptf byte) (int, error) {
+	n, err := fh.Decode(src)
+	if err != nil {
+		return 0, err
+	}
+	if fh.ControlPacketType() != cpt {
+		return 0, ErrPacketTypeInvalid
+	}
+	if cpt != PUBLISH && fh.ControlPacketTypeFlag() != cptf {
+		return 0, ErrPacketFlagsInvalid
+	}
+	return n, nil
+}
+
+// encodeAs writes the fixed header with Remaining Length ml, making sure dest is
+// large enough for the whole packet
+func (fh *fixedHeader) encodeAs(dest []byte, ml int) (int, error) {
+	if ml > maxVarint {
+		return 0, ErrRemainingLengthInvalid
+	}
+	if len(dest) < headerLen(ml)+ml {
+		return 0, ErrBufferInsufficient
+	}
+
+	// The message itself is left untouched so that the same message can be encoded
+	// by several goroutines at once, e.g. when it is sent to many subscribers
+	dest[0] = fh.controlPacket
+	return 1 + putVarint(dest[1:], uint32(ml)), nil
+}
+
+// headerLen returns the length of a fixed header carrying Remaining Length ml
+func headerLen(ml int) int {
+	return 1 + varintLen(uint32(ml))
+}
+
 // EncodeLength implements non normative commented on line 280 - 294
 func (fh *fixedHeader) encodeLength(length uint32) uint32 {
 	var encodedLength, encodedByte uint32
@@ -148,13 +259,17 @@ func (fh *fixedHeader) decodeLength(r io.Reader) (uint32, error) {
 	encodedByte := make([]byte, 1)
 	limit := uint32(22)
 	for {
-		_, err := r.Read(encodedByte)
+		_, err := io.ReadFull(r, encodedByte)
 		if err != nil {
 			return 0, err
 		}
 
 		value |= uint32(encodedByte[0]&0x7F) << multiplier
 		if (encodedByte[0] & 0x80) == 0 {
+			// The encoded value MUST use the minimum number of bytes necessary
+			if multiplier > 0 && encodedByte[0] == 0 {
+				return 0, ErrMalformedReaminingLength
+			}
 			break
 		}
 		multiplier += 7
@@ -165,7 +280,7 @@ func (fh *fixedHeader) decodeLength(r io.Reader) (uint32, error) {
 	return value, nil
 }
 
+// length returns the length of the fixed header
 func (fh *fixedHeader) length() uint32 {
-	// TODO: avoid calculation here
-	return uint32(math.Log2(float64(fh.remainingLength)))/7 + 1 + 1
+	return uint32(headerLen(int(fh.remainingLength)))
 }
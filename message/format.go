@@ -0,0 +1,153 @@
+package message
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// redacted replaces secrets such as passwords in String and JSON output
+const redacted = "[redacted]"
+
+var packetNames = map[byte]string{
+	CONNECT:     "CONNECT",
+	CONNACK:     "CONNACK",
+	PUBLISH:     "PUBLISH",
+	PUBACK:      "PUBACK",
+	PUBREC:      "PUBREC",
+	PUBREL:      "PUBREL",
+	PUBCOMP:     "PUBCOMP",
+	SUBSCRIBE:   "SUBSCRIBE",
+	SUBACK:      "SUBACK",
+	UNSUBSCRIBE: "UNSUBSCRIBE",
+	UNSUBACK:    "UNSUBACK",
+	PINGREQ:     "PINGREQ",
+	PINGREP:     "PINGRESP",
+	DISCONNECT:  "DISCONNECT",
+	AUTH:        "AUTH",
+}
+
+var propertyNames = map[byte]string{
+	PayloadFormatIndicator:          "PayloadFormatIndicator",
+	MessageExpiryInterval:           "MessageExpiryInterval",
+	ContentType:                     "ContentType",
+	ResponseTopic:                   "ResponseTopic",
+	CorrelationData:                 "CorrelationData",
+	SubscriptionIdentifier:          "SubscriptionIdentifier",
+	SessionExpiryInterval:           "SessionExpiryInterval",
+	AssignedClientIdentifier:        "AssignedClientIdentifier",
+	ServerKeepAlive:                 "ServerKeepAlive",
+	AuthenticationMethod:            "AuthenticationMethod",
+	AuthenticationData:              "AuthenticationData",
+	RequestProblemInformation:       "RequestProblemInformation",
+	WillDelayInterval:               "WillDelayInterval",
+	RequestResponseInformation:      "RequestResponseInformation",
+	ResponseInformation:             "ResponseInformation",
+	ServerReference:                 "ServerReference",
+	ReasonString:                    "ReasonString",
+	ReceiveMaximum:                  "ReceiveMaximum",
+	TopicAliasMaximum:               "TopicAliasMaximum",
+	TopicAlias:                      "TopicAlias",
+	MaximumQoS:                      "MaximumQoS",
+	RetainAvailable:                 "RetainAvailable",
+	UserProperty:                    "UserProperty",
+	MaximumPacketSize:               "MaximumPacketSize",
+	WildcardSubscriptionAvailable:   "WildcardSubscriptionAvailable",
+	SubscriptionIdentifierAvailable: "SubscriptionIdentifierAvailable",
+	SharedSubscriptionAvailable:     "SharedSubscriptionAvailable",
+}
+
+// PacketName returns the name of Control Packet type t, e.g. "PUBLISH"
+func PacketName(t byte) string {
+	if name, ok := packetNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("RESERVED(%d)", t)
+}
+
+// packetIDValue returns Packet Identifier as a number
+func packetIDValue(pid []byte) uint16 {
+	if len(pid) != 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(pid)
+}
+
+// codesString formats Reason Codes or return codes as "[0x00 0x80]"
+func codesString(cs []byte) string {
+	s := make([]string, len(cs))
+	for i, c := range cs {
+		s[i] = fmt.Sprintf("0x%02x", c)
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// propsString returns ", props=N" for MQTT 5.0 packets carrying Properties
+func propsString(ps Properties) string {
+	if len(ps) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", props=%d", len(ps))
+}
+
+// binaryJSON is Binary Data shown as text when it is valid UTF-8, or base64 encoded
+// by encoding/json otherwise
+type binaryJSON []byte
+
+// MarshalJSON implements json.Marshaler
+func (b binaryJSON) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(struct {
+		Base64 []byte `json:"base64"`
+	}{b})
+}
+
+// String returns the Property as name=value
+func (p Property) String() string {
+	name, ok := propertyNames[p.ID]
+	if !ok {
+		name = fmt.Sprintf("0x%02x", p.ID)
+	}
+	switch propertySpecs[p.ID].kind {
+	case propString:
+		return fmt.Sprintf("%s=%q", name, p.Data)
+	case propBinary:
+		return fmt.Sprintf("%s=%dB", name, len(p.Data))
+	case propPair:
+		return fmt.Sprintf("%s=%q:%q", name, p.Data, p.Pair)
+	}
+	return fmt.Sprintf("%s=%d", name, p.Value)
+}
+
+// MarshalJSON implements json.Marshaler. Authentication Data is redacted.
+func (p Property) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Name  string      `json:"name"`
+		Value interface{} `json:"value"`
+		Pair  interface{} `json:"pair,omitempty"`
+	}{Name: propertyNames[p.ID]}
+	if v.Name == "" {
+		v.Name = fmt.Sprintf("0x%02x", p.ID)
+	}
+
+	switch propertySpecs[p.ID].kind {
+	case propString:
+		v.Value = string(p.Data)
+	case propBinary:
+		v.Value = binaryJSON(p.Data)
+		if p.ID == AuthenticationData {
+			// Authentication Data holds credentials, like the Password
+			v.Value = redacted
+		}
+	case propPair:
+		v.Value = string(p.Data)
+		v.Pair = string(p.Pair)
+	default:
+		v.Value = p.Value
+	}
+	return json.Marshal(v)
+}
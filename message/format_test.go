@@ -0,0 +1,108 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPublishString(t *testing.T) {
+	p := NewPublishMessage()
+	p.SetQoS(1)
+	p.SetTopicName([]byte("a/b"))
+	p.SetPacketID([]byte{0x00, 0x11})
+	p.SetPayload(make([]byte, 1024))
+
+	want := `PUBLISH(q1, r0, d0, id=17, topic="a/b", 1024B)`
+	if s := p.String(); s != want {
+		t.Errorf("String() = %s, want %s", s, want)
+	}
+}
+
+func TestPublishJSON(t *testing.T) {
+	p := NewPublishMessage()
+	p.SetTopicName([]byte("a/b"))
+	p.SetPayload([]byte{0xFF, 0x00})
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"PUBLISH","qos":0,"retain":false,"dup":false,"topic":"a/b","payload":{"base64":"/wA="}}`
+	if string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+}
+
+func TestConnectRedactsPassword(t *testing.T) {
+	c := NewConnectMessage()
+	c.SetClientId([]byte("mammoth"))
+	c.SetUserNameFlag(true)
+	c.SetUserName([]byte("mqtt"))
+	c.SetPasswordFlag(true)
+	c.SetPassword([]byte("s3cret"))
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{c.String(), string(b)} {
+		if strings.Contains(s, "s3cret") {
+			t.Errorf("%s contains the password", s)
+		}
+		if !strings.Contains(s, redacted) {
+			t.Errorf("%s does not show the password is set", s)
+		}
+	}
+}
+
+func TestRedactsAuthenticationData(t *testing.T) {
+	props := Properties{}
+	props.AddData(AuthenticationMethod, []byte("SCRAM-SHA-256"))
+	props.AddData(AuthenticationData, []byte("s3cret"))
+	c := NewConnectMessage()
+	c.SetVersion(Version5)
+	c.SetClientId([]byte("mammoth"))
+	c.SetProperties(props)
+	a := NewAuthMessage()
+	a.SetReasonCode(0x18)
+	a.SetProperties(props)
+
+	for _, m := range []Message{c, a} {
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{m.(fmt.Stringer).String(), string(b)} {
+			if strings.Contains(s, "s3cret") {
+				t.Errorf("%s contains the Authentication Data", s)
+			}
+		}
+		if !strings.Contains(string(b), redacted) || !strings.Contains(string(b), "SCRAM-SHA-256") {
+			t.Errorf("json.Marshal() = %s, want the method and redacted data", b)
+		}
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	for _, v := range []byte{Version311, Version5} {
+		for _, m := range newTestMessages(v) {
+			name := PacketName(m.ControlPacketType())
+			s := m.(fmt.Stringer).String()
+			if !strings.HasPrefix(s, name) {
+				t.Errorf("String() = %s, want prefix %s", s, name)
+			}
+
+			b, err := json.Marshal(m)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			var v struct{ Type string }
+			if err := json.Unmarshal(b, &v); err != nil || v.Type != name {
+				t.Errorf("json.Marshal() = %s, want type %s", b, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+// malformedPackets are seeds which every decoder has to reject without panicking
+var malformedPackets = [][]byte{
+	{},
+	{0x10},
+	{0x10, 0x80},
+	{0x10, 0xFF, 0xFF, 0xFF, 0xFF},
+	{0x10, 0x80, 0x00},
+	{0x10, 0x7F, 0, 4, 'M', 'Q', 'T', 'T'},
+	{0x10, 12, 0, 4, 'M', 'Q', 'T', 'T', 4, 0xFF, 0, 10, 0, 0},
+	{0x10, 16, 0, 4, 'M', 'Q', 'T', 'T', 5, 0x02, 0, 10, 0x05, 0x26, 0, 1, 'a', 0, 0},
+	{0x20, 3, 0, 0, 0},
+	{0x32, 5, 0, 3, 'a', '/', 'b'},
+	{0x36, 7, 0, 3, 'a', '/', 'b', 0, 1},
+	{0x30, 3, 0, 0xFF, 'a'},
+	{0x40, 2, 0},
+	{0x40, 4, 0, 1, 0, 0xFF},
+	{0x62, 1, 0},
+	{0x80, 2, 0, 1},
+	{0x82, 5, 0, 1, 0, 1, '#'},
+	{0x82, 6, 0, 1, 0, 1, 'a', 0xC0},
+	{0x90, 3, 0, 1, 0x03},
+	{0xA2, 4, 0, 1, 0, 5},
+	{0xB0, 2, 0, 0},
+	{0xC0, 1, 0},
+	{0xE0, 1, 0x7F},
+	{0xF0, 2, 0x01, 0},
+}
+
+// canonicalForm reports whether a decoded packet of type cpt re-encodes to the bytes
+// it was decoded from. MQTT 5.0 acknowledgements, DISCONNECT and AUTH may spell out
+// a 0x00 Reason Code and empty Properties which the encoder leaves out, so for them
+// the encoder only has to produce a shorter form which is stable.
+func canonicalForm(cpt byte, version byte) bool {
+	if version != Version5 {
+		return true
+	}
+	switch cpt {
+	case PUBACK, PUBREC, PUBREL, PUBCOMP, DISCONNECT, AUTH:
+		return false
+	}
+	return true
+}
+
+// checkRoundTrip encodes m, which was decoded from src, and compares the result to src
+func checkRoundTrip(t *testing.T, m Message, src []byte) {
+	out := make([]byte, m.Len())
+	n, err := m.Encode(out)
+	if err != nil {
+		t.Fatalf("decoded packet % x does not encode: %v", src, err)
+	}
+	if n != len(out) {
+		t.Fatalf("Len() is %d but %d bytes were encoded", len(out), n)
+	}
+	if bytes.Equal(src, out) {
+		return
+	}
+	if canonicalForm(m.ControlPacketType(), m.Version()) || len(out) > len(src) {
+		t.Fatalf("round trip changed the packet\n in: % x\nout: % x", src, out)
+	}
+
+	again, err := NewMessage(m.ControlPacketType())
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.SetVersion(m.Version())
+	if _, err := again.Decode(out); err != nil {
+		t.Fatalf("re-encoded packet % x does not decode: %v", out, err)
+	}
+	out2 := make([]byte, again.Len())
+	again.Encode(out2)
+	if !bytes.Equal(out, out2) {
+		t.Fatalf("encoding is not stable\n in: % x\nout: % x", out, out2)
+	}
+}
+
+// fuzzVersion maps any byte to one of the supported Protocol Levels
+func fuzzVersion(v byte) byte {
+	return Version31 + v%3
+}
+
+func addSeeds(f *testing.F) {
+	for _, version := range []byte{Version311, Version5} {
+		for _, m := range newTestMessages(version) {
+			buf := make([]byte, m.Len())
+			if _, err := m.Encode(buf); err != nil {
+				f.Fatal(err)
+			}
+			f.Add(version, buf)
+		}
+
+		c := NewConnectMessage()
+		c.SetVersion(version)
+		c.SetClientId([]byte("mammoth"))
+		c.SetWillTopic([]byte("will"))
+		c.SetWillMessage([]byte("bye"))
+		c.SetUserName([]byte("user"))
+		c.SetPassword([]byte("secret"))
+		buf := make([]byte, c.Len())
+		c.Encode(buf)
+		f.Add(version, buf)
+	}
+	for _, p := range malformedPackets {
+		f.Add(byte(Version311), p)
+		f.Add(byte(Version5), p)
+	}
+}
+
+func FuzzReadPacket(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, v byte, data []byte) {
+		version := fuzzVersion(v)
+
+		// The only allocation sized by the input is the packet itself, and it MUST
+		// NOT be larger than what the fixed header declares
+		var allocated int
+		m, err := readPacket(bytes.NewReader(data), version, 0, func(n int) []byte {
+			allocated = n
+			return make([]byte, n)
+		})
+		if allocated > 0 {
+			l, hl, lerr := readVarint(data[1:])
+			if lerr != nil || allocated != 1+hl+int(l) {
+				t.Fatalf("allocated %d bytes for % x", allocated, data)
+			}
+		}
+		if err != nil {
+			return
+		}
+		checkRoundTrip(t, m, data[:allocated])
+	})
+}
+
+func fuzzDecode(f *testing.F, cpt byte) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, v byte, data []byte) {
+		m, err := NewMessage(cpt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.SetVersion(fuzzVersion(v))
+		n, err := m.Decode(data)
+		if err != nil {
+			return
+		}
+		if n > len(data) {
+			t.Fatalf("decoded %d bytes out of %d", n, len(data))
+		}
+		checkRoundTrip(t, m, data[:n])
+	})
+}
+
+func FuzzConnectDecode(f *testing.F)     { fuzzDecode(f, CONNECT) }
+func FuzzConnackDecode(f *testing.F)     { fuzzDecode(f, CONNACK) }
+func FuzzPublishDecode(f *testing.F)     { fuzzDecode(f, PUBLISH) }
+func FuzzPubackDecode(f *testing.F)      { fuzzDecode(f, PUBACK) }
+func FuzzPubrecDecode(f *testing.F)      { fuzzDecode(f, PUBREC) }
+func FuzzPubrelDecode(f *testing.F)      { fuzzDecode(f, PUBREL) }
+func FuzzPubcompDecode(f *testing.F)     { fuzzDecode(f, PUBCOMP) }
+func FuzzSubscribeDecode(f *testing.F)   { fuzzDecode(f, SUBSCRIBE) }
+func FuzzSubackDecode(f *testing.F)      { fuzzDecode(f, SUBACK) }
+func FuzzUnsubscribeDecode(f *testing.F) { fuzzDecode(f, UNSUBSCRIBE) }
+func FuzzUnsubackDecode(f *testing.F)    { fuzzDecode(f, UNSUBACK) }
+func FuzzPingeqDecode(f *testing.F)      { fuzzDecode(f, PINGREQ) }
+func FuzzPingrespDecode(f *testing.F)    { fuzzDecode(f, PINGREP) }
+func FuzzDisconnectDecode(f *testing.F)  { fuzzDecode(f, DISCONNECT) }
+func FuzzAuthDecode(f *testing.F)        { fuzzDecode(f, AUTH) }
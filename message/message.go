@@ -0,0 +1,173 @@
+package message
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	// ErrMalformedPacket indicates the packet does not follow the format of its type
+	ErrMalformedPacket = errors.New("malformed Packet")
+
+	// ErrBufferInsufficient indicates the destination is too small for the encoded packet
+	ErrBufferInsufficient = errors.New("insufficient buffer size")
+
+	// ErrPacketIDInvalid indicates Packet Identifier is missing, zero or not 2 bytes long
+	ErrPacketIDInvalid = errors.New("invalid Packet Identifier")
+
+	// ErrTopicFilterMissing indicates a SUBSCRIBE or UNSUBSCRIBE Packet carries no Topic Filter
+	ErrTopicFilterMissing = errors.New("at least one Topic Filter is required")
+)
+
+// Message is an MQTT Control Packet which can be encoded to and decoded from bytes
+type Message interface {
+	// ControlPacketType returns Control Packet Type
+	ControlPacketType() byte
+
+	// SetVersion sets the Protocol Level used to encode and decode the packet
+	SetVersion(v byte)
+
+	// Version returns the Protocol Level used to encode and decode the packet
+	Version() byte
+
+	// Len returns the length of the encoded packet including its fixed header
+	Len() int
+
+	// Encode writes the packet to dest, which must be at least Len() bytes
+	Encode(dest []byte) (int, error)
+
+	// Decode reads the packet from src. Byte slices held by the message refer
+	// to src, so src must not be modified while the message is in use.
+	Decode(src []byte) (int, error)
+}
+
+// NewMessage returns an empty message of Control Packet Type t
+func NewMessage(t byte) (Message, error) {
+	switch t {
+	case CONNECT:
+		return NewConnectMessage(), nil
+	case CONNACK:
+		return NewConnackMessage(), nil
+	case PUBLISH:
+		return NewPublishMessage(), nil
+	case PUBACK:
+		return NewPubackMessage(), nil
+	case PUBREC:
+		return NewPubrecMessage(), nil
+	case PUBREL:
+		return NewPubrelMessage(), nil
+	case PUBCOMP:
+		return NewPubcompMessage(), nil
+	case SUBSCRIBE:
+		return NewSubscribeMessage(), nil
+	case SUBACK:
+		return NewSubackMessage(), nil
+	case UNSUBSCRIBE:
+		return NewUnsubscribeMessage(), nil
+	case UNSUBACK:
+		return NewUnsubackMessage(), nil
+	case PINGREQ:
+		return NewPingeqMessage(), nil
+	case PINGREP:
+		return NewPingrespMessage(), nil
+	case DISCONNECT:
+		return NewDisconnectMessage(), nil
+	case AUTH:
+		return NewAuthMessage(), nil
+	}
+	return nil, ErrPacketTypeInvalid
+}
+
+// ReadPacket reads one Control Packet from r and decodes it with Protocol Level
+// version. A CONNECT Packet is always decoded with the level it carries.
+func ReadPacket(r io.Reader, version byte) (Message, error) {
+	return ReadPacketLimit(r, version, 0)
+}
+
+// ReadPacketLimit reads one Control Packet like ReadPacket. If max is not zero, it
+// returns ErrPacketTooLarge for a packet of more than max bytes before reading its
+// body.
+func ReadPacketLimit(r io.Reader, version byte, max int) (Message, error) {
+	return readPacket(r, version, max, func(n int) []byte {
+		return make([]byte, n)
+	})
+}
+
+// ReadPacketBuffer reads one Control Packet from r like ReadPacket, but into a
+// Buffer taken from a pool. The message refers to the bytes of the Buffer instead
+// of copying them, so the caller must Release the Buffer once it and everyone it
+// handed the message to are done with it.
+func ReadPacketBuffer(r io.Reader, version byte) (Message, *Buffer, error) {
+	return ReadPacketBufferLimit(r, version, 0)
+}
+
+// ReadPacketBufferLimit reads one Control Packet like ReadPacketBuffer, with the
+// limit of ReadPacketLimit
+func ReadPacketBufferLimit(r io.Reader, version byte, max int) (Message, *Buffer, error) {
+	var buf *Buffer
+	m, err := readPacket(r, version, max, func(n int) []byte {
+		buf = NewBuffer(n)
+		return buf.Bytes()
+	})
+	if err != nil {
+		if buf != nil {
+			buf.Release()
+		}
+		return nil, nil, err
+	}
+	return m, buf, nil
+}
+
+// readPacket reads one Control Packet of at most max bytes, unless max is zero,
+// into the bytes returned by alloc
+func readPacket(r io.Reader, version byte, max int, alloc func(n int) []byte) (Message, error) {
+	fh := fixedHeader{}
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(r, first); err != nil {
+		return nil, err
+	}
+
+	l, err := fh.decodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t := first[0] >> 4
+	if t == AUTH && version != Version5 {
+		return nil, ErrPacketTypeInvalid
+	}
+	m, err := NewMessage(t)
+	if err != nil {
+		return nil, err
+	}
+
+	hl := headerLen(int(l))
+	if max > 0 && hl+int(l) > max {
+		return nil, ErrPacketTooLarge
+	}
+	buf := alloc(hl + int(l))
+	buf[0] = first[0]
+	putVarint(buf[1:], l)
+	if _, err := io.ReadFull(r, buf[hl:]); err != nil {
+		return nil, err
+	}
+
+	m.SetVersion(version)
+	if _, err := m.Decode(buf); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// WritePacket encodes m into a pooled Buffer and writes it to w
+func WritePacket(w io.Writer, m Message) error {
+	buf := NewBuffer(m.Len())
+	defer buf.Release()
+
+	n, err := m.Encode(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes()[:n])
+	return err
+}
@@ -0,0 +1,183 @@
+package message
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func newTestMessages(version byte) []Message {
+	pid := []byte{0x31, 0x17}
+
+	props := Properties{}
+	if version == Version5 {
+		props.AddUserProperty([]byte("region"), []byte("eu"))
+	}
+
+	connack := NewConnackMessage()
+	connack.SetVersion(version)
+	connack.SetSessionPresent(true)
+	connack.SetProperties(props)
+
+	publish := NewPublishMessage()
+	publish.SetVersion(version)
+	publish.SetQoS(1)
+	publish.SetRetain(true)
+	publish.SetTopicName([]byte("a/b"))
+	publish.SetPacketID(pid)
+	publish.SetPayload([]byte("Hi MQTT"))
+	publish.SetProperties(props)
+
+	puback := NewPubackMessage()
+	puback.SetPacketID(pid)
+	pubrec := NewPubrecMessage()
+	pubrec.SetPacketID(pid)
+	pubrel := NewPubrelMessage()
+	pubrel.SetPacketID(pid)
+	pubcomp := NewPubcompMessage()
+	pubcomp.SetPacketID(pid)
+	if version == Version5 {
+		puback.SetReasonCode(NoMatchingSubscribers)
+		pubrec.SetProperties(props)
+		pubrel.SetReasonCode(PacketIdentifierNotFound)
+		pubrel.SetProperties(props)
+	}
+
+	subscribe := NewSubscribeMessage()
+	subscribe.SetPacketID(pid)
+	subscribe.Add([]byte("a/+"), 1)
+	subscribe.Add([]byte("b/#"), 2)
+	subscribe.SetProperties(props)
+
+	suback := NewSubackMessage()
+	suback.SetPacketID(pid)
+	suback.AddReturnCode(1)
+	suback.AddReturnCode(0x80)
+	suback.SetProperties(props)
+
+	unsubscribe := NewUnsubscribeMessage()
+	unsubscribe.SetPacketID(pid)
+	unsubscribe.AddTopic([]byte("a/+"))
+	unsubscribe.SetProperties(props)
+
+	unsuback := NewUnsubackMessage()
+	unsuback.SetPacketID(pid)
+	if version == Version5 {
+		unsuback.AddReasonCode(NoSubscriptionExisted)
+	}
+
+	disconnect := NewDisconnectMessage()
+	if version == Version5 {
+		disconnect.SetReasonCode(ServerShuttingDown)
+	}
+
+	msgs := []Message{
+		connack, publish, puback, pubrec, pubrel, pubcomp, subscribe, suback,
+		unsubscribe, unsuback, NewPingeqMessage(), NewPingrespMessage(), disconnect,
+	}
+	if version == Version5 {
+		auth := NewAuthMessage()
+		auth.SetReasonCode(ContinueAuthentication)
+		authProps := Properties{}
+		authProps.AddData(AuthenticationMethod, []byte("SCRAM-SHA-1"))
+		authProps.AddData(AuthenticationData, []byte{0x01, 0x02})
+		auth.SetProperties(authProps)
+		msgs = append(msgs, auth)
+	}
+	for _, m := range msgs {
+		m.SetVersion(version)
+	}
+	return msgs
+}
+
+func TestReadPacketRoundTrip(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		for _, m := range newTestMessages(version) {
+			buf := make([]byte, m.Len())
+			n, err := m.Encode(buf)
+			if err != nil {
+				t.Fatalf("v%d type %d: %v", version, m.ControlPacketType(), err)
+			}
+			if n != len(buf) {
+				t.Errorf("v%d type %d: expected %d bytes encoded, got %d", version, m.ControlPacketType(), len(buf), n)
+			}
+
+			d, err := ReadPacket(bytes.NewReader(buf), version)
+			if err != nil {
+				t.Fatalf("v%d type %d: %v", version, m.ControlPacketType(), err)
+			}
+			if reflect.TypeOf(d) != reflect.TypeOf(m) {
+				t.Errorf("v%d: expected %T, got %T", version, m, d)
+			}
+
+			out := make([]byte, d.Len())
+			if _, err := d.Encode(out); err != nil {
+				t.Fatalf("v%d type %d: %v", version, m.ControlPacketType(), err)
+			}
+			if !bytes.Equal(buf, out) {
+				t.Errorf("v%d type %d: expected % x, got % x", version, m.ControlPacketType(), buf, out)
+			}
+		}
+	}
+}
+
+func TestReadPacketKeepsV311Encoding(t *testing.T) {
+	p := NewPubackMessage()
+	p.SetPacketID([]byte{0, 1})
+	p.SetReasonCode(NoMatchingSubscribers)
+	buf := make([]byte, p.Len())
+	p.Encode(buf)
+	if !bytes.Equal(buf, []byte{0x40, 2, 0, 1}) {
+		t.Errorf("Reason Code should not be encoded for 3.1.1, got % x", buf)
+	}
+}
+
+func TestReadPacketInvalid(t *testing.T) {
+	testCases := []struct {
+		in      []byte
+		version byte
+		err     error
+	}{
+		{in: []byte{0x00, 0}, version: Version311, err: ErrPacketTypeInvalid},
+		{in: []byte{0xF0, 0}, version: Version311, err: ErrPacketTypeInvalid},
+		{in: []byte{0x80, 5, 0, 1, 0, 1, 'a'}, version: Version311, err: ErrPacketFlagsInvalid},
+		{in: []byte{0x62, 2, 0, 0}, version: Version311, err: ErrPacketIDInvalid},
+		{in: []byte{0x40, 3, 0, 1, 0x10}, version: Version311, err: ErrMalformedPacket},
+		{in: []byte{0x40, 3, 0, 1, 0x05}, version: Version5, err: ErrReasonCodeInvalid},
+		{in: []byte{0x40, 6, 0, 1, 0x10, 2, 0x01, 1}, version: Version5, err: ErrPropertyInvalid},
+		{in: []byte{0x82, 2, 0, 1}, version: Version311, err: ErrTopicFilterMissing},
+		{in: []byte{0x82, 6, 0, 1, 0, 1, 'a', 3}, version: Version311, err: ErrQoSInvalid},
+		{in: []byte{0x30, 5, 0, 3, 'a', '/', '#'}, version: Version311, err: ErrTopicNameInvalid},
+		{in: []byte{0xC0, 1, 0}, version: Version311, err: ErrMalformedPacket},
+		{in: []byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, version: Version311, err: ErrMalformedReaminingLength},
+	}
+
+	for _, tc := range testCases {
+		if _, err := ReadPacket(bytes.NewReader(tc.in), tc.version); err != tc.err {
+			t.Errorf("% x: expected %v, got %v", tc.in, tc.err, err)
+		}
+	}
+}
+
+func TestReadPacketBufferLimit(t *testing.T) {
+	in := []byte{0x30, 7, 0, 3, 'a', '/', 'b', 'h', 'i'}
+	if _, _, err := ReadPacketBufferLimit(bytes.NewReader(in), Version311, len(in)-1); err != ErrPacketTooLarge {
+		t.Errorf("expected %v, got %v", ErrPacketTooLarge, err)
+	}
+	m, buf, err := ReadPacketBufferLimit(bytes.NewReader(in), Version311, len(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Release()
+	if p := m.(*PublishMessage); string(p.Payload()) != "hi" {
+		t.Errorf("expected payload hi, got %q", p.Payload())
+	}
+}
+
+func TestEncodeBufferInsufficient(t *testing.T) {
+	p := NewPubackMessage()
+	p.SetPacketID([]byte{0, 1})
+	if _, err := p.Encode(make([]byte, 3)); err != ErrBufferInsufficient {
+		t.Errorf("expected %v, got %v", ErrBufferInsufficient, err)
+	}
+}
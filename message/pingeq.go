@@ -1,5 +1,9 @@
 package message
 
+import (
+	"encoding/json"
+)
+
 // PingeqMessage is that the PINGEQ Packet is sent from a Client to the Server. It can
 // be used to:
 // 1. Indicate to the Server that the Client is alive in the absence of any other Control
@@ -11,3 +15,37 @@ package message
 type PingeqMessage struct {
 	fixedHeader
 }
+
+// NewPingeqMessage returns a pointer of PingeqMessage
+func NewPingeqMessage() *PingeqMessage {
+	p := &PingeqMessage{}
+	p.SetControlPacketType(PINGREQ)
+	return p
+}
+
+// Len returns the length of the encoded packet
+func (p *PingeqMessage) Len() int {
+	return headerLen(0)
+}
+
+// Encode convert the struct to bytes
+func (p *PingeqMessage) Encode(dest []byte) (int, error) {
+	return encodeEmpty(&p.fixedHeader, dest)
+}
+
+// Decode reads the packet from src
+func (p *PingeqMessage) Decode(src []byte) (int, error) {
+	return decodeEmpty(&p.fixedHeader, src, PINGREQ)
+}
+
+// String returns a short description of the packet
+func (p *PingeqMessage) String() string {
+	return "PINGREQ"
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PingeqMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string `json:"type"`
+	}{"PINGREQ"})
+}
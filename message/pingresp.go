@@ -1,7 +1,45 @@
 package message
 
+import (
+	"encoding/json"
+)
+
 // PingrespMessage is that a PINGRESP Packet is sent by the Server to the Client in
 // response to a PINGREQ Packet. It indicates that the Server is alive.
 type PingrespMessage struct {
 	fixedHeader
 }
+
+// NewPingrespMessage returns a pointer of PingrespMessage
+func NewPingrespMessage() *PingrespMessage {
+	p := &PingrespMessage{}
+	p.SetControlPacketType(PINGREP)
+	return p
+}
+
+// Len returns the length of the encoded packet
+func (p *PingrespMessage) Len() int {
+	return headerLen(0)
+}
+
+// Encode convert the struct to bytes
+func (p *PingrespMessage) Encode(dest []byte) (int, error) {
+	return encodeEmpty(&p.fixedHeader, dest)
+}
+
+// Decode reads the packet from src
+func (p *PingrespMessage) Decode(src []byte) (int, error) {
+	return decodeEmpty(&p.fixedHeader, src, PINGREP)
+}
+
+// String returns a short description of the packet
+func (p *PingrespMessage) String() string {
+	return "PINGRESP"
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PingrespMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string `json:"type"`
+	}{"PINGRESP"})
+}
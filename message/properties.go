@@ -0,0 +1,322 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrPropertyInvalid indicates a Property is unknown, malformed, repeated or not
+	// allowed in the packet carrying it
+	ErrPropertyInvalid = errors.New("invalid Property")
+)
+
+// Property Identifiers defined by MQTT 5.0 section 2.2.2.2
+const (
+	PayloadFormatIndicator          = 0x01
+	MessageExpiryInterval           = 0x02
+	ContentType                     = 0x03
+	ResponseTopic                   = 0x08
+	CorrelationData                 = 0x09
+	SubscriptionIdentifier          = 0x0B
+	SessionExpiryInterval           = 0x11
+	AssignedClientIdentifier        = 0x12
+	ServerKeepAlive                 = 0x13
+	AuthenticationMethod            = 0x15
+	AuthenticationData              = 0x16
+	RequestProblemInformation       = 0x17
+	WillDelayInterval               = 0x18
+	RequestResponseInformation      = 0x19
+	ResponseInformation             = 0x1A
+	ServerReference                 = 0x1C
+	ReasonString                    = 0x1F
+	ReceiveMaximum                  = 0x21
+	TopicAliasMaximum               = 0x22
+	TopicAlias                      = 0x23
+	MaximumQoS                      = 0x24
+	RetainAvailable                 = 0x25
+	UserProperty                    = 0x26
+	MaximumPacketSize               = 0x27
+	WildcardSubscriptionAvailable   = 0x28
+	SubscriptionIdentifierAvailable = 0x29
+	SharedSubscriptionAvailable     = 0x2A
+)
+
+// Data types a Property value can have
+const (
+	propByte = iota + 1
+	propUint16
+	propUint32
+	propVarint
+	propString
+	propBinary
+	propPair
+)
+
+// willProperties stands in for the Control Packet type of the Will Properties
+// of a CONNECT Packet, which is reserved and never sent on the wire
+const willProperties = 0
+
+// propertySpec describes the data type of a Property and the packets allowed
+// to carry it as a bit mask indexed by Control Packet type
+type propertySpec struct {
+	kind    byte
+	packets uint16
+}
+
+func packets(types ...byte) uint16 {
+	var m uint16
+	for _, t := range types {
+		m |= 1 << t
+	}
+	return m
+}
+
+var propertySpecs = map[byte]propertySpec{
+	PayloadFormatIndicator:          {propByte, packets(PUBLISH, willProperties)},
+	MessageExpiryInterval:           {propUint32, packets(PUBLISH, willProperties)},
+	ContentType:                     {propString, packets(PUBLISH, willProperties)},
+	ResponseTopic:                   {propString, packets(PUBLISH, willProperties)},
+	CorrelationData:                 {propBinary, packets(PUBLISH, willProperties)},
+	SubscriptionIdentifier:          {propVarint, packets(PUBLISH, SUBSCRIBE)},
+	SessionExpiryInterval:           {propUint32, packets(CONNECT, CONNACK, DISCONNECT)},
+	AssignedClientIdentifier:        {propString, packets(CONNACK)},
+	ServerKeepAlive:                 {propUint16, packets(CONNACK)},
+	AuthenticationMethod:            {propString, packets(CONNECT, CONNACK, AUTH)},
+	AuthenticationData:              {propBinary, packets(CONNECT, CONNACK, AUTH)},
+	RequestProblemInformation:       {propByte, packets(CONNECT)},
+	WillDelayInterval:               {propUint32, packets(willProperties)},
+	RequestResponseInformation:      {propByte, packets(CONNECT)},
+	ResponseInformation:             {propString, packets(CONNACK)},
+	ServerReference:                 {propString, packets(CONNACK, DISCONNECT)},
+	ReasonString:                    {propString, packets(CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBACK, UNSUBACK, DISCONNECT, AUTH)},
+	ReceiveMaximum:                  {propUint16, packets(CONNECT, CONNACK)},
+	TopicAliasMaximum:               {propUint16, packets(CONNECT, CONNACK)},
+	TopicAlias:                      {propUint16, packets(PUBLISH)},
+	MaximumQoS:                      {propByte, packets(CONNACK)},
+	RetainAvailable:                 {propByte, packets(CONNACK)},
+	UserProperty:                    {propPair, packets(willProperties, CONNECT, CONNACK, PUBLISH, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBSCRIBE, SUBACK, UNSUBSCRIBE, UNSUBACK, DISCONNECT, AUTH)},
+	MaximumPacketSize:               {propUint32, packets(CONNECT, CONNACK)},
+	WildcardSubscriptionAvailable:   {propByte, packets(CONNACK)},
+	SubscriptionIdentifierAvailable: {propByte, packets(CONNACK)},
+	SharedSubscriptionAvailable:     {propByte, packets(CONNACK)},
+}
+
+// Property is a single entry of the Properties of an MQTT 5.0 packet.
+//
+// Byte, Two Byte Integer, Four Byte Integer and Variable Byte Integer values are
+// kept in Value. UTF-8 Encoded Strings and Binary Data are kept in Data. A User
+// Property keeps its name in Data and its value in Pair.
+type Property struct {
+	ID    byte
+	Value uint32
+	Data  []byte
+	Pair  []byte
+}
+
+// Properties is the ordered list of Properties in the Variable Header of an MQTT 5.0
+// packet, or in the Will Properties of a CONNECT Packet payload.
+//
+// It is a Protocol Error to include a Property more than once, except for User
+// Property and, in a PUBLISH Packet, Subscription Identifier.
+type Properties []Property
+
+// Get returns the first Property with identifier id
+func (ps Properties) Get(id byte) (Property, bool) {
+	for _, p := range ps {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+// Value returns the integer value of the Property with identifier id
+func (ps Properties) Value(id byte) (uint32, bool) {
+	p, ok := ps.Get(id)
+	return p.Value, ok
+}
+
+// Data returns the string or binary value of the Property with identifier id
+func (ps Properties) Data(id byte) ([]byte, bool) {
+	p, ok := ps.Get(id)
+	return p.Data, ok
+}
+
+// AddValue adds an integer Property
+func (ps *Properties) AddValue(id byte, v uint32) error {
+	switch propertySpecs[id].kind {
+	case propByte:
+		if v > 0xFF {
+			return ErrPropertyInvalid
+		}
+	case propUint16:
+		if v > 0xFFFF {
+			return ErrPropertyInvalid
+		}
+	case propUint32:
+	case propVarint:
+		if v > maxVarint {
+			return ErrPropertyInvalid
+		}
+	default:
+		return ErrPropertyInvalid
+	}
+	*ps = append(*ps, Property{ID: id, Value: v})
+	return nil
+}
+
+// AddData adds a UTF-8 Encoded String or Binary Data Property
+func (ps *Properties) AddData(id byte, b []byte) error {
+	switch propertySpecs[id].kind {
+	case propString:
+		if !validString(b) {
+			return ErrPropertyInvalid
+		}
+	case propBinary:
+	default:
+		return ErrPropertyInvalid
+	}
+	if len(b) > 0xFFFF {
+		return ErrPropertyInvalid
+	}
+	*ps = append(*ps, Property{ID: id, Data: b})
+	return nil
+}
+
+// AddUserProperty adds a User Property name-value pair
+func (ps *Properties) AddUserProperty(name, value []byte) error {
+	if !validString(name) || !validString(value) || len(name) > 0xFFFF || len(value) > 0xFFFF {
+		return ErrPropertyInvalid
+	}
+	*ps = append(*ps, Property{ID: UserProperty, Data: name, Pair: value})
+	return nil
+}
+
+// length returns the Property Length, which does not include the bytes used to
+// encode itself
+func (ps Properties) length() int {
+	l := 0
+	for _, p := range ps {
+		l += varintLen(uint32(p.ID))
+		switch propertySpecs[p.ID].kind {
+		case propByte:
+			l++
+		case propUint16:
+			l += 2
+		case propUint32:
+			l += 4
+		case propVarint:
+			l += varintLen(p.Value)
+		case propString, propBinary:
+			l += 2 + len(p.Data)
+		case propPair:
+			l += 4 + len(p.Data) + len(p.Pair)
+		}
+	}
+	return l
+}
+
+// encodedLen returns the length of the Properties including Property Length
+func (ps Properties) encodedLen() int {
+	l := ps.length()
+	return varintLen(uint32(l)) + l
+}
+
+// encode writes Property Length followed by the Properties
+func (ps Properties) encode(dest []byte) int {
+	p := putVarint(dest, uint32(ps.length()))
+	for _, prop := range ps {
+		p += putVarint(dest[p:], uint32(prop.ID))
+		switch propertySpecs[prop.ID].kind {
+		case propByte:
+			dest[p] = byte(prop.Value)
+			p++
+		case propUint16:
+			binary.BigEndian.PutUint16(dest[p:], uint16(prop.Value))
+			p += 2
+		case propUint32:
+			binary.BigEndian.PutUint32(dest[p:], prop.Value)
+			p += 4
+		case propVarint:
+			p += putVarint(dest[p:], prop.Value)
+		case propString, propBinary:
+			p += putBytes(dest[p:], prop.Data)
+		case propPair:
+			p += putBytes(dest[p:], prop.Data)
+			p += putBytes(dest[p:], prop.Pair)
+		}
+	}
+	return p
+}
+
+// decodeProperties reads Property Length and the Properties of a packet of type cpt
+func decodeProperties(src []byte, cpt byte) (Properties, int, error) {
+	l, p, err := readVarint(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(src)-p < int(l) {
+		return nil, 0, ErrMalformedPacket
+	}
+	end := p + int(l)
+
+	var ps Properties
+	seen := map[byte]bool{}
+	for p < end {
+		id, n, err := readVarint(src[p:end])
+		if err != nil {
+			return nil, 0, err
+		}
+		p += n
+
+		spec, ok := propertySpecs[byte(id)]
+		if !ok || id > 0x7F || spec.packets&(1<<cpt) == 0 {
+			return nil, 0, ErrPropertyInvalid
+		}
+		repeatable := id == UserProperty || (id == SubscriptionIdentifier && cpt == PUBLISH)
+		if seen[byte(id)] && !repeatable {
+			return nil, 0, ErrPropertyInvalid
+		}
+		seen[byte(id)] = true
+
+		prop := Property{ID: byte(id)}
+		switch spec.kind {
+		case propByte:
+			if p >= end {
+				return nil, 0, ErrMalformedPacket
+			}
+			prop.Value = uint32(src[p])
+			n = 1
+		case propUint16:
+			var v uint16
+			v, n, err = readUint16(src[p:end])
+			prop.Value = uint32(v)
+		case propUint32:
+			prop.Value, n, err = readUint32(src[p:end])
+		case propVarint:
+			prop.Value, n, err = readVarint(src[p:end])
+			if err == nil && prop.Value == 0 {
+				err = ErrPropertyInvalid
+			}
+		case propString:
+			prop.Data, n, err = readString(src[p:end])
+		case propBinary:
+			prop.Data, n, err = readBytes(src[p:end])
+		case propPair:
+			prop.Data, n, err = readString(src[p:end])
+			if err == nil {
+				var m int
+				prop.Pair, m, err = readString(src[p+n : end])
+				n += m
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		p += n
+		ps = append(ps, prop)
+	}
+
+	return ps, p, nil
+}
@@ -0,0 +1,97 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPropertiesEncodeDecode(t *testing.T) {
+	ps := Properties{}
+	if err := ps.AddValue(MessageExpiryInterval, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddValue(SubscriptionIdentifier, 268435455); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddValue(SubscriptionIdentifier, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddData(ContentType, []byte("text/plain")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddData(CorrelationData, []byte{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddUserProperty([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddValue(TopicAlias, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddValue(PayloadFormatIndicator, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, ps.encodedLen())
+	n := ps.encode(buf)
+	if n != len(buf) {
+		t.Errorf("expected %d bytes encoded, got %d", len(buf), n)
+	}
+
+	d, n, err := decodeProperties(buf, PUBLISH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(buf) {
+		t.Errorf("expected %d bytes decoded, got %d", len(buf), n)
+	}
+	if !reflect.DeepEqual(ps, d) {
+		t.Errorf("expected %v, got %v", ps, d)
+	}
+}
+
+func TestPropertiesAddInvalid(t *testing.T) {
+	ps := Properties{}
+	if ps.AddValue(PayloadFormatIndicator, 256) == nil {
+		t.Error("Byte Property should not be larger than 255")
+	}
+	if ps.AddValue(ReceiveMaximum, 65536) == nil {
+		t.Error("Two Byte Integer Property should not be larger than 65535")
+	}
+	if ps.AddValue(ContentType, 1) == nil {
+		t.Error("UTF-8 Encoded String Property should not take an integer")
+	}
+	if ps.AddData(ResponseTopic, []byte{'a', 0}) == nil {
+		t.Error("UTF-8 Encoded String Property should not contain U+0000")
+	}
+	if ps.AddData(0x7F, []byte("a")) == nil {
+		t.Error("Unknown Property should not be added")
+	}
+	if len(ps) != 0 {
+		t.Error("Invalid Properties should not be added")
+	}
+}
+
+func TestDecodePropertiesInvalid(t *testing.T) {
+	testCases := []struct {
+		in  []byte
+		cpt byte
+	}{
+		// Topic Alias is not allowed in CONNECT
+		{in: []byte{3, TopicAlias, 0, 1}, cpt: CONNECT},
+		// Content Type appears twice
+		{in: []byte{6, ContentType, 0, 1, 'a', ContentType, 0}, cpt: PUBLISH},
+		// Subscription Identifier can be repeated in PUBLISH only
+		{in: []byte{4, SubscriptionIdentifier, 1, SubscriptionIdentifier, 2}, cpt: SUBSCRIBE},
+		// Subscription Identifier of 0 is a Protocol Error
+		{in: []byte{2, SubscriptionIdentifier, 0}, cpt: PUBLISH},
+		// Unknown identifier
+		{in: []byte{2, 0x7F, 0}, cpt: PUBLISH},
+	}
+
+	for _, tc := range testCases {
+		if _, _, err := decodeProperties(tc.in, tc.cpt); err != ErrPropertyInvalid {
+			t.Errorf("% x: expected %v, got %v", tc.in, ErrPropertyInvalid, err)
+		}
+	}
+}
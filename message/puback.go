@@ -1,5 +1,10 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // PubackMessage is that a PUBACK Packet is the response to a PUBLISH Packet with QoS
 // level 1
 type PubackMessage struct {
@@ -8,6 +13,18 @@ type PubackMessage struct {
 	// This contains the Packet Identifier from the PUBLISH Packet that is bening
 	// acknowledged.
 	packetID []byte
+
+	// MQTT 5.0 only. The Reason Code and Properties follow the Packet Identifier and
+	// are omitted when the Reason Code is 0x00 (Success) and there are no Properties.
+	reasonCode byte
+	properties Properties
+}
+
+// NewPubackMessage returns a pointer of PubackMessage
+func NewPubackMessage() *PubackMessage {
+	p := &PubackMessage{}
+	p.SetControlPacketType(PUBACK)
+	return p
 }
 
 // SetPacketID sets Packet Identifier
@@ -19,3 +36,63 @@ func (p *PubackMessage) SetPacketID(v []byte) {
 func (p *PubackMessage) PacketID() []byte {
 	return p.packetID
 }
+
+// SetReasonCode sets Reason Code
+func (p *PubackMessage) SetReasonCode(v byte) {
+	p.reasonCode = v
+}
+
+// ReasonCode returns Reason Code
+func (p *PubackMessage) ReasonCode() byte {
+	return p.reasonCode
+}
+
+// SetProperties sets Properties
+func (p *PubackMessage) SetProperties(v Properties) {
+	p.properties = v
+}
+
+// Properties returns Properties
+func (p *PubackMessage) Properties() Properties {
+	return p.properties
+}
+
+// Len returns the length of the encoded packet
+func (p *PubackMessage) Len() int {
+	ml := ackLen(&p.fixedHeader, p.reasonCode, p.properties)
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (p *PubackMessage) Encode(dest []byte) (int, error) {
+	return encodeAck(&p.fixedHeader, dest, p.packetID, p.reasonCode, p.properties)
+}
+
+// Decode reads the packet from src
+func (p *PubackMessage) Decode(src []byte) (int, error) {
+	id, rc, props, n, err := decodeAck(&p.fixedHeader, src, PUBACK, 0)
+	if err != nil {
+		return 0, err
+	}
+	p.packetID, p.reasonCode, p.properties = id, rc, props
+	return n, nil
+}
+
+// String returns a short description of the packet
+func (p *PubackMessage) String() string {
+	s := fmt.Sprintf("PUBACK(id=%d", packetIDValue(p.packetID))
+	if p.v5() && (p.reasonCode != Success || len(p.properties) > 0) {
+		s += fmt.Sprintf(", rc=0x%02x%s", p.reasonCode, propsString(p.properties))
+	}
+	return s + ")"
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PubackMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		PacketID   uint16     `json:"packetId"`
+		ReasonCode byte       `json:"reasonCode"`
+		Properties Properties `json:"properties,omitempty"`
+	}{"PUBACK", packetIDValue(p.packetID), p.reasonCode, p.properties})
+}
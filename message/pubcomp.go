@@ -1,5 +1,10 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // PubcompMessage is that The PUBCOMP Packet is the response to a PUBREL Packet. It is
 // the fourth and final packet of the QoS 2 protocol exchange.
 type PubcompMessage struct {
@@ -10,6 +15,18 @@ type PubcompMessage struct {
 	// This contains the Packet Identifier from the PUBLISH Packet that is bening
 	// acknowledged.
 	packetID []byte
+
+	// MQTT 5.0 only. The Reason Code and Properties follow the Packet Identifier and
+	// are omitted when the Reason Code is 0x00 (Success) and there are no Properties.
+	reasonCode byte
+	properties Properties
+}
+
+// NewPubcompMessage returns a pointer of PubcompMessage
+func NewPubcompMessage() *PubcompMessage {
+	p := &PubcompMessage{}
+	p.SetControlPacketType(PUBCOMP)
+	return p
 }
 
 // SetPacketID sets Packet Identifier
@@ -21,3 +38,63 @@ func (p *PubcompMessage) SetPacketID(v []byte) {
 func (p *PubcompMessage) PacketID() []byte {
 	return p.packetID
 }
+
+// SetReasonCode sets Reason Code
+func (p *PubcompMessage) SetReasonCode(v byte) {
+	p.reasonCode = v
+}
+
+// ReasonCode returns Reason Code
+func (p *PubcompMessage) ReasonCode() byte {
+	return p.reasonCode
+}
+
+// SetProperties sets Properties
+func (p *PubcompMessage) SetProperties(v Properties) {
+	p.properties = v
+}
+
+// Properties returns Properties
+func (p *PubcompMessage) Properties() Properties {
+	return p.properties
+}
+
+// Len returns the length of the encoded packet
+func (p *PubcompMessage) Len() int {
+	ml := ackLen(&p.fixedHeader, p.reasonCode, p.properties)
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (p *PubcompMessage) Encode(dest []byte) (int, error) {
+	return encodeAck(&p.fixedHeader, dest, p.packetID, p.reasonCode, p.properties)
+}
+
+// Decode reads the packet from src
+func (p *PubcompMessage) Decode(src []byte) (int, error) {
+	id, rc, props, n, err := decodeAck(&p.fixedHeader, src, PUBCOMP, 0)
+	if err != nil {
+		return 0, err
+	}
+	p.packetID, p.reasonCode, p.properties = id, rc, props
+	return n, nil
+}
+
+// String returns a short description of the packet
+func (p *PubcompMessage) String() string {
+	s := fmt.Sprintf("PUBCOMP(id=%d", packetIDValue(p.packetID))
+	if p.v5() && (p.reasonCode != Success || len(p.properties) > 0) {
+		s += fmt.Sprintf(", rc=0x%02x%s", p.reasonCode, propsString(p.properties))
+	}
+	return s + ")"
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PubcompMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		PacketID   uint16     `json:"packetId"`
+		ReasonCode byte       `json:"reasonCode"`
+		Properties Properties `json:"properties,omitempty"`
+	}{"PUBCOMP", packetIDValue(p.packetID), p.reasonCode, p.properties})
+}
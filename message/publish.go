@@ -1,6 +1,17 @@
 package message
 
-import "encoding/binary"
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTopicNameInvalid indicates Topic Name is empty or contains wildcard characters
+	ErrTopicNameInvalid = errors.New("invalid Topic Name")
+)
 
 // PublishMessage is a PUBLISH Control Packet is sent from a Client to a Server or from
 // Server to a Client to transport an Application Message
@@ -97,23 +108,85 @@ type PublishMessage struct {
 	// from the Remaining Length field that is in the Fixed Header. It is valid for
 	// a PUBLISH Packet to contain a zero length payload.
 	payload []byte
+
+	// MQTT 5.0 only. The Properties follow the Packet Identifier in the variable header.
+	properties Properties
+}
+
+// NewPublishMessage returns a pointer of PublishMessage
+func NewPublishMessage() *PublishMessage {
+	p := &PublishMessage{}
+	p.SetControlPacketType(PUBLISH)
+	return p
+}
+
+// SetDup sets DUP flag
+func (p *PublishMessage) SetDup(active bool) {
+	if active {
+		// 00001000
+		p.controlPacket |= 0x08
+	} else {
+		// 11110111
+		p.controlPacket &= 0xF7
+	}
+}
+
+// Dup returns DUP flag
+func (p *PublishMessage) Dup() byte {
+	return (p.controlPacket >> 3) & 0x1
+}
+
+// SetQoS sets QoS level
+func (p *PublishMessage) SetQoS(v byte) error {
+	if v > 2 {
+		return ErrQoSInvalid
+	}
+	// 11111001
+	p.controlPacket = (p.controlPacket & 0xF9) | (v << 1)
+	return nil
+}
+
+// QoS returns QoS level
+func (p *PublishMessage) QoS() byte {
+	return (p.controlPacket >> 1) & 0x3
+}
+
+// SetRetain sets RETAIN flag
+func (p *PublishMessage) SetRetain(active bool) {
+	if active {
+		// 00000001
+		p.controlPacket |= 0x01
+	} else {
+		// 11111110
+		p.controlPacket &= 0xFE
+	}
+}
+
+// Retain returns RETAIN flag
+func (p *PublishMessage) Retain() byte {
+	return p.controlPacket & 0x1
 }
 
 // SetTopicName sets Topic Name and its length
 func (p *PublishMessage) SetTopicName(v []byte) {
-	length := make([]byte, 2)
-	binary.BigEndian.PutUint16(length, uint16(len(v)))
-	p.topicName = append(p.topicName, length...)
+	p.topicName = make([]byte, 2, 2+len(v))
+	binary.BigEndian.PutUint16(p.topicName, uint16(len(v)))
 	p.topicName = append(p.topicName, v...)
 }
 
 // TopicNameLen returns Topic Name first two bytes representing length
 func (p *PublishMessage) TopicNameLen() uint16 {
+	if len(p.topicName) < 2 {
+		return 0
+	}
 	return binary.BigEndian.Uint16(p.topicName[:2])
 }
 
 // TopicName returns real Topic Name content
 func (p *PublishMessage) TopicName() []byte {
+	if len(p.topicName) < 2 {
+		return nil
+	}
 	return p.topicName[2:]
 }
 
@@ -136,3 +209,145 @@ func (p *PublishMessage) SetPayload(v []byte) {
 func (p *PublishMessage) Payload() []byte {
 	return p.payload
 }
+
+// SetProperties sets Properties
+func (p *PublishMessage) SetProperties(v Properties) {
+	p.properties = v
+}
+
+// Properties returns Properties
+func (p *PublishMessage) Properties() Properties {
+	return p.properties
+}
+
+// msgLen returns Remaining Length
+func (p *PublishMessage) msgLen() int {
+	l := 2 + len(p.TopicName())
+	if p.QoS() > 0 {
+		l += 2
+	}
+	if p.v5() {
+		l += p.properties.encodedLen()
+	}
+	return l + len(p.payload)
+}
+
+// Len returns the length of the encoded packet
+func (p *PublishMessage) Len() int {
+	ml := p.msgLen()
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (p *PublishMessage) Encode(dest []byte) (int, error) {
+	if p.QoS() == 3 {
+		return 0, ErrQoSInvalid
+	}
+	if !p.validTopicName(p.TopicName()) {
+		return 0, ErrTopicNameInvalid
+	}
+
+	i, err := p.fixedHeader.encodeAs(dest, p.msgLen())
+	if err != nil {
+		return i, err
+	}
+
+	i += putBytes(dest[i:], p.TopicName())
+
+	if p.QoS() > 0 {
+		n, err := putPacketID(dest[i:], p.packetID)
+		if err != nil {
+			return i, err
+		}
+		i += n
+	}
+
+	if p.v5() {
+		i += p.properties.encode(dest[i:])
+	}
+
+	i += copy(dest[i:], p.payload)
+
+	return i, nil
+}
+
+// Decode reads the packet from src
+func (p *PublishMessage) Decode(src []byte) (int, error) {
+	i, err := p.fixedHeader.decodeAs(src, PUBLISH, 0)
+	if err != nil {
+		return 0, err
+	}
+	end := i + int(p.remainingLength)
+
+	if p.QoS() == 3 {
+		return 0, ErrQoSInvalid
+	}
+
+	tn, n, err := readString(src[i:end])
+	if err != nil {
+		return 0, err
+	}
+	p.topicName = src[i : i+n : i+n]
+	i += n
+
+	p.packetID = nil
+	if p.QoS() > 0 {
+		p.packetID, n, err = readPacketID(src[i:end])
+		if err != nil {
+			return 0, err
+		}
+		i += n
+	}
+
+	p.properties = nil
+	if p.v5() {
+		p.properties, n, err = decodeProperties(src[i:end], PUBLISH)
+		if err != nil {
+			return 0, err
+		}
+		i += n
+	}
+	if !p.validTopicName(tn) {
+		return 0, ErrTopicNameInvalid
+	}
+
+	p.payload = src[i:end:end]
+
+	return end, nil
+}
+
+// validTopicName reports whether tn can be used as Topic Name. The Topic Name in
+// the PUBLISH Packet MUST NOT contain wildcard characters [MQTT-3.3.2-2]. In MQTT
+// 5.0 it can be empty when a Topic Alias is used instead.
+func (p *PublishMessage) validTopicName(tn []byte) bool {
+	if len(tn) == 0 {
+		_, ok := p.properties.Get(TopicAlias)
+		return p.v5() && ok
+	}
+	return bytes.IndexAny(tn, "+#") < 0
+}
+
+// String returns a short description of the packet, e.g.
+// PUBLISH(q1, r0, d0, id=17, topic="a/b", 1024B)
+func (p *PublishMessage) String() string {
+	s := fmt.Sprintf("PUBLISH(q%d, r%d, d%d", p.QoS(), p.Retain(), p.Dup())
+	if p.QoS() > 0 {
+		s += fmt.Sprintf(", id=%d", packetIDValue(p.packetID))
+	}
+	return s + fmt.Sprintf(", topic=%q, %dB%s)", p.TopicName(), len(p.payload), propsString(p.properties))
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PublishMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		QoS        byte       `json:"qos"`
+		Retain     bool       `json:"retain"`
+		Dup        bool       `json:"dup"`
+		PacketID   uint16     `json:"packetId,omitempty"`
+		Topic      string     `json:"topic"`
+		Properties Properties `json:"properties,omitempty"`
+		Payload    binaryJSON `json:"payload"`
+	}{"PUBLISH", p.QoS(), p.Retain() == 1, p.Dup() == 1, packetIDValue(p.packetID),
+		string(p.TopicName()), p.properties, binaryJSON(p.payload)})
+}
@@ -1,5 +1,10 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // PubrecMessage is that a PUBREC Packet is the response to a PUBLISH Packet with QoS 2.
 // It is the second packet of the QoS 2 protoocl exchange.
 type PubrecMessage struct {
@@ -10,6 +15,18 @@ type PubrecMessage struct {
 	// This contains the Packet Identifier from the PUBLISH Packet that is bening
 	// acknowledged.
 	packetID []byte
+
+	// MQTT 5.0 only. The Reason Code and Properties follow the Packet Identifier and
+	// are omitted when the Reason Code is 0x00 (Success) and there are no Properties.
+	reasonCode byte
+	properties Properties
+}
+
+// NewPubrecMessage returns a pointer of PubrecMessage
+func NewPubrecMessage() *PubrecMessage {
+	p := &PubrecMessage{}
+	p.SetControlPacketType(PUBREC)
+	return p
 }
 
 // SetPacketID sets Packet Identifier
@@ -21,3 +38,63 @@ func (p *PubrecMessage) SetPacketID(v []byte) {
 func (p *PubrecMessage) PacketID() []byte {
 	return p.packetID
 }
+
+// SetReasonCode sets Reason Code
+func (p *PubrecMessage) SetReasonCode(v byte) {
+	p.reasonCode = v
+}
+
+// ReasonCode returns Reason Code
+func (p *PubrecMessage) ReasonCode() byte {
+	return p.reasonCode
+}
+
+// SetProperties sets Properties
+func (p *PubrecMessage) SetProperties(v Properties) {
+	p.properties = v
+}
+
+// Properties returns Properties
+func (p *PubrecMessage) Properties() Properties {
+	return p.properties
+}
+
+// Len returns the length of the encoded packet
+func (p *PubrecMessage) Len() int {
+	ml := ackLen(&p.fixedHeader, p.reasonCode, p.properties)
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (p *PubrecMessage) Encode(dest []byte) (int, error) {
+	return encodeAck(&p.fixedHeader, dest, p.packetID, p.reasonCode, p.properties)
+}
+
+// Decode reads the packet from src
+func (p *PubrecMessage) Decode(src []byte) (int, error) {
+	id, rc, props, n, err := decodeAck(&p.fixedHeader, src, PUBREC, 0)
+	if err != nil {
+		return 0, err
+	}
+	p.packetID, p.reasonCode, p.properties = id, rc, props
+	return n, nil
+}
+
+// String returns a short description of the packet
+func (p *PubrecMessage) String() string {
+	s := fmt.Sprintf("PUBREC(id=%d", packetIDValue(p.packetID))
+	if p.v5() && (p.reasonCode != Success || len(p.properties) > 0) {
+		s += fmt.Sprintf(", rc=0x%02x%s", p.reasonCode, propsString(p.properties))
+	}
+	return s + ")"
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PubrecMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		PacketID   uint16     `json:"packetId"`
+		ReasonCode byte       `json:"reasonCode"`
+		Properties Properties `json:"properties,omitempty"`
+	}{"PUBREC", packetIDValue(p.packetID), p.reasonCode, p.properties})
+}
@@ -1,5 +1,10 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // PubrelMessage is that a PUBREL Packet is the response to a PUBREC Packet. It is the
 // third packet of the QoS 2 protocol exchange
 type PubrelMessage struct {
@@ -14,6 +19,19 @@ type PubrelMessage struct {
 	// The variable header contains the same Packet Identifier as the PUBREC Packet that is being
 	// acknowledged.
 	packetID []byte
+
+	// MQTT 5.0 only. The Reason Code and Properties follow the Packet Identifier and
+	// are omitted when the Reason Code is 0x00 (Success) and there are no Properties.
+	reasonCode byte
+	properties Properties
+}
+
+// NewPubrelMessage returns a pointer of PubrelMessage
+func NewPubrelMessage() *PubrelMessage {
+	p := &PubrelMessage{}
+	p.SetControlPacketType(PUBREL)
+	p.SetControlPacketTypeFlag(0x02)
+	return p
 }
 
 // SetPacketID sets Packet Identifier
@@ -25,3 +43,63 @@ func (p *PubrelMessage) SetPacketID(v []byte) {
 func (p *PubrelMessage) PacketID() []byte {
 	return p.packetID
 }
+
+// SetReasonCode sets Reason Code
+func (p *PubrelMessage) SetReasonCode(v byte) {
+	p.reasonCode = v
+}
+
+// ReasonCode returns Reason Code
+func (p *PubrelMessage) ReasonCode() byte {
+	return p.reasonCode
+}
+
+// SetProperties sets Properties
+func (p *PubrelMessage) SetProperties(v Properties) {
+	p.properties = v
+}
+
+// Properties returns Properties
+func (p *PubrelMessage) Properties() Properties {
+	return p.properties
+}
+
+// Len returns the length of the encoded packet
+func (p *PubrelMessage) Len() int {
+	ml := ackLen(&p.fixedHeader, p.reasonCode, p.properties)
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (p *PubrelMessage) Encode(dest []byte) (int, error) {
+	return encodeAck(&p.fixedHeader, dest, p.packetID, p.reasonCode, p.properties)
+}
+
+// Decode reads the packet from src
+func (p *PubrelMessage) Decode(src []byte) (int, error) {
+	id, rc, props, n, err := decodeAck(&p.fixedHeader, src, PUBREL, 0x02)
+	if err != nil {
+		return 0, err
+	}
+	p.packetID, p.reasonCode, p.properties = id, rc, props
+	return n, nil
+}
+
+// String returns a short description of the packet
+func (p *PubrelMessage) String() string {
+	s := fmt.Sprintf("PUBREL(id=%d", packetIDValue(p.packetID))
+	if p.v5() && (p.reasonCode != Success || len(p.properties) > 0) {
+		s += fmt.Sprintf(", rc=0x%02x%s", p.reasonCode, propsString(p.properties))
+	}
+	return s + ")"
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PubrelMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		PacketID   uint16     `json:"packetId"`
+		ReasonCode byte       `json:"reasonCode"`
+		Properties Properties `json:"properties,omitempty"`
+	}{"PUBREL", packetIDValue(p.packetID), p.reasonCode, p.properties})
+}
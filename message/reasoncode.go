@@ -0,0 +1,102 @@
+package message
+
+import "errors"
+
+var (
+	// ErrReasonCodeInvalid indicates a Reason Code is not allowed in the packet carrying it
+	ErrReasonCodeInvalid = errors.New("invalid Reason Code")
+)
+
+// Reason Codes defined by MQTT 5.0 section 2.4. A Reason Code less than 0x80
+// indicates successful completion of an operation, 0x80 or greater indicates failure.
+const (
+	Success                             = 0x00
+	NormalDisconnection                 = 0x00
+	GrantedQoS0                         = 0x00
+	GrantedQoS1                         = 0x01
+	GrantedQoS2                         = 0x02
+	DisconnectWithWillMessage           = 0x04
+	NoMatchingSubscribers               = 0x10
+	NoSubscriptionExisted               = 0x11
+	ContinueAuthentication              = 0x18
+	ReAuthenticate                      = 0x19
+	UnspecifiedError                    = 0x80
+	MalformedPacket                     = 0x81
+	ProtocolError                       = 0x82
+	ImplementationSpecificError         = 0x83
+	UnsupportedProtocolVersion          = 0x84
+	ClientIdentifierNotValid            = 0x85
+	BadUserNameOrPassword               = 0x86
+	NotAuthorized                       = 0x87
+	ServerUnavailable                   = 0x88
+	ServerBusy                          = 0x89
+	Banned                              = 0x8A
+	ServerShuttingDown                  = 0x8B
+	BadAuthenticationMethod             = 0x8C
+	KeepAliveTimeout                    = 0x8D
+	SessionTakenOver                    = 0x8E
+	TopicFilterInvalid                  = 0x8F
+	TopicNameInvalid                    = 0x90
+	PacketIdentifierInUse               = 0x91
+	PacketIdentifierNotFound            = 0x92
+	ReceiveMaximumExceeded              = 0x93
+	TopicAliasInvalid                   = 0x94
+	PacketTooLarge                      = 0x95
+	MessageRateTooHigh                  = 0x96
+	QuotaExceeded                       = 0x97
+	AdministrativeAction                = 0x98
+	PayloadFormatInvalid                = 0x99
+	RetainNotSupported                  = 0x9A
+	QoSNotSupported                     = 0x9B
+	UseAnotherServer                    = 0x9C
+	ServerMoved                         = 0x9D
+	SharedSubscriptionsNotSupported     = 0x9E
+	ConnectionRateExceeded              = 0x9F
+	MaximumConnectTime                  = 0xA0
+	SubscriptionIdentifiersNotSupported = 0xA1
+	WildcardSubscriptionsNotSupported   = 0xA2
+)
+
+func codes(cs ...byte) map[byte]bool {
+	m := make(map[byte]bool, len(cs))
+	for _, c := range cs {
+		m[c] = true
+	}
+	return m
+}
+
+// reasonCodes lists the Reason Codes each MQTT 5.0 packet type may carry
+var reasonCodes = map[byte]map[byte]bool{
+	CONNACK: codes(Success, UnspecifiedError, MalformedPacket, ProtocolError,
+		ImplementationSpecificError, UnsupportedProtocolVersion, ClientIdentifierNotValid,
+		BadUserNameOrPassword, NotAuthorized, ServerUnavailable, ServerBusy, Banned,
+		BadAuthenticationMethod, TopicNameInvalid, PacketTooLarge, QuotaExceeded,
+		PayloadFormatInvalid, RetainNotSupported, QoSNotSupported, UseAnotherServer,
+		ServerMoved, ConnectionRateExceeded),
+	PUBACK: codes(Success, NoMatchingSubscribers, UnspecifiedError, ImplementationSpecificError,
+		NotAuthorized, TopicNameInvalid, PacketIdentifierInUse, QuotaExceeded, PayloadFormatInvalid),
+	PUBREC: codes(Success, NoMatchingSubscribers, UnspecifiedError, ImplementationSpecificError,
+		NotAuthorized, TopicNameInvalid, PacketIdentifierInUse, QuotaExceeded, PayloadFormatInvalid),
+	PUBREL:  codes(Success, PacketIdentifierNotFound),
+	PUBCOMP: codes(Success, PacketIdentifierNotFound),
+	SUBACK: codes(GrantedQoS0, GrantedQoS1, GrantedQoS2, UnspecifiedError,
+		ImplementationSpecificError, NotAuthorized, TopicFilterInvalid, PacketIdentifierInUse,
+		QuotaExceeded, SharedSubscriptionsNotSupported, SubscriptionIdentifiersNotSupported,
+		WildcardSubscriptionsNotSupported),
+	UNSUBACK: codes(Success, NoSubscriptionExisted, UnspecifiedError, ImplementationSpecificError,
+		NotAuthorized, TopicFilterInvalid, PacketIdentifierInUse),
+	DISCONNECT: codes(NormalDisconnection, DisconnectWithWillMessage, UnspecifiedError,
+		MalformedPacket, ProtocolError, ImplementationSpecificError, NotAuthorized, ServerBusy,
+		ServerShuttingDown, KeepAliveTimeout, SessionTakenOver, TopicFilterInvalid,
+		TopicNameInvalid, ReceiveMaximumExceeded, TopicAliasInvalid, PacketTooLarge,
+		MessageRateTooHigh, QuotaExceeded, AdministrativeAction, PayloadFormatInvalid,
+		RetainNotSupported, QoSNotSupported, UseAnotherServer, ServerMoved,
+		SharedSubscriptionsNotSupported, ConnectionRateExceeded, MaximumConnectTime,
+		SubscriptionIdentifiersNotSupported, WildcardSubscriptionsNotSupported),
+	AUTH: codes(Success, ContinueAuthentication, ReAuthenticate),
+}
+
+// validReasonCode reports whether packets of type cpt may carry Reason Code rc
+func validReasonCode(cpt byte, rc byte) bool {
+	return reasonCodes[cpt][rc]
+}
@@ -1,5 +1,10 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // SubackMessage is that A SUBACK Packet is sent by the Server to the Client to
 // confirm receipt and processing of a SUBSCRIBE Packet
 //
@@ -25,7 +30,19 @@ type SubackMessage struct {
 	//
 	// SUBACK return codes other than 0x00, 0x01, 0x02 and 0x80 are reserved and MUST NOT
 	// be used[MQTT-3.9.3-2]
-	qos byte
+	//
+	// In MQTT 5.0 the return codes are Reason Codes.
+	returnCodes []byte
+
+	// MQTT 5.0 only. The Properties follow the Packet Identifier in the variable header.
+	properties Properties
+}
+
+// NewSubackMessage returns a pointer of SubackMessage
+func NewSubackMessage() *SubackMessage {
+	s := &SubackMessage{}
+	s.SetControlPacketType(SUBACK)
+	return s
 }
 
 // SetPacketID sets Packet Identifier
@@ -38,16 +55,150 @@ func (s *SubackMessage) PacketID() []byte {
 	return s.packetID
 }
 
-// SetQoS sets QoS
+// SetQoS sets QoS as the only return code
 func (s *SubackMessage) SetQoS(v byte) error {
-	if (v > 0 && v < 4) || v == 128 {
-		s.qos = v
+	if v < 4 || v == 128 {
+		s.returnCodes = []byte{v}
 		return nil
 	}
 	return ErrQoSInvalid
 }
 
-// QoS returns QoS
+// QoS returns the first return code
 func (s *SubackMessage) QoS() byte {
-	return s.qos
+	if len(s.returnCodes) == 0 {
+		return 0
+	}
+	return s.returnCodes[0]
+}
+
+// AddReturnCode adds the return code of the next Topic Filter
+func (s *SubackMessage) AddReturnCode(v byte) error {
+	if !s.validReturnCode(v) {
+		return ErrReasonCodeInvalid
+	}
+	s.returnCodes = append(s.returnCodes, v)
+	return nil
+}
+
+// ReturnCodes returns all return codes
+func (s *SubackMessage) ReturnCodes() []byte {
+	return s.returnCodes
+}
+
+// SetProperties sets Properties
+func (s *SubackMessage) SetProperties(v Properties) {
+	s.properties = v
+}
+
+// Properties returns Properties
+func (s *SubackMessage) Properties() Properties {
+	return s.properties
+}
+
+// validReturnCode reports whether v is allowed for the version of the packet
+func (s *SubackMessage) validReturnCode(v byte) bool {
+	if s.v5() {
+		return validReasonCode(SUBACK, v)
+	}
+	return v < 3 || v == 0x80
+}
+
+// msgLen returns Remaining Length
+func (s *SubackMessage) msgLen() int {
+	l := 2 + len(s.returnCodes)
+	if s.v5() {
+		l += s.properties.encodedLen()
+	}
+	return l
+}
+
+// Len returns the length of the encoded packet
+func (s *SubackMessage) Len() int {
+	ml := s.msgLen()
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (s *SubackMessage) Encode(dest []byte) (int, error) {
+	for _, rc := range s.returnCodes {
+		if !s.validReturnCode(rc) {
+			return 0, ErrReasonCodeInvalid
+		}
+	}
+
+	p, err := s.fixedHeader.encodeAs(dest, s.msgLen())
+	if err != nil {
+		return p, err
+	}
+
+	n, err := putPacketID(dest[p:], s.packetID)
+	if err != nil {
+		return p, err
+	}
+	p += n
+
+	if s.v5() {
+		p += s.properties.encode(dest[p:])
+	}
+
+	p += copy(dest[p:], s.returnCodes)
+
+	return p, nil
+}
+
+// Decode reads the packet from src
+func (s *SubackMessage) Decode(src []byte) (int, error) {
+	p, err := s.fixedHeader.decodeAs(src, SUBACK, 0)
+	if err != nil {
+		return 0, err
+	}
+	end := p + int(s.remainingLength)
+
+	var n int
+	s.packetID, n, err = readPacketID(src[p:end])
+	if err != nil {
+		return 0, err
+	}
+	p += n
+
+	s.properties = nil
+	if s.v5() {
+		s.properties, n, err = decodeProperties(src[p:end], SUBACK)
+		if err != nil {
+			return 0, err
+		}
+		p += n
+	}
+
+	if p == end {
+		return 0, ErrMalformedPacket
+	}
+	for _, rc := range src[p:end] {
+		if !s.validReturnCode(rc) {
+			return 0, ErrReasonCodeInvalid
+		}
+	}
+	s.returnCodes = src[p:end:end]
+
+	return end, nil
+}
+
+// String returns a short description of the packet
+func (s *SubackMessage) String() string {
+	return fmt.Sprintf("SUBACK(id=%d, %s%s)", packetIDValue(s.packetID), codesString(s.returnCodes), propsString(s.properties))
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *SubackMessage) MarshalJSON() ([]byte, error) {
+	codes := make([]int, len(s.returnCodes))
+	for i, c := range s.returnCodes {
+		codes[i] = int(c)
+	}
+	return json.Marshal(struct {
+		Type        string     `json:"type"`
+		PacketID    uint16     `json:"packetId"`
+		Properties  Properties `json:"properties,omitempty"`
+		ReturnCodes []int      `json:"returnCodes"`
+	}{"SUBACK", packetIDValue(s.packetID), s.properties, codes})
 }
@@ -1,5 +1,10 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // SubscribeMessage is that The SUBSCRIBE Packet is sent from the Client to the Server
 // to create one or more Subscriptions. Each Subscription registers a Client's interest
 // in one or more Topics. The Server sends PUBLISH Packets to the Client in order to
@@ -36,8 +41,33 @@ type SubscribeMessage struct {
 	// of the protocol. They are reserved for future use. The Server MUST treat a
 	// SUBSCRIBE packet as malformed and close the Network Connection if any of
 	// Reserved bits in the payload are non-zero, or QoS is not 0, 1 and 2[MQTT-3.8.3-4].
+	//
+	// In MQTT 5.0 the Requested QoS byte is called Subscription Options. Bits 0 and
+	// 1 are the Maximum QoS, bit 2 is No Local, bit 3 is Retain As Published, bits
+	// 4 and 5 are Retain Handling and bits 6 and 7 are reserved.
 	topics [][]byte
 	qos    []byte
+
+	// MQTT 5.0 only. The Properties follow the Packet Identifier in the variable header.
+	properties Properties
+}
+
+// NewSubscribeMessage returns a pointer of SubscribeMessage
+func NewSubscribeMessage() *SubscribeMessage {
+	s := &SubscribeMessage{}
+	s.SetControlPacketType(SUBSCRIBE)
+	s.SetControlPacketTypeFlag(0x02)
+	return s
+}
+
+// SetPacketID sets Packet Identifier
+func (s *SubscribeMessage) SetPacketID(v []byte) {
+	s.packetID = v
+}
+
+// PacketID returns Packet Identifier
+func (s *SubscribeMessage) PacketID() []byte {
+	return s.packetID
 }
 
 // addTopic adds topic
@@ -66,10 +96,163 @@ func (s *SubscribeMessage) QoS() []byte {
 
 // Add adds Topic with QoS
 func (s *SubscribeMessage) Add(t []byte, q byte) error {
-	s.addTopic(t)
 	err := s.addQoS(q)
 	if err != nil {
 		return err
 	}
+	s.addTopic(t)
+	return nil
+}
+
+// AddWithOptions adds Topic with MQTT 5.0 Subscription Options
+func (s *SubscribeMessage) AddWithOptions(t []byte, opts byte) error {
+	if !validSubscriptionOptions(opts) {
+		return ErrQoSInvalid
+	}
+	s.qos = append(s.qos, opts)
+	s.addTopic(t)
 	return nil
 }
+
+// SetProperties sets Properties
+func (s *SubscribeMessage) SetProperties(v Properties) {
+	s.properties = v
+}
+
+// Properties returns Properties
+func (s *SubscribeMessage) Properties() Properties {
+	return s.properties
+}
+
+// msgLen returns Remaining Length
+func (s *SubscribeMessage) msgLen() int {
+	l := 2
+	if s.v5() {
+		l += s.properties.encodedLen()
+	}
+	for _, t := range s.topics {
+		l += 2 + len(t) + 1
+	}
+	return l
+}
+
+// Len returns the length of the encoded packet
+func (s *SubscribeMessage) Len() int {
+	ml := s.msgLen()
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (s *SubscribeMessage) Encode(dest []byte) (int, error) {
+	if len(s.topics) == 0 {
+		return 0, ErrTopicFilterMissing
+	}
+
+	p, err := s.fixedHeader.encodeAs(dest, s.msgLen())
+	if err != nil {
+		return p, err
+	}
+
+	n, err := putPacketID(dest[p:], s.packetID)
+	if err != nil {
+		return p, err
+	}
+	p += n
+
+	if s.v5() {
+		p += s.properties.encode(dest[p:])
+	}
+
+	for i, t := range s.topics {
+		p += putBytes(dest[p:], t)
+		dest[p] = s.qos[i]
+		p++
+	}
+
+	return p, nil
+}
+
+// Decode reads the packet from src
+func (s *SubscribeMessage) Decode(src []byte) (int, error) {
+	p, err := s.fixedHeader.decodeAs(src, SUBSCRIBE, 0x02)
+	if err != nil {
+		return 0, err
+	}
+	end := p + int(s.remainingLength)
+
+	var n int
+	s.packetID, n, err = readPacketID(src[p:end])
+	if err != nil {
+		return 0, err
+	}
+	p += n
+
+	s.properties = nil
+	if s.v5() {
+		s.properties, n, err = decodeProperties(src[p:end], SUBSCRIBE)
+		if err != nil {
+			return 0, err
+		}
+		p += n
+	}
+
+	s.topics, s.qos = nil, nil
+	for p < end {
+		t, n, err := readString(src[p:end])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+		if p >= end {
+			return 0, ErrMalformedPacket
+		}
+
+		q := src[p]
+		p++
+		if (!s.v5() && q > 2) || (s.v5() && !validSubscriptionOptions(q)) {
+			return 0, ErrQoSInvalid
+		}
+
+		s.topics = append(s.topics, t)
+		s.qos = append(s.qos, q)
+	}
+	if len(s.topics) == 0 {
+		return 0, ErrTopicFilterMissing
+	}
+
+	return p, nil
+}
+
+// validSubscriptionOptions reports whether reserved bits are zero, Maximum QoS is
+// not 3 and Retain Handling is not 3
+func validSubscriptionOptions(opts byte) bool {
+	return opts&0xC0 == 0 && opts&0x03 != 0x03 && opts&0x30 != 0x30
+}
+
+// String returns a short description of the packet
+func (s *SubscribeMessage) String() string {
+	str := fmt.Sprintf("SUBSCRIBE(id=%d", packetIDValue(s.packetID))
+	for i, t := range s.topics {
+		str += fmt.Sprintf(", %q=q%d", t, s.qos[i]&0x03)
+	}
+	return str + propsString(s.properties) + ")"
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *SubscribeMessage) MarshalJSON() ([]byte, error) {
+	type filter struct {
+		Topic   string `json:"topic"`
+		QoS     byte   `json:"qos"`
+		Options byte   `json:"options,omitempty"`
+	}
+	filters := make([]filter, len(s.topics))
+	for i, t := range s.topics {
+		filters[i] = filter{string(t), s.qos[i] & 0x03, s.qos[i] &^ 0x03}
+	}
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		PacketID   uint16     `json:"packetId"`
+		Properties Properties `json:"properties,omitempty"`
+		Filters    []filter   `json:"filters"`
+	}{"SUBSCRIBE", packetIDValue(s.packetID), s.properties, filters})
+}
@@ -1,5 +1,10 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // UnsubackMessage is that the UNSUBACK Packet is sent by the Server to the Client
 // to confirm receipt of an UNSUBSCRIBE Packet
 type UnsubackMessage struct {
@@ -11,6 +16,18 @@ type UnsubackMessage struct {
 	// The variable header contains the Packet Identifier of the UNSUBSCRIBE Packet
 	// is being acknowledged.
 	packetID []byte
+
+	// MQTT 5.0 only. The Properties follow the Packet Identifier and the payload holds
+	// one Reason Code for each Topic Filter in the UNSUBSCRIBE Packet, in the same order.
+	properties  Properties
+	reasonCodes []byte
+}
+
+// NewUnsubackMessage returns a pointer of UnsubackMessage
+func NewUnsubackMessage() *UnsubackMessage {
+	s := &UnsubackMessage{}
+	s.SetControlPacketType(UNSUBACK)
+	return s
 }
 
 // SetPacketID sets Packet Identifier
@@ -22,3 +39,129 @@ func (s *UnsubackMessage) SetPacketID(v []byte) {
 func (s *UnsubackMessage) PacketID() []byte {
 	return s.packetID
 }
+
+// AddReasonCode adds the Reason Code of the next Topic Filter
+func (s *UnsubackMessage) AddReasonCode(v byte) error {
+	if !validReasonCode(UNSUBACK, v) {
+		return ErrReasonCodeInvalid
+	}
+	s.reasonCodes = append(s.reasonCodes, v)
+	return nil
+}
+
+// ReasonCodes returns all Reason Codes
+func (s *UnsubackMessage) ReasonCodes() []byte {
+	return s.reasonCodes
+}
+
+// SetProperties sets Properties
+func (s *UnsubackMessage) SetProperties(v Properties) {
+	s.properties = v
+}
+
+// Properties returns Properties
+func (s *UnsubackMessage) Properties() Properties {
+	return s.properties
+}
+
+// msgLen returns Remaining Length
+func (s *UnsubackMessage) msgLen() int {
+	if s.v5() {
+		return 2 + s.properties.encodedLen() + len(s.reasonCodes)
+	}
+	return 2
+}
+
+// Len returns the length of the encoded packet
+func (s *UnsubackMessage) Len() int {
+	ml := s.msgLen()
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (s *UnsubackMessage) Encode(dest []byte) (int, error) {
+	if s.v5() && len(s.reasonCodes) == 0 {
+		return 0, ErrReasonCodeInvalid
+	}
+
+	p, err := s.fixedHeader.encodeAs(dest, s.msgLen())
+	if err != nil {
+		return p, err
+	}
+
+	n, err := putPacketID(dest[p:], s.packetID)
+	if err != nil {
+		return p, err
+	}
+	p += n
+
+	if s.v5() {
+		p += s.properties.encode(dest[p:])
+		p += copy(dest[p:], s.reasonCodes)
+	}
+
+	return p, nil
+}
+
+// Decode reads the packet from src
+func (s *UnsubackMessage) Decode(src []byte) (int, error) {
+	p, err := s.fixedHeader.decodeAs(src, UNSUBACK, 0)
+	if err != nil {
+		return 0, err
+	}
+	end := p + int(s.remainingLength)
+	if !s.v5() && s.remainingLength != 2 {
+		return 0, ErrMalformedPacket
+	}
+
+	var n int
+	s.packetID, n, err = readPacketID(src[p:end])
+	if err != nil {
+		return 0, err
+	}
+	p += n
+
+	s.properties, s.reasonCodes = nil, nil
+	if s.v5() {
+		s.properties, n, err = decodeProperties(src[p:end], UNSUBACK)
+		if err != nil {
+			return 0, err
+		}
+		p += n
+
+		if p == end {
+			return 0, ErrMalformedPacket
+		}
+		for _, rc := range src[p:end] {
+			if !validReasonCode(UNSUBACK, rc) {
+				return 0, ErrReasonCodeInvalid
+			}
+		}
+		s.reasonCodes = src[p:end:end]
+		p = end
+	}
+
+	return p, nil
+}
+
+// String returns a short description of the packet
+func (s *UnsubackMessage) String() string {
+	if !s.v5() {
+		return fmt.Sprintf("UNSUBACK(id=%d)", packetIDValue(s.packetID))
+	}
+	return fmt.Sprintf("UNSUBACK(id=%d, %s%s)", packetIDValue(s.packetID), codesString(s.reasonCodes), propsString(s.properties))
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *UnsubackMessage) MarshalJSON() ([]byte, error) {
+	codes := make([]int, len(s.reasonCodes))
+	for i, c := range s.reasonCodes {
+		codes[i] = int(c)
+	}
+	return json.Marshal(struct {
+		Type        string     `json:"type"`
+		PacketID    uint16     `json:"packetId"`
+		Properties  Properties `json:"properties,omitempty"`
+		ReasonCodes []int      `json:"reasonCodes,omitempty"`
+	}{"UNSUBACK", packetIDValue(s.packetID), s.properties, codes})
+}
@@ -1,5 +1,10 @@
 package message
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // UnsubscribeMessage is that an UNSUBSCRIBE Packet is sent by the Client to the Server,
 // to unsubscribe from topics.
 type UnsubscribeMessage struct {
@@ -21,6 +26,17 @@ type UnsubscribeMessage struct {
 	// An UNSUBSCRIBE packet with no payload is a protocol violation[MQTT-3.10.3-2].
 	// See section 4.8 for information about handling errors.
 	topics [][]byte
+
+	// MQTT 5.0 only. The Properties follow the Packet Identifier in the variable header.
+	properties Properties
+}
+
+// NewUnsubscribeMessage returns a pointer of UnsubscribeMessage
+func NewUnsubscribeMessage() *UnsubscribeMessage {
+	s := &UnsubscribeMessage{}
+	s.SetControlPacketType(UNSUBSCRIBE)
+	s.SetControlPacketTypeFlag(0x02)
+	return s
 }
 
 // SetPacketID sets Packet Identifier
@@ -42,3 +58,122 @@ func (s *UnsubscribeMessage) AddTopic(t []byte) {
 func (s *UnsubscribeMessage) Topics() [][]byte {
 	return s.topics
 }
+
+// SetProperties sets Properties
+func (s *UnsubscribeMessage) SetProperties(v Properties) {
+	s.properties = v
+}
+
+// Properties returns Properties
+func (s *UnsubscribeMessage) Properties() Properties {
+	return s.properties
+}
+
+// msgLen returns Remaining Length
+func (s *UnsubscribeMessage) msgLen() int {
+	l := 2
+	if s.v5() {
+		l += s.properties.encodedLen()
+	}
+	for _, t := range s.topics {
+		l += 2 + len(t)
+	}
+	return l
+}
+
+// Len returns the length of the encoded packet
+func (s *UnsubscribeMessage) Len() int {
+	ml := s.msgLen()
+	return headerLen(ml) + ml
+}
+
+// Encode convert the struct to bytes
+func (s *UnsubscribeMessage) Encode(dest []byte) (int, error) {
+	if len(s.topics) == 0 {
+		return 0, ErrTopicFilterMissing
+	}
+
+	p, err := s.fixedHeader.encodeAs(dest, s.msgLen())
+	if err != nil {
+		return p, err
+	}
+
+	n, err := putPacketID(dest[p:], s.packetID)
+	if err != nil {
+		return p, err
+	}
+	p += n
+
+	if s.v5() {
+		p += s.properties.encode(dest[p:])
+	}
+
+	for _, t := range s.topics {
+		p += putBytes(dest[p:], t)
+	}
+
+	return p, nil
+}
+
+// Decode reads the packet from src
+func (s *UnsubscribeMessage) Decode(src []byte) (int, error) {
+	p, err := s.fixedHeader.decodeAs(src, UNSUBSCRIBE, 0x02)
+	if err != nil {
+		return 0, err
+	}
+	end := p + int(s.remainingLength)
+
+	var n int
+	s.packetID, n, err = readPacketID(src[p:end])
+	if err != nil {
+		return 0, err
+	}
+	p += n
+
+	s.properties = nil
+	if s.v5() {
+		s.properties, n, err = decodeProperties(src[p:end], UNSUBSCRIBE)
+		if err != nil {
+			return 0, err
+		}
+		p += n
+	}
+
+	s.topics = nil
+	for p < end {
+		t, n, err := readString(src[p:end])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+		s.topics = append(s.topics, t)
+	}
+	if len(s.topics) == 0 {
+		return 0, ErrTopicFilterMissing
+	}
+
+	return p, nil
+}
+
+// String returns a short description of the packet
+func (s *UnsubscribeMessage) String() string {
+	str := fmt.Sprintf("UNSUBSCRIBE(id=%d", packetIDValue(s.packetID))
+	for _, t := range s.topics {
+		str += fmt.Sprintf(", %q", t)
+	}
+	return str + propsString(s.properties) + ")"
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *UnsubscribeMessage) MarshalJSON() ([]byte, error) {
+	topics := make([]string, len(s.topics))
+	for i, t := range s.topics {
+		topics[i] = string(t)
+	}
+	return json.Marshal(struct {
+		Type       string     `json:"type"`
+		PacketID   uint16     `json:"packetId"`
+		Properties Properties `json:"properties,omitempty"`
+		Topics     []string   `json:"topics"`
+	}{"UNSUBSCRIBE", packetIDValue(s.packetID), s.properties, topics})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/Den3/mammoth/auth"
+)
+
+// runPasswd adds, changes or deletes a user of a password file. The password is
+// read from the first line of stdin unless -b is given, so that it does not show
+// up in the process list.
+func runPasswd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("mammoth passwd", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mammoth passwd [flags] file user")
+		flags.PrintDefaults()
+	}
+	create := flags.Bool("c", false, "create the password file if it does not exist")
+	del := flags.Bool("D", false, "delete the user")
+	password := flags.String("b", "", "the password, instead of reading it from stdin")
+	iterations := flags.Int("iterations", auth.DefaultIterations, "PBKDF2 iterations of the hash")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	path, name := flags.Arg(0), flags.Arg(1)
+	if !auth.ValidUserName(name) {
+		fmt.Fprintf(stderr, "mammoth passwd: %q: %v\n", name, auth.ErrUserNameInvalid)
+		return 2
+	}
+
+	users, err := auth.ReadPasswordFile(path)
+	if errors.Is(err, fs.ErrNotExist) && *create && !*del {
+		users, err = map[string]string{}, nil
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "mammoth passwd:", err)
+		return 1
+	}
+
+	if *del {
+		if _, ok := users[name]; !ok {
+			fmt.Fprintf(stderr, "mammoth passwd: no user %q in %s\n", name, path)
+			return 1
+		}
+		delete(users, name)
+	} else {
+		p := *password
+		if !isFlagSet(flags, "b") {
+			if p, err = readPassword(stdin); err != nil {
+				fmt.Fprintln(stderr, "mammoth passwd:", err)
+				return 1
+			}
+		}
+		hash, err := auth.HashPassword(p, *iterations)
+		if err != nil {
+			fmt.Fprintln(stderr, "mammoth passwd:", err)
+			return 1
+		}
+		users[name] = hash
+	}
+
+	if err := auth.WritePasswordFile(path, users); err != nil {
+		fmt.Fprintln(stderr, "mammoth passwd:", err)
+		return 1
+	}
+	return 0
+}
+
+// readPassword reads a password from the first line of r
+func readPassword(r io.Reader) (string, error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("no password on stdin")
+	}
+	return strings.TrimRight(s.Text(), "\r"), nil
+}
+
+// isFlagSet reports whether the flag called name was given
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"github.com/Den3/mammoth/config"
+	"github.com/Den3/mammoth/server"
+)
+
+// reloader applies the configuration to a running broker again, keeping the
+// Clients connected
+type reloader struct {
+	flags  *serveFlags
+	server *server.Server
+	level  *slog.LevelVar
+	logger *slog.Logger
+
+	// certs are the certificates of the listeners, nil for the ones without TLS
+	certs []*server.TLS
+
+	mu sync.Mutex
+
+	// cfg is the configuration in effect
+	cfg *config.Config
+}
+
+// reload loads the configuration again and applies the settings which can change
+// while the broker runs: authentication, authorization, limits and log level. The
+// password, JWT key, ACL and TLS certificate files are read again even if their
+// names did not change. Changes to other settings are rejected and logged, and
+// returned as the paths of the settings, which keep their previous value. An
+// invalid configuration is rejected as a whole.
+func (r *reloader) reload() ([]string, error) {
+	cfg, err := r.flags.load()
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newAuthenticator(cfg, r.logger)
+	if err != nil {
+		return nil, err
+	}
+	acl, err := newAuthorizer(cfg, r.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rejected []string
+	if !reflect.DeepEqual(cfg.Listeners, r.cfg.Listeners) {
+		r.logger.Warn("listeners cannot change while the broker runs, keeping them until a restart")
+		cfg.Listeners = r.cfg.Listeners
+		rejected = append(rejected, "listeners")
+	}
+	if cfg.Log.Format != r.cfg.Log.Format {
+		r.logger.Warn("log.format cannot change while the broker runs, keeping it until a restart", "format", r.cfg.Log.Format)
+		cfg.Log.Format = r.cfg.Log.Format
+		rejected = append(rejected, "log.format")
+	}
+
+	// Every certificate is read before any is put in use, so that a failure
+	// leaves all the listeners with their previous one
+	configs := make([]*tls.Config, len(r.certs))
+	for i, t := range r.certs {
+		if t == nil {
+			continue
+		}
+		if configs[i], err = t.Load(); err != nil {
+			return nil, fmt.Errorf("listeners[%d]: %w", i, err)
+		}
+	}
+	for i, t := range r.certs {
+		if t != nil {
+			t.Store(configs[i])
+		}
+	}
+
+	r.server.SetAuthenticator(a)
+	r.server.SetAuthorizer(acl)
+	r.server.SetLimits(limitsOf(cfg))
+	r.level.Set(cfg.LogLevel())
+	r.cfg = cfg
+	r.logger.Info("configuration reloaded")
+	return rejected, nil
+}
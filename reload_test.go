@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/server"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mammoth.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	passwd := filepath.Join(filepath.Dir(path), "passwd")
+	setPassword := func(name string) {
+		hash, _ := auth.HashPassword("secret", 10)
+		if err := auth.WritePasswordFile(passwd, map[string]string{name: hash}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setPassword("alice")
+	write(`{
+		"listeners": [{"type": "tcp", "address": ":1883"}],
+		"auth": {"password_file": "` + passwd + `"},
+		"limits": {"max_connections": 10}
+	}`)
+
+	f := &serveFlags{config: path}
+	cfg, err := f.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	level := &slog.LevelVar{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := newServer(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &reloader{
+		flags:  f,
+		server: s,
+		level:  level,
+		logger: logger,
+		cfg:    cfg,
+	}
+
+	setPassword("bob")
+	write(`{
+		"listeners": [{"type": "tcp", "address": ":1884"}],
+		"auth": {"password_file": "` + passwd + `"},
+		"limits": {"max_connections": 20, "max_packet_size": 1024},
+		"log": {"level": "debug", "format": "json"}
+	}`)
+	rejected, err := r.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"listeners", "log.format"}; !reflect.DeepEqual(rejected, want) {
+		t.Errorf("reload() rejected %v, want %v", rejected, want)
+	}
+	if want := (server.Limits{MaxConnections: 20, MaxPacketSize: 1024}); s.MaxConnections != want.MaxConnections || s.MaxPacketSize != want.MaxPacketSize {
+		t.Errorf("limits = %d, %d, want %+v", s.MaxConnections, s.MaxPacketSize, want)
+	}
+	bob := &auth.Request{UserName: "bob", Password: []byte("secret"), HasUserName: true, HasPassword: true}
+	if _, err := s.Authenticator.Authenticate(context.Background(), bob); err != nil {
+		t.Errorf("Authenticate(bob) after reload error = %v", err)
+	}
+	if level.Level() != slog.LevelDebug {
+		t.Errorf("level = %v, want %v", level.Level(), slog.LevelDebug)
+	}
+	if r.cfg.Listeners[0].Address != ":1883" || r.cfg.Log.Format != "text" {
+		t.Errorf("rejected settings changed: %v", r.cfg)
+	}
+
+	write(`{"limits": {"max_connections": -1}}`)
+	if _, err := r.reload(); err == nil {
+		t.Error("reload() accepted an invalid configuration")
+	}
+	if s.MaxConnections != 20 {
+		t.Errorf("MaxConnections = %d after an invalid configuration, want 20", s.MaxConnections)
+	}
+}
+
+// writeTestCert writes a self-signed certificate for localhost and its key to
+// name.pem and name.key in dir
+func writeTestCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// currentConfig returns the tls.Config t serves
+func currentConfig(t *server.TLS) *tls.Config {
+	config, _ := t.Config().GetConfigForClient(nil)
+	return config
+}
+
+func TestReloadTLS(t *testing.T) {
+	dir := t.TempDir()
+	firstCert, firstKey := writeTestCert(t, dir, "first")
+	secondCert, secondKey := writeTestCert(t, dir, "second")
+	path := filepath.Join(dir, "mammoth.json")
+	data := `{"listeners": [
+		{"type": "tls", "address": ":8883", "tls": {"cert_file": "` + firstCert + `", "key_file": "` + firstKey + `"}},
+		{"type": "tls", "address": ":8884", "tls": {"cert_file": "` + secondCert + `", "key_file": "` + secondKey + `"}}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &serveFlags{config: path}
+	cfg, err := f.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := newServer(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := newTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &reloader{flags: f, server: s, certs: certs, level: &slog.LevelVar{}, logger: logger, cfg: cfg}
+
+	// A broken certificate of the second listener keeps the first one's too
+	before := currentConfig(certs[0])
+	os.WriteFile(secondCert, []byte("garbage"), 0600)
+	if _, err := r.reload(); err == nil {
+		t.Fatal("reload() of a broken certificate succeeded")
+	}
+	if currentConfig(certs[0]) != before {
+		t.Error("the certificate of listeners[0] changed after a failed reload()")
+	}
+
+	writeTestCert(t, dir, "second")
+	if _, err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if currentConfig(certs[0]) == before {
+		t.Error("the certificate of listeners[0] was not reloaded")
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/config"
+	"github.com/Den3/mammoth/server"
+)
+
+// serveFlags are the flags of the serve and check-config commands
+type serveFlags struct {
+	config string
+	addr   string
+}
+
+// parseServeFlags parses the flags of the serve and check-config commands. It
+// returns nil and the exit code if they are not valid or -h was given.
+func parseServeFlags(name string, args []string, stderr io.Writer) (*serveFlags, int) {
+	fs := flag.NewFlagSet("mammoth "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	f := &serveFlags{}
+	fs.StringVar(&f.config, "config", "", "configuration file")
+	fs.StringVar(&f.addr, "addr", "", "TCP address to listen on instead of the listeners of the configuration")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, 0
+		}
+		return nil, 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "mammoth %s: unexpected arguments %q\n", name, fs.Args())
+		return nil, 2
+	}
+	return f, 0
+}
+
+// load returns the configuration described by the flags: the -config file or the
+// default configuration, overridden by the environment and then by -addr
+func (f *serveFlags) load() (*config.Config, error) {
+	var cfg *config.Config
+	var err error
+	if f.config != "" {
+		cfg, err = config.Load(f.config)
+	} else {
+		cfg = config.Default()
+		err = cfg.ApplyEnv(os.LookupEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.addr != "" {
+		cfg.Listeners = []config.Listener{{Type: "tcp", Address: f.addr}}
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("-addr: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// loadConfig parses the flags of the serve and check-config commands and loads the
+// configuration. It returns nil and the exit code if either is not valid, or -h
+// was given.
+func loadConfig(name string, args []string, stderr io.Writer) (*serveFlags, *config.Config, int) {
+	f, code := parseServeFlags(name, args, stderr)
+	if f == nil {
+		return nil, nil, code
+	}
+	cfg, err := f.load()
+	if err != nil {
+		fmt.Fprintf(stderr, "mammoth %s: %v\n", name, err)
+		return nil, nil, 1
+	}
+	return f, cfg, 0
+}
+
+// newLogger returns a Logger writing to w in the format of cfg, at level
+func newLogger(cfg *config.Config, level slog.Leveler, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Log.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// limitsOf returns the limits of cfg
+func limitsOf(cfg *config.Config) server.Limits {
+	return server.Limits{
+		MaxConnections: cfg.Limits.MaxConnections,
+		MaxPacketSize:  cfg.Limits.MaxPacketSize,
+		QueueSize:      cfg.Limits.QueueSize,
+	}
+}
+
+// newAuthenticator returns the Authenticator configured by cfg
+func newAuthenticator(cfg *config.Config, logger *slog.Logger) (auth.Authenticator, error) {
+	a, err := newPasswordAuthenticator(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Auth.CertUserName != "" || cfg.Auth.CertClientID {
+		a = &auth.Certificate{
+			UserName:      auth.CertificateField(cfg.Auth.CertUserName),
+			MatchClientID: cfg.Auth.CertClientID,
+			Next:          a,
+		}
+	}
+	if cfg.Auth.UnixPeerUID {
+		a = &auth.PeerUID{Next: a}
+	}
+	return a, nil
+}
+
+// newPasswordAuthenticator returns the Authenticator of the Clients without
+// certificate or unix socket credentials configured by cfg
+func newPasswordAuthenticator(cfg *config.Config, logger *slog.Logger) (auth.Authenticator, error) {
+	if cfg.Auth.JWTKeysFile != "" {
+		keys, err := auth.LoadJWKS(cfg.Auth.JWTKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		return &auth.JWT{
+			Keys:          keys,
+			Audience:      cfg.Auth.JWTAudience,
+			Issuer:        cfg.Auth.JWTIssuer,
+			UserNameClaim: cfg.Auth.JWTUserNameClaim,
+			Next:          &auth.Static{AllowAnonymous: cfg.Auth.AllowAnonymous},
+		}, nil
+	}
+	if cfg.Auth.WebhookURL != "" {
+		return newWebhook(cfg, cfg.Auth.WebhookURL, logger), nil
+	}
+	if cfg.Auth.PasswordFile == "" {
+		if !cfg.Auth.AllowAnonymous {
+			// Only Clients with a certificate or over a unix socket are accepted
+			return &auth.Static{}, nil
+		}
+		return auth.AllowAll{}, nil
+	}
+	a, err := auth.NewPasswordFile(cfg.Auth.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	a.AllowAnonymous = cfg.Auth.AllowAnonymous
+	a.OnError = func(err error) {
+		logger.Error("password file not reloaded", "err", err)
+	}
+	return a, nil
+}
+
+// newWebhook returns a Webhook asking the endpoint at url as configured by cfg
+func newWebhook(cfg *config.Config, url string, logger *slog.Logger) *auth.Webhook {
+	return &auth.Webhook{
+		URL:            url,
+		Timeout:        config.Duration(cfg.Auth.WebhookTimeout),
+		CacheTTL:       config.Duration(cfg.Auth.WebhookCacheTTL),
+		FailOpen:       cfg.Auth.WebhookFailOpen,
+		AllowAnonymous: cfg.Auth.AllowAnonymous,
+		OnError: func(err error) {
+			logger.Error("webhook failed, allowing the request", "err", err)
+		},
+	}
+}
+
+// newAuthorizer returns the Authorizer configured by cfg
+func newAuthorizer(cfg *config.Config, logger *slog.Logger) (auth.Authorizer, error) {
+	if cfg.Auth.WebhookACLURL != "" {
+		return newWebhook(cfg, cfg.Auth.WebhookACLURL, logger), nil
+	}
+	if cfg.Auth.ACLFile == "" {
+		return auth.AllowAll{}, nil
+	}
+	return auth.LoadACL(cfg.Auth.ACLFile)
+}
+
+// newServer returns a Server configured by cfg
+func newServer(cfg *config.Config, logger *slog.Logger) (*server.Server, error) {
+	a, err := newAuthenticator(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	acl, err := newAuthorizer(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	s := &server.Server{Logger: logger, Authenticator: a, Authorizer: acl}
+	s.SetLimits(limitsOf(cfg))
+	return s, nil
+}
+
+// newTLS returns the certificates of every listener of cfg, nil for the
+// listeners without TLS
+func newTLS(cfg *config.Config) ([]*server.TLS, error) {
+	certs := make([]*server.TLS, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		if l.TLS == nil {
+			continue
+		}
+		t, err := server.NewTLS(server.TLSOptions{
+			CertFile:     l.TLS.CertFile,
+			KeyFile:      l.TLS.KeyFile,
+			MinVersion:   l.TLS.Version(),
+			CipherSuites: l.TLS.CipherSuiteIDs(),
+			ClientAuth:   l.TLS.ClientAuthType(),
+			ClientCAFile: l.TLS.ClientCAFile,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listeners[%d]: %w", i, err)
+		}
+		certs[i] = t
+	}
+	return certs, nil
+}
+
+// listen listens on the address of l, expecting PROXY protocol headers if l asks
+// for them
+func listen(l config.Listener) (net.Listener, error) {
+	ln, err := listenNetwork(l)
+	if err != nil || !l.ProxyProtocol {
+		return ln, err
+	}
+	return &server.ProxyListener{Listener: ln, Trusted: l.TrustedProxies()}, nil
+}
+
+// listenNetwork listens on the address of l. A unix socket left by a broker which
+// did not stop cleanly is replaced, and a new one has the permissions of Mode
+// from the start.
+func listenNetwork(l config.Listener) (net.Listener, error) {
+	if l.Network() != "unix" {
+		return net.Listen("tcp", l.Address)
+	}
+	if fi, err := os.Stat(l.Address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", l.Address); err == nil {
+			c.Close()
+		} else {
+			os.Remove(l.Address)
+		}
+	}
+	return listenUnix(l.Address, l.FileMode())
+}
+
+// serveListener serves s on ln as l describes, over TLS with cert if it is not
+// nil
+func serveListener(s *server.Server, l config.Listener, ln net.Listener, cert *server.TLS) error {
+	var tlsConfig *tls.Config
+	if cert != nil {
+		tlsConfig = cert.Config()
+	}
+	switch {
+	case l.WebSocket():
+		return s.ServeWebSocket(ln, l.Path, tlsConfig)
+	case tlsConfig != nil:
+		return s.ServeTLS(ln, tlsConfig)
+	}
+	return s.Serve(ln)
+}
+
+// runServe runs the broker until ctx is done. SIGHUP reloads the configuration.
+func runServe(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	f, cfg, code := loadConfig("serve", args, stderr)
+	if cfg == nil {
+		return code
+	}
+	fmt.Fprintf(stderr, "effective configuration:\n%s\n", cfg)
+
+	level := &slog.LevelVar{}
+	level.Set(cfg.LogLevel())
+	logger := newLogger(cfg, level, stderr)
+	s, err := newServer(cfg, logger)
+	if err != nil {
+		fmt.Fprintln(stderr, "mammoth serve:", err)
+		return 1
+	}
+	certs, err := newTLS(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, "mammoth serve:", err)
+		return 1
+	}
+
+	var listeners []net.Listener
+	for _, l := range cfg.Listeners {
+		ln, err := listen(l)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			fmt.Fprintln(stderr, "mammoth serve:", err)
+			return 1
+		}
+		logger.Info("listening", "type", l.Type, "addr", ln.Addr().String())
+		listeners = append(listeners, ln)
+	}
+
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
+
+	r := &reloader{flags: f, server: s, certs: certs, level: level, logger: logger, cfg: cfg}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if _, err := r.reload(); err != nil {
+					logger.Error("reload failed, keeping the configuration", "err", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// The first listener failing stops the others
+	errs := make(chan error, len(listeners))
+	for i, ln := range listeners {
+		go func(l config.Listener, ln net.Listener, cert *server.TLS) {
+			errs <- serveListener(s, l, ln, cert)
+		}(cfg.Listeners[i], ln, certs[i])
+	}
+	code = 0
+	for range listeners {
+		err := <-errs
+		if !errors.Is(err, server.ErrServerClosed) {
+			fmt.Fprintln(stderr, "mammoth serve:", err)
+			code = 1
+			s.Close()
+		}
+	}
+	logger.Info("stopped")
+	return code
+}
+
+func runCheckConfig(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	_, cfg, code := loadConfig("check-config", args, stderr)
+	if cfg == nil {
+		return code
+	}
+	if _, err := newServer(cfg, slog.Default()); err != nil {
+		fmt.Fprintln(stderr, "mammoth check-config:", err)
+		return 1
+	}
+	if _, err := newTLS(cfg); err != nil {
+		fmt.Fprintln(stderr, "mammoth check-config:", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, cfg)
+	return 0
+}
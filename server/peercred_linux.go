@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/Den3/mammoth/auth"
+)
+
+// peerCred returns the credentials of the process at the other end of the unix
+// socket c runs over, nil if c is not a unix socket. Connections accepted by a
+// ProxyListener have none either, as that process is the proxy and not the Client.
+func peerCred(c net.Conn) *auth.PeerCred {
+	for {
+		if _, ok := c.(*proxyConn); ok {
+			return nil
+		}
+		cc, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = cc.NetConn()
+	}
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return nil
+	}
+	return &auth.PeerCred{PID: int(cred.Pid), UID: int(cred.Uid), GID: int(cred.Gid)}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/message"
+)
+
+func TestPeerUID(t *testing.T) {
+	acl, err := auth.ParseACL("acl", strings.NewReader("all: allow subscribe agents/%u/#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "mammoth.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&Server{Authenticator: &auth.PeerUID{Next: &auth.Static{}}, Authorizer: acl}).Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The User Name of CONNECT is ignored for the UID of the process
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("agent"))
+	connect.SetUserName([]byte("mallory"))
+	message.WritePacket(c, connect)
+	expectPacket(t, c, message.Version311, "CONNACK(sp=0, rc=0x00)")
+
+	sub := message.NewSubscribeMessage()
+	sub.SetPacketID([]byte{0, 1})
+	sub.Add([]byte(fmt.Sprintf("agents/%d/#", os.Getuid())), 0)
+	sub.Add([]byte("agents/mallory/#"), 0)
+	message.WritePacket(c, sub)
+	expectPacket(t, c, message.Version311, "SUBACK(id=1, [0x00 0x80])")
+
+	// Clients over TCP are left to Next
+	addr := startServer(t, &Server{Authenticator: &auth.PeerUID{Next: &auth.Static{}}})
+	_, connack := dialConnect(t, addr, connect)
+	if rc := connack.ConnectReturnCode(); rc != 0x04 {
+		t.Errorf("CONNACK return code over TCP = %#x, want 0x04", rc)
+	}
+
+	// Clients behind a proxy connected over a unix socket are left to Next, the
+	// UID is the proxy's
+	proxied := filepath.Join(t.TempDir(), "proxied.sock")
+	pln, err := net.Listen("unix", proxied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&Server{Authenticator: &auth.PeerUID{Next: &auth.Static{}}}).Serve(&ProxyListener{Listener: pln})
+	t.Cleanup(func() { pln.Close() })
+	pc, err := net.Dial("unix", proxied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+	pc.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 51234 1883\r\n"))
+	message.WritePacket(pc, connect)
+	expectPacket(t, pc, message.Version311, "CONNACK(sp=0, rc=0x04)")
+}
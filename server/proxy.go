@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout bounds reading the PROXY protocol header of a
+// connection accepted by a ProxyListener without Timeout
+const DefaultProxyHeaderTimeout = 5 * time.Second
+
+// maxProxyV1Header is the length of the longest PROXY protocol version 1 header,
+// CRLF included
+const maxProxyV1Header = 107
+
+// proxyV2Signature starts a PROXY protocol version 2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var (
+	// ErrProxyHeader indicates a connection accepted by a ProxyListener which does
+	// not start with a valid PROXY protocol header
+	ErrProxyHeader = errors.New("invalid PROXY protocol header")
+
+	// ErrProxyUntrusted indicates a connection accepted by a ProxyListener from an
+	// address not in Trusted
+	ErrProxyUntrusted = errors.New("PROXY protocol upstream not trusted")
+)
+
+// ProxyListener accepts connections from a proxy or load balancer starting with a
+// PROXY protocol header, version 1 or 2, as sent by HAProxy. The RemoteAddr and
+// LocalAddr of the connections are the addresses of the header, so that the
+// Server authenticates, authorizes and logs the Clients by their own address.
+//
+// The header is read when the connection is first read or asked for its
+// addresses. Connections without a valid header fail with ErrProxyHeader, the
+// ones from an untrusted upstream with ErrProxyUntrusted. Headers telling the
+// connection is the proxy's own, such as a health check, keep the addresses of
+// the connection.
+type ProxyListener struct {
+	net.Listener
+
+	// Trusted are the networks of the upstreams allowed to connect. Empty trusts
+	// every upstream.
+	Trusted []netip.Prefix
+
+	// Timeout bounds reading the header. Zero means DefaultProxyHeaderTimeout.
+	Timeout time.Duration
+}
+
+// Accept implements net.Listener
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, l: l, r: bufio.NewReader(c)}, nil
+}
+
+// trusts reports whether the upstream at addr may connect
+func (l *ProxyListener) trusts(addr net.Addr) bool {
+	if len(l.Trusted) == 0 {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, p := range l.Trusted {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a connection accepted by a ProxyListener
+type proxyConn struct {
+	net.Conn
+	l *ProxyListener
+	r *bufio.Reader
+
+	// once reads the header, which sets err, or remote and local unless the
+	// addresses of the connection are kept
+	once          sync.Once
+	err           error
+	remote, local net.Addr
+
+	// readDeadline is the deadline set by the user of the connection, restored
+	// after the header is read
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// NetConn returns the connection from the upstream
+func (c *proxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the source address of the header
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the header
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readHeader reads the header within the timeout of the listener, or the read
+// deadline of the connection if sooner
+func (c *proxyConn) readHeader() {
+	if !c.l.trusts(c.Conn.RemoteAddr()) {
+		c.err = fmt.Errorf("%w: %s", ErrProxyUntrusted, c.Conn.RemoteAddr())
+		return
+	}
+	timeout := c.l.Timeout
+	if timeout <= 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	c.mu.Lock()
+	if d := c.readDeadline; !d.IsZero() && d.Before(deadline) {
+		deadline = d
+	}
+	c.mu.Unlock()
+	c.Conn.SetReadDeadline(deadline)
+
+	c.remote, c.local, c.err = readProxyHeader(c.r)
+
+	c.mu.Lock()
+	c.Conn.SetReadDeadline(c.readDeadline)
+	c.mu.Unlock()
+}
+
+// readProxyHeader reads a PROXY protocol header of version 1 or 2 and returns its
+// source and destination addresses, nil if the connection is the proxy's own
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b[0] == proxyV2Signature[0] {
+		return readProxyV2(r)
+	}
+	return readProxyV1(r)
+}
+
+// readProxyV1 reads a header of version 1, a line of text:
+//
+//	PROXY TCP4 192.0.2.1 198.51.100.1 51234 1883\r\n
+func readProxyV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	b, err := r.Peek(6)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(b) != "PROXY " {
+		return nil, nil, fmt.Errorf("%w: no PROXY", ErrProxyHeader)
+	}
+
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxProxyV1Header {
+			return nil, nil, fmt.Errorf("%w: line too long", ErrProxyHeader)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown protocol %q", ErrProxyHeader, fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf("%w: %d fields", ErrProxyHeader, len(fields))
+	}
+	addr := func(ip, port string) (net.Addr, error) {
+		a, err := netip.ParseAddr(ip)
+		if err != nil || a.Is4() != (fields[1] == "TCP4") || a.Zone() != "" {
+			return nil, fmt.Errorf("%w: invalid %s address %q", ErrProxyHeader, fields[1], ip)
+		}
+		// Ports are written without leading zeros
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || strconv.FormatUint(p, 10) != port {
+			return nil, fmt.Errorf("%w: invalid port %q", ErrProxyHeader, port)
+		}
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, uint16(p))), nil
+	}
+	if src, err = addr(fields[2], fields[4]); err != nil {
+		return nil, nil, err
+	}
+	if dst, err = addr(fields[3], fields[5]); err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+// readProxyV2 reads a header of version 2: the signature, the version and command,
+// the address family and protocol, the length of the addresses and TLVs, the
+// addresses and the TLVs, which are skipped.
+func readProxyV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var h [16]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(h[:12], proxyV2Signature) {
+		return nil, nil, fmt.Errorf("%w: invalid signature", ErrProxyHeader)
+	}
+	if h[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: version %d", ErrProxyHeader, h[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(h[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	switch h[12] & 0x0f {
+	case 0x0:
+		// LOCAL: the connection was established by the proxy itself
+		return nil, nil, nil
+	case 0x1:
+		// PROXY
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown command %#x", ErrProxyHeader, h[12]&0x0f)
+	}
+
+	// The address family is the high 4 bits, the transport protocol the low ones
+	var size int
+	switch h[13] >> 4 {
+	case 0x0:
+		// AF_UNSPEC: the addresses are unknown
+		return nil, nil, nil
+	case 0x1:
+		size = 4
+	case 0x2:
+		size = 16
+	case 0x3:
+		// AF_UNIX: two paths of 108 bytes padded with zeros
+		if len(body) < 216 {
+			return nil, nil, fmt.Errorf("%w: short unix addresses", ErrProxyHeader)
+		}
+		path := func(b []byte) net.Addr {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[:i]
+			}
+			return &net.UnixAddr{Name: string(b), Net: "unix"}
+		}
+		return path(body[:108]), path(body[108:216]), nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown address family %#x", ErrProxyHeader, h[13]>>4)
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: short addresses", ErrProxyHeader)
+	}
+	addr := func(ip []byte, port []byte) net.Addr {
+		a, _ := netip.AddrFromSlice(ip)
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, binary.BigEndian.Uint16(port)))
+	}
+	src = addr(body[:size], body[2*size:2*size+2])
+	dst = addr(body[size:2*size], body[2*size+2:2*size+4])
+	return src, dst, nil
+}
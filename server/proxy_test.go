@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/message"
+)
+
+// proxyV2 returns a PROXY protocol version 2 header of command and family
+// carrying addrs
+func proxyV2(command, family byte, addrs []byte) string {
+	h := append([]byte{}, proxyV2Signature...)
+	h = append(h, 0x20|command, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(addrs)))
+	return string(append(h, addrs...))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	unixAddrs := make([]byte, 216)
+	copy(unixAddrs, "/run/client.sock")
+	copy(unixAddrs[108:], "/run/mammoth.sock")
+	ipv6 := append(netip.MustParseAddr("2001:db8::1").AsSlice(), netip.MustParseAddr("2001:db8::2").AsSlice()...)
+
+	tests := []struct {
+		header   string
+		src, dst string
+		err      error
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 51234 1883\r\n", "192.0.2.1:51234", "198.51.100.1:1883", nil},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 51234 1883\r\n", "[2001:db8::1]:51234", "[2001:db8::2]:1883", nil},
+		{"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", "", "", nil},
+		{proxyV2(1, 0x11, []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xc8, 0x22, 0x07, 0x5b}), "192.0.2.1:51234", "198.51.100.1:1883", nil},
+		{proxyV2(1, 0x21, append(ipv6, 0xc8, 0x22, 0x07, 0x5b, 0x01, 0x00, 0x00)), "[2001:db8::1]:51234", "[2001:db8::2]:1883", nil},
+		{proxyV2(1, 0x31, unixAddrs), "/run/client.sock", "/run/mammoth.sock", nil},
+		{proxyV2(0, 0x00, nil), "", "", nil},
+		{"PROXY TCP4 2001:db8::1 198.51.100.1 51234 1883\r\n", "", "", ErrProxyHeader},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 051234 1883\r\n", "", "", ErrProxyHeader},
+		{"PROXY UDP4 192.0.2.1 198.51.100.1 51234 1883\r\n", "", "", ErrProxyHeader},
+		{"PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n", "", "", ErrProxyHeader},
+		{"\x10\x0c\x00\x04MQTT\x04\x02\x00\x3c", "", "", ErrProxyHeader},
+		{proxyV2(1, 0x11, []byte{192, 0, 2, 1}), "", "", ErrProxyHeader},
+		{proxyV2(2, 0x11, nil), "", "", ErrProxyHeader},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.header + "MQTT"))
+		src, dst, err := readProxyHeader(r)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("readProxyHeader(%q) error = %v, want %v", tt.header, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := addrString(src); got != tt.src {
+			t.Errorf("readProxyHeader(%q) source = %s, want %s", tt.header, got, tt.src)
+		}
+		if got := addrString(dst); got != tt.dst {
+			t.Errorf("readProxyHeader(%q) destination = %s, want %s", tt.header, got, tt.dst)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "MQTT" {
+			t.Errorf("readProxyHeader(%q) left %q, want MQTT", tt.header, rest)
+		}
+	}
+}
+
+// addrString returns addr as a string, empty if it is nil
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func TestProxyListener(t *testing.T) {
+	acl, err := auth.ParseACL("acl", strings.NewReader("addr 192.0.2.0/24: allow subscribe plant/#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(trusted string) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pl := &ProxyListener{Listener: ln, Trusted: []netip.Prefix{netip.MustParsePrefix(trusted)}}
+		go (&Server{Authorizer: acl}).Serve(pl)
+		t.Cleanup(func() { ln.Close() })
+		return ln.Addr().String()
+	}
+	dial := func(addr, header string) net.Conn {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(c, header)
+		connect := message.NewConnectMessage()
+		connect.SetClientId([]byte("sensor"))
+		message.WritePacket(c, connect)
+		return c
+	}
+
+	// The ACL knows the Client by the address of the header
+	addr := serve("127.0.0.0/8")
+	c := dial(addr, "PROXY TCP4 192.0.2.7 127.0.0.1 40000 1883\r\n")
+	expectPacket(t, c, message.Version311, "CONNACK(sp=0, rc=0x00)")
+	sub := message.NewSubscribeMessage()
+	sub.SetPacketID([]byte{0, 1})
+	sub.Add([]byte("plant/#"), 0)
+	message.WritePacket(c, sub)
+	expectPacket(t, c, message.Version311, "SUBACK(id=1, [0x00])")
+
+	c = dial(addr, proxyV2(1, 0x11, []byte{198, 51, 100, 7, 127, 0, 0, 1, 0x9c, 0x40, 0x07, 0x5b}))
+	expectPacket(t, c, message.Version311, "CONNACK(sp=0, rc=0x00)")
+	message.WritePacket(c, sub)
+	expectPacket(t, c, message.Version311, "SUBACK(id=1, [0x80])")
+
+	// Connections without header, or from an untrusted upstream, are closed
+	c = dial(addr, "")
+	if m, err := message.ReadPacket(c, message.Version311); err == nil {
+		t.Errorf("received %v without header", m)
+	}
+	c = dial(serve("10.0.0.0/8"), "PROXY TCP4 192.0.2.7 127.0.0.1 40000 1883\r\n")
+	if m, err := message.ReadPacket(c, message.Version311); err == nil {
+		t.Errorf("received %v from an untrusted upstream", m)
+	}
+}
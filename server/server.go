@@ -1,10 +1,20 @@
 package server
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Den3/mammoth/auth"
 	"github.com/Den3/mammoth/message"
+	"github.com/Den3/mammoth/topic"
 )
 
 const (
@@ -13,38 +23,569 @@ const (
 
 	// AcceptInterval is connection acception interval in micro second
 	AcceptInterval = 10
+
+	// DefaultConnectTimeout bounds the time a Client has to send CONNECT, TLS and
+	// WebSocket handshakes included, for a Server without ConnectTimeout
+	DefaultConnectTimeout = 10 * time.Second
+
+	// DefaultWriteTimeout bounds writing a Control Packet to a Client for a Server
+	// without WriteTimeout
+	DefaultWriteTimeout = 30 * time.Second
+)
+
+var (
+	// ErrFirstPacketNotConnect indicates the Client sent something else than CONNECT first
+	ErrFirstPacketNotConnect = errors.New("first packet is not CONNECT")
+
+	// ErrConnectionRefused indicates the Server answered CONNECT with a non-zero return code
+	ErrConnectionRefused = errors.New("connection refused")
+
+	// ErrSecondConnect indicates the Client sent CONNECT again on the same Network Connection
+	ErrSecondConnect = errors.New("second CONNECT")
+
+	// ErrServerClosed is returned by Serve after Close was called
+	ErrServerClosed = errors.New("server closed")
 )
 
-// Server is listening on port 1883 only
-type Server struct{}
+// Limits are the settings of a Server which SetLimits changes while it runs
+type Limits struct {
+	MaxConnections int
+	MaxPacketSize  int
+	QueueSize      int
+}
+
+// Server is an MQTT Server. The zero value is ready to use. Its exported fields
+// must not be changed once Serve was called, other than through SetLimits,
+// SetAuthenticator and SetAuthorizer.
+type Server struct {
+	// MaxConnections is the number of Network Connections the Server accepts at a
+	// time. Clients connecting beyond it are answered with CONNACK return code 0x03
+	// (Server unavailable). Zero means no limit.
+	MaxConnections int
+
+	// MaxPacketSize is the size in bytes of the largest Control Packet the Server
+	// accepts. A Client sending a larger one is disconnected. Zero means no limit.
+	MaxPacketSize int
+
+	// QueueSize is the number of packets waiting to be written to a Client. QoS 0
+	// messages to a Client whose queue is full are dropped, a Client whose queue
+	// is full when sent a QoS 1 or QoS 2 message is disconnected. Zero means
+	// DefaultQueueSize.
+	QueueSize int
+
+	// ConnectTimeout is the time a Client has to send CONNECT once its Network
+	// Connection is accepted. Zero means DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+
+	// WriteTimeout is the time a Client has to take a Control Packet. A Client
+	// which does not read is disconnected. Zero means DefaultWriteTimeout.
+	WriteTimeout time.Duration
+
+	// Logger logs connection errors. Nil means slog.Default().
+	Logger *slog.Logger
+
+	// Authenticator decides whether a Client may connect. Nil means auth.AllowAll.
+	Authenticator auth.Authenticator
+
+	// Authorizer decides whether a Client may publish to a Topic Name or subscribe
+	// to a Topic Filter. Nil means auth.AllowAll.
+	Authorizer auth.Authorizer
+
+	// clientIds counts ClientIds assigned to Clients which sent a zero-byte ClientId
+	clientIds uint64
+
+	// liveMu guards the settings changed while the Server runs: MaxConnections,
+	// MaxPacketSize, QueueSize, Authenticator and Authorizer
+	liveMu sync.RWMutex
+
+	// handlers counts the running handleConn calls
+	handlers sync.WaitGroup
+
+	initOnce sync.Once
+
+	// ctx is passed to the Authenticator and Authorizer, and cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// topics holds the Subscriptions of every connected session
+	topics *topic.Tree
+
+	mu        sync.Mutex
+	sessions  map[string]*session
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// init prepares the zero value of Server for use
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		s.topics = topic.NewTree()
+		s.sessions = map[string]*session{}
+		s.listeners = map[net.Listener]struct{}{}
+		s.conns = map[net.Conn]struct{}{}
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	})
+}
+
+// SetLimits changes MaxConnections, MaxPacketSize and QueueSize while the Server
+// runs. The limits apply to the Network Connections accepted afterwards, Clients
+// already connected keep the Maximum Packet Size and queue they were given.
+func (s *Server) SetLimits(l Limits) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	s.MaxConnections = l.MaxConnections
+	s.MaxPacketSize = l.MaxPacketSize
+	s.QueueSize = l.QueueSize
+}
+
+// SetAuthenticator changes the Authenticator while the Server runs. Clients
+// already connected stay connected.
+func (s *Server) SetAuthenticator(a auth.Authenticator) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	s.Authenticator = a
+}
+
+// SetAuthorizer changes the Authorizer while the Server runs. It applies to the
+// packets received afterwards, existing Subscriptions are kept.
+func (s *Server) SetAuthorizer(a auth.Authorizer) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	s.Authorizer = a
+}
+
+// authorizer returns the current Authorizer
+func (s *Server) authorizer() auth.Authorizer {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	if s.Authorizer == nil {
+		return auth.AllowAll{}
+	}
+	return s.Authorizer
+}
+
+// limits returns the current limits
+func (s *Server) limits() Limits {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	return Limits{
+		MaxConnections: s.MaxConnections,
+		MaxPacketSize:  s.MaxPacketSize,
+		QueueSize:      s.QueueSize,
+	}
+}
+
+// logger returns the Logger of the Server
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
 
-// handleConn judges its MQTT type
+// handleConn judges its MQTT type. c was added to s.conns by Serve.
 func (s *Server) handleConn(c net.Conn) {
-	m := message.NewConnackMessage()
-	buf := make([]byte, 3)
-	m.Encode(buf)
-	_, err := c.Write(buf)
+	defer c.Close()
+
+	l := s.limits()
+	s.mu.Lock()
+	full := l.MaxConnections > 0 && len(s.conns) > l.MaxConnections
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	// The deadline bounds the handshakes of the connection too, as they are run
+	// by its first read
+	c.SetDeadline(time.Now().Add(s.connectTimeout()))
+	connect, id, err := s.connect(c, l, full)
 	if err != nil {
-		log.Fatal(err)
+		s.logger().Info("connect failed", "remote", c.RemoteAddr().String(), "err", err)
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	ss := newSession(s, c, connect, id, l)
+	s.register(ss)
+	defer s.unregister(ss)
+
+	err = ss.serve()
+	if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+		s.logger().Info("connection lost", "client", ss.clientId, "remote", c.RemoteAddr().String(), "err", err)
+	}
+}
+
+// connectTimeout returns ConnectTimeout or its default
+func (s *Server) connectTimeout() time.Duration {
+	if s.ConnectTimeout > 0 {
+		return s.ConnectTimeout
+	}
+	return DefaultConnectTimeout
+}
+
+// writeTimeout returns WriteTimeout or its default
+func (s *Server) writeTimeout() time.Duration {
+	if s.WriteTimeout > 0 {
+		return s.WriteTimeout
+	}
+	return DefaultWriteTimeout
+}
+
+// register adds a session. If the ClientId represents a Client already connected to
+// the Server then the Server MUST disconnect the existing Client [MQTT-3.1.4-2].
+func (s *Server) register(ss *session) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ss.close()
+		return
+	}
+	old := s.sessions[ss.clientId]
+	s.sessions[ss.clientId] = ss
+	s.mu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+}
+
+// unregister removes a session and its Subscriptions
+func (s *Server) unregister(ss *session) {
+	s.mu.Lock()
+	if s.sessions[ss.clientId] == ss {
+		delete(s.sessions, ss.clientId)
+	}
+	s.mu.Unlock()
+
+	ss.close()
+	for _, f := range ss.subscriptions() {
+		s.topics.Unsubscribe([]byte(f), ss)
+	}
+	ss.releaseInflight()
+}
+
+// publish sends p to every session subscribed to its Topic Name. buf holds the
+// bytes p refers to and gets one more reference for every session p is queued to.
+func (s *Server) publish(p *message.PublishMessage, buf *message.Buffer) {
+	subs := s.topics.Match(p.TopicName(), nil)
+
+	// QoS 0 messages are the same for every subscriber of a Protocol Level, so they
+	// are shared instead of being built for each of them
+	var shared [message.Version5 + 1]*message.PublishMessage
+	for _, sub := range subs {
+		ss := sub.Subscriber.(*session)
+		qos := p.QoS()
+		if sub.QoS < qos {
+			qos = sub.QoS
+		}
+
+		if qos == 0 {
+			m := shared[ss.version]
+			if m == nil {
+				m = outgoing(p, ss.version, 0)
+				shared[ss.version] = m
+			}
+			ss.deliver(m, buf)
+			continue
+		}
+		ss.deliver(outgoing(p, ss.version, qos), buf)
+	}
+}
+
+// outgoing returns a copy of p to be sent to a subscriber. The copy shares Topic Name
+// and Payload with p.
+//
+// The RETAIN flag MUST be set to 0 when a PUBLISH Packet is sent to a Client because
+// it matches an established subscription [MQTT-3.3.1-9], and DUP is set independently
+// from the incoming PUBLISH Packet [MQTT-3.3.1-3].
+func outgoing(p *message.PublishMessage, version byte, qos byte) *message.PublishMessage {
+	m := *p
+	m.SetVersion(version)
+	m.SetRetain(false)
+	m.SetDup(false)
+	m.SetQoS(qos)
+	m.SetPacketID(nil)
+
+	// A Topic Alias only has a meaning on the Network Connection it was sent on
+	if _, ok := p.Properties().Get(message.TopicAlias); ok {
+		var props message.Properties
+		for _, prop := range p.Properties() {
+			if prop.ID != message.TopicAlias {
+				props = append(props, prop)
+			}
+		}
+		m.SetProperties(props)
 	}
+	return &m
+}
+
+// connect reads the CONNECT Packet, authenticates the Client and answers with a
+// CONNACK Packet. full tells that the Server has no room for another Network
+// Connection.
+func (s *Server) connect(c net.Conn, l Limits, full bool) (*message.ConnectMessage, *auth.Identity, error) {
+	m, err := message.ReadPacketLimit(c, message.Version311, l.MaxPacketSize)
+	if err == message.ErrProtocolLevelInvalid {
+		// The Server MUST respond to the CONNECT Packet with a CONNACK return code
+		// 0x01 (unacceptable protocol level) and then disconnect the Client if the
+		// Protocol Level is not supported by the Server [MQTT-3.1.2-2]
+		connack := message.NewConnackMessage()
+		connack.SetConnectReturnCode(0x01)
+		if werr := message.WritePacket(c, connack); werr != nil {
+			return nil, nil, werr
+		}
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connect, ok := m.(*message.ConnectMessage)
+	if !ok {
+		return nil, nil, ErrFirstPacketNotConnect
+	}
+
+	connack := message.NewConnackMessage()
+	connack.SetVersion(connect.Version())
+
+	if err := connect.ValidateClientId(); err != nil {
+		return nil, nil, refuse(c, connack, 0x02)
+	}
+	if full {
+		return nil, nil, refuse(c, connack, 0x03)
+	}
+
+	var assigned []byte
+	if len(connect.ClientId()) == 0 {
+		assigned = s.assignClientId()
+		connect.SetClientId(assigned)
+	}
+
+	id, err := s.authenticate(c, connect)
+	if err == nil && id == nil {
+		err = auth.ErrNotAuthorized
+	}
+	if err == nil && id.RemoteAddr == nil {
+		id.RemoteAddr = c.RemoteAddr()
+	}
+	if errors.Is(err, auth.ErrBadCredentials) {
+		return nil, nil, refuse(c, connack, 0x04)
+	}
+	if errors.Is(err, auth.ErrNotAuthorized) {
+		return nil, nil, refuse(c, connack, 0x05)
+	}
+	if err != nil {
+		s.logger().Error("authentication failed", "client", string(connect.ClientId()), "remote", c.RemoteAddr().String(), "err", err)
+		return nil, nil, refuse(c, connack, 0x03)
+	}
+
+	props := message.Properties{}
+	if assigned != nil {
+		props.AddData(message.AssignedClientIdentifier, assigned)
+	}
+	if l.MaxPacketSize > 0 {
+		props.AddValue(message.MaximumPacketSize, uint32(l.MaxPacketSize))
+	}
+	if connect.Version() == message.Version5 && len(props) > 0 {
+		connack.SetProperties(props)
+	}
+
+	if err := message.WritePacket(c, connack); err != nil {
+		return nil, nil, err
+	}
+	return connect, id, nil
+}
+
+// authenticate asks the Authenticator whether the Client of connect may connect
+func (s *Server) authenticate(c net.Conn, connect *message.ConnectMessage) (*auth.Identity, error) {
+	s.liveMu.RLock()
+	a := s.Authenticator
+	s.liveMu.RUnlock()
+	if a == nil {
+		a = auth.AllowAll{}
+	}
+	r := &auth.Request{
+		ClientID:    string(connect.ClientId()),
+		UserName:    string(connect.UserName()),
+		Password:    connect.Password(),
+		HasUserName: connect.UserNameFlag() == 1,
+		HasPassword: connect.PasswordFlag() == 1,
+		RemoteAddr:  c.RemoteAddr(),
+	}
+	r.TLS = tlsState(c)
+	r.Peer = peerCred(c)
+	return a.Authenticate(s.ctx, r)
+}
+
+// tlsState returns the state of the TLS connection c runs over, nil if there is
+// none. The handshake completed when CONNECT was read.
+func tlsState(c net.Conn) *tls.ConnectionState {
+	for {
+		switch cc := c.(type) {
+		case *tls.Conn:
+			state := cc.ConnectionState()
+			return &state
+		case interface{ NetConn() net.Conn }:
+			c = cc.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+// netConn returns the connection c runs over, unwrapping TLS and WebSocket
+func netConn(c net.Conn) net.Conn {
+	for {
+		cc, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return c
+		}
+		c = cc.NetConn()
+	}
+}
+
+// refuse answers CONNECT with connack carrying the 3.1.1 Connect Return code code,
+// converted for the version of connack, and returns ErrConnectionRefused
+func refuse(c net.Conn, connack *message.ConnackMessage, code byte) error {
+	connack.SetConnectReturnCode(connackCode(connack.Version(), code))
+	if err := message.WritePacket(c, connack); err != nil {
+		return err
+	}
+	return ErrConnectionRefused
+}
+
+// assignClientId returns a unique ClientId for a Client which sent a zero-byte one
+func (s *Server) assignClientId() []byte {
+	n := atomic.AddUint64(&s.clientIds, 1)
+	return []byte(fmt.Sprintf("mammoth%d", n))
+}
+
+// connackCode converts a 3.1.1 Connect Return code to the Reason Code of version
+func connackCode(version byte, code byte) byte {
+	if version != message.Version5 {
+		return code
+	}
+	switch code {
+	case 0x01:
+		return message.UnsupportedProtocolVersion
+	case 0x02:
+		return message.ClientIdentifierNotValid
+	case 0x03:
+		return message.ServerUnavailable
+	case 0x04:
+		return message.BadUserNameOrPassword
+	case 0x05:
+		return message.NotAuthorized
+	}
+	return code
 }
 
 // Listen Listen on port 1883 only
 func (s *Server) Listen() error {
-	log.Println("Server starting...")
 	ln, err := net.Listen("tcp", "0.0.0.0:"+Port)
-	log.Println("Server lisening on " + Port + "...")
 	if err != nil {
 		return err
 	}
+	s.logger().Info("listening", "addr", ln.Addr().String())
+	return s.Serve(ln)
+}
+
+// Serve accepts Network Connections on ln until it is closed. It returns
+// ErrServerClosed once Close was called.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.addListener(ln) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.removeListener(ln)
 	defer ln.Close()
 
 	for {
 		c, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return s.closedErr(err)
+		}
 		if err != nil {
-			log.Println("accept conn error:", err)
+			s.logger().Error("accept failed", "err", err)
 			continue
 		}
-		s.handleConn(c)
+
+		if !s.addConn(c) {
+			c.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.handlers.Done()
+			s.handleConn(c)
+		}()
 	}
 }
+
+// addListener registers ln to be closed by Close. It returns false if the Server
+// is closed.
+func (s *Server) addListener(ln net.Listener) bool {
+	s.init()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+// removeListener forgets ln once it stopped being served
+func (s *Server) removeListener(ln net.Listener) {
+	s.mu.Lock()
+	delete(s.listeners, ln)
+	s.mu.Unlock()
+}
+
+// addConn registers c to be closed by Close, and counts the handleConn call
+// which is to serve it. It returns false if the Server is closed.
+func (s *Server) addConn(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+// closedErr returns ErrServerClosed if the Server was closed, err otherwise
+func (s *Server) closedErr(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Close stops every Serve call, disconnects every Client and waits until their
+// Network Connections are closed
+func (s *Server) Close() error {
+	s.init()
+
+	s.mu.Lock()
+	s.closed = true
+	s.cancel()
+	var err error
+	for ln := range s.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.handlers.Wait()
+	return err
+}
@@ -1,60 +1,441 @@
 package server
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/surgemq/message"
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/client"
+	"github.com/Den3/mammoth/message"
 )
 
+// startServer serves s on a free port of the loopback interface
+func startServer(t testing.TB, s *Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
 func TestHandleConn(t *testing.T) {
-	s := &Server{}
+	addr := startServer(t, &Server{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	go func() {
-		err := s.Listen()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
-	// Instantiates a new Client
-	c := &Client{}
+	received := make(chan *message.PublishMessage, 1)
+	c := &client.Client{
+		OnPublish: func(p *message.PublishMessage) {
+			received <- p
+		},
+	}
 
 	// Creates a new MQTT CONNECT message and sets the proper parameters
 	msg := message.NewConnectMessage()
-	msg.SetWillQos(1)
-	msg.SetVersion(4)
+	msg.SetWillQoS(1)
 	msg.SetCleanSession(true)
-	msg.SetClientId([]byte("surgemq"))
+	msg.SetClientId([]byte("mammoth"))
 	msg.SetKeepAlive(10)
+	msg.SetWillFlag(true)
 	msg.SetWillTopic([]byte("will"))
 	msg.SetWillMessage([]byte("send me home"))
-	msg.SetUsername([]byte("surgemq"))
+	msg.SetUserNameFlag(true)
+	msg.SetUserName([]byte("mammoth"))
+	msg.SetPasswordFlag(true)
 	msg.SetPassword([]byte("verysecret"))
 
-	// Connects to the remote server at 127.0.0.1 port 1883
-	c.Connect("tcp://127.0.0.1:1883", msg)
+	if _, err := c.Connect(ctx, "tcp://"+addr, msg); err != nil {
+		t.Fatal(err)
+	}
 
-	// Creates a new SUBSCRIBE message to subscribe to topic "abc"
 	submsg := message.NewSubscribeMessage()
-	submsg.AddTopic([]byte("abc"), 0)
+	submsg.Add([]byte("abc"), 1)
+	suback, err := c.Subscribe(ctx, submsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc := suback.ReturnCodes(); len(rc) != 1 || rc[0] != 1 {
+		t.Errorf("SUBACK return codes = %v, want [1]", rc)
+	}
 
-	// Subscribes to the topic by sending the message. The first nil in the function
-	// call is a OnCompleteFunc that should handle the SUBACK message from the server.
-	// Nil means we are ignoring the SUBACK messages. The second nil should be a
-	// OnPublishFunc that handles any messages send to the client because of this
-	// subscription. Nil means we are ignoring any PUBLISH messages for this topic.
-	c.Subscribe(submsg, nil, nil)
-
-	// Creates a new PUBLISH message with the appropriate contents for publishing
 	pubmsg := message.NewPublishMessage()
-	pubmsg.SetPacketId(pktid)
-	pubmsg.SetTopic([]byte("abc"))
+	pubmsg.SetTopicName([]byte("abc"))
 	pubmsg.SetPayload(make([]byte, 1024))
-	pubmsg.SetQoS(qos)
+	pubmsg.SetQoS(2)
+	if err := c.Publish(ctx, pubmsg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case p := <-received:
+		if string(p.TopicName()) != "abc" || len(p.Payload()) != 1024 || p.QoS() != 1 {
+			t.Errorf("received %v", p)
+		}
+	case <-ctx.Done():
+		t.Fatal("PUBLISH not received")
+	}
+
+	if err := c.Disconnect(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	addr := startServer(t, &Server{})
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("idle"))
+	connect.SetKeepAlive(1)
+	if err := message.WritePacket(c, connect); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := message.ReadPacket(c, message.Version311); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Server closes the Network Connection after 1.5 seconds without a packet
+	start := time.Now()
+	c.SetReadDeadline(start.Add(3 * time.Second))
+	if _, err := message.ReadPacket(c, message.Version311); err != io.EOF {
+		t.Fatalf("ReadPacket() error = %v, want %v", err, io.EOF)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("closed after %v, before 1.5 times the Keep Alive", d)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	addr := startServer(t, &Server{ConnectTimeout: 100 * time.Millisecond})
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The Server closes a Network Connection which does not send CONNECT
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	s := &Server{WriteTimeout: 100 * time.Millisecond}
+	addr := startServer(t, s)
+
+	// The subscriber never reads what it is sent
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("stalled"))
+	sub, _ := dialConnect(t, addr, connect)
+	subscribe := message.NewSubscribeMessage()
+	subscribe.SetPacketID([]byte{0, 1})
+	subscribe.Add([]byte("bulk"), 0)
+	if err := message.WritePacket(sub, subscribe); err != nil {
+		t.Fatal(err)
+	}
+	expectPacket(t, sub, message.Version311, "SUBACK(id=1, [0x00])")
+
+	connect = message.NewConnectMessage()
+	connect.SetClientId([]byte("publisher"))
+	pub, _ := dialConnect(t, addr, connect)
+	publish := message.NewPublishMessage()
+	publish.SetTopicName([]byte("bulk"))
+	publish.SetPayload(make([]byte, 256*1024))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if err := message.WritePacket(pub, publish); err != nil {
+			t.Fatal(err)
+		}
+		s.mu.Lock()
+		_, ok := s.sessions["stalled"]
+		s.mu.Unlock()
+		if !ok {
+			return
+		}
+	}
+	t.Error("subscriber not disconnected")
+}
+
+// dialConnect connects to addr with a raw Network Connection, sends CONNECT and
+// returns the CONNACK of the Server
+func dialConnect(t *testing.T, addr string, connect *message.ConnectMessage) (net.Conn, *message.ConnackMessage) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := message.WritePacket(c, connect); err != nil {
+		t.Fatal(err)
+	}
+	m, err := message.ReadPacket(c, connect.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, m.(*message.ConnackMessage)
+}
+
+func TestMaxConnections(t *testing.T) {
+	addr := startServer(t, &Server{MaxConnections: 1})
+
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("first"))
+	if _, connack := dialConnect(t, addr, connect); connack.ConnectReturnCode() != 0 {
+		t.Fatalf("first CONNACK return code = %#x, want 0", connack.ConnectReturnCode())
+	}
+	connect.SetClientId([]byte("second"))
+	if _, connack := dialConnect(t, addr, connect); connack.ConnectReturnCode() != 0x03 {
+		t.Errorf("second CONNACK return code = %#x, want 0x03", connack.ConnectReturnCode())
+	}
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	addr := startServer(t, &Server{MaxPacketSize: 64})
+
+	connect := message.NewConnectMessage()
+	connect.SetVersion(message.Version5)
+	connect.SetClientId([]byte("large"))
+	c, connack := dialConnect(t, addr, connect)
+	if v, _ := connack.Properties().Value(message.MaximumPacketSize); v != 64 {
+		t.Errorf("CONNACK Maximum Packet Size = %d, want 64", v)
+	}
+
+	p := message.NewPublishMessage()
+	p.SetVersion(message.Version5)
+	p.SetTopicName([]byte("a/b"))
+	p.SetPayload(make([]byte, 64))
+	if err := message.WritePacket(c, p); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := message.ReadPacket(c, message.Version5); err == nil {
+		t.Errorf("received %v, want the connection closed", m)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	static := auth.NewStatic(map[string]string{"alice": "secret"})
+	failing := auth.AuthenticatorFunc(func(ctx context.Context, r *auth.Request) (*auth.Identity, error) {
+		return nil, errors.New("backend down")
+	})
+	anonymous := auth.AuthenticatorFunc(func(ctx context.Context, r *auth.Request) (*auth.Identity, error) {
+		return nil, nil
+	})
+
+	tests := []struct {
+		a                  auth.Authenticator
+		version            byte
+		userName, password string
+		code               byte
+	}{
+		{nil, message.Version311, "", "", 0},
+		{static, message.Version311, "alice", "secret", 0},
+		{static, message.Version311, "alice", "wrong", 0x04},
+		{static, message.Version311, "mallory", "secret", 0x04},
+		{static, message.Version311, "", "", 0x05},
+		{static, message.Version5, "alice", "wrong", message.BadUserNameOrPassword},
+		{static, message.Version5, "", "", message.NotAuthorized},
+		{failing, message.Version311, "alice", "secret", 0x03},
+		{anonymous, message.Version311, "", "", 0x05},
+	}
+	for _, tt := range tests {
+		addr := startServer(t, &Server{Authenticator: tt.a})
+		connect := message.NewConnectMessage()
+		connect.SetVersion(tt.version)
+		connect.SetClientId([]byte("auth"))
+		if tt.userName != "" {
+			connect.SetUserName([]byte(tt.userName))
+			connect.SetPassword([]byte(tt.password))
+		}
+		if _, connack := dialConnect(t, addr, connect); connack.ConnectReturnCode() != tt.code {
+			t.Errorf("%q/%q v%d: CONNACK return code = %#x, want %#x", tt.userName, tt.password, tt.version, connack.ConnectReturnCode(), tt.code)
+		}
+	}
+}
+
+// expectPacket reads the next packet from c and reports whether it is the expected one
+func expectPacket(t *testing.T, c net.Conn, version byte, want string) {
+	t.Helper()
+	m, err := message.ReadPacket(c, version)
+	if err != nil {
+		t.Fatalf("reading %s: %v", want, err)
+	}
+	if s := m.(fmt.Stringer).String(); s != want {
+		t.Errorf("received %s, want %s", s, want)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	acl, err := auth.ParseACL("acl", strings.NewReader("user alice: allow pubsub a/#\nuser bob: allow subscribe a/#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, &Server{
+		Authenticator: auth.NewStatic(map[string]string{"alice": "a", "bob": "b"}),
+		Authorizer:    acl,
+	})
+
+	for _, version := range []byte{message.Version311, message.Version5} {
+		login := func(name string) net.Conn {
+			connect := message.NewConnectMessage()
+			connect.SetVersion(version)
+			connect.SetClientId([]byte(name))
+			connect.SetUserName([]byte(name))
+			connect.SetPassword([]byte(name[:1]))
+			c, connack := dialConnect(t, addr, connect)
+			if connack.ConnectReturnCode() != 0 {
+				t.Fatalf("CONNACK return code = %#x", connack.ConnectReturnCode())
+			}
+			return c
+		}
+		alice, bob := login("alice"), login("bob")
+
+		sub := message.NewSubscribeMessage()
+		sub.SetVersion(version)
+		sub.SetPacketID([]byte{0, 1})
+		sub.Add([]byte("a/#"), 1)
+		sub.Add([]byte("b/#"), 1)
+		message.WritePacket(bob, sub)
+		denied := subackDenied(version)
+		expectPacket(t, bob, version, fmt.Sprintf("SUBACK(id=1, [0x01 %#02x])", denied))
+
+		// The PUBLISH of bob is acknowledged but not delivered, not even to bob
+		p := message.NewPublishMessage()
+		p.SetVersion(version)
+		p.SetTopicName([]byte("a/bob"))
+		p.SetQoS(1)
+		p.SetPacketID([]byte{0, 2})
+		message.WritePacket(bob, p)
+		if version == message.Version5 {
+			expectPacket(t, bob, version, "PUBACK(id=2, rc=0x87)")
+		} else {
+			expectPacket(t, bob, version, "PUBACK(id=2)")
+		}
+
+		p.SetTopicName([]byte("a/alice"))
+		p.SetQoS(0)
+		p.SetPacketID(nil)
+		message.WritePacket(alice, p)
+		expectPacket(t, bob, version, `PUBLISH(q0, r0, d0, topic="a/alice", 0B)`)
+	}
+}
+
+func TestExpires(t *testing.T) {
+	addr := startServer(t, &Server{
+		Authenticator: auth.AuthenticatorFunc(func(ctx context.Context, r *auth.Request) (*auth.Identity, error) {
+			return &auth.Identity{ClientID: r.ClientID, Expires: time.Now().Add(100 * time.Millisecond)}, nil
+		}),
+	})
+
+	for _, version := range []byte{message.Version311, message.Version5} {
+		connect := message.NewConnectMessage()
+		connect.SetVersion(version)
+		connect.SetClientId([]byte("expiring"))
+		c, connack := dialConnect(t, addr, connect)
+		if connack.ConnectReturnCode() != 0 {
+			t.Fatalf("CONNACK return code = %#x", connack.ConnectReturnCode())
+		}
+		if version == message.Version5 {
+			expectPacket(t, c, version, "DISCONNECT(rc=0xa0)")
+		}
+		if _, err := message.ReadPacket(c, version); err == nil {
+			t.Errorf("version %d: connection open after the credentials expired", version)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := &Server{}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := &client.Client{}
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("closed"))
+	if _, err := c.Connect(ctx, ln.Addr().String(), connect); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-served:
+		if err != ErrServerClosed {
+			t.Errorf("Serve() = %v, want %v", err, ErrServerClosed)
+		}
+	case <-ctx.Done():
+		t.Fatal("Serve() did not return")
+	}
+	select {
+	case <-c.Done():
+	case <-ctx.Done():
+		t.Fatal("Client still connected")
+	}
+	if err := s.Serve(ln); err != ErrServerClosed {
+		t.Errorf("Serve() after Close() = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestCloseCancelsAuthenticate(t *testing.T) {
+	called := make(chan struct{})
+	s := &Server{Authenticator: auth.AuthenticatorFunc(func(ctx context.Context, r *auth.Request) (*auth.Identity, error) {
+		close(called)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})}
+	addr := startServer(t, s)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("waiting"))
+	if err := message.WritePacket(c, connect); err != nil {
+		t.Fatal(err)
+	}
+	<-called
+
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() waits for Authenticate")
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	s := &Server{}
+	addr := startServer(t, s)
 
-	// Publishes to the server by sending the message
-	c.Publish(pubmsg, nil)
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("first"))
+	dialConnect(t, addr, connect)
 
-	// Disconnects from the server
-	c.Disconnect()
+	s.SetLimits(Limits{MaxConnections: 1})
+	connect.SetClientId([]byte("second"))
+	if _, connack := dialConnect(t, addr, connect); connack.ConnectReturnCode() != 0x03 {
+		t.Errorf("CONNACK return code = %#x, want 0x03", connack.ConnectReturnCode())
+	}
 }
@@ -0,0 +1,430 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/message"
+	"github.com/Den3/mammoth/topic"
+)
+
+// DefaultQueueSize is the number of packets waiting to be written to a Client
+const DefaultQueueSize = 64
+
+// packet is a Control Packet waiting to be written to a Client. buf, if not nil,
+// holds the bytes msg refers to and is released once msg has been written.
+type packet struct {
+	msg message.Message
+	buf *message.Buffer
+
+	// last closes the session once msg has been written
+	last bool
+}
+
+// inflight is a QoS 1 or QoS 2 PUBLISH Packet sent to a Client and not acknowledged
+// yet. It keeps its own reference to buf until then.
+type inflight struct {
+	msg *message.PublishMessage
+	buf *message.Buffer
+}
+
+// session is the state of one connected Client
+type session struct {
+	server   *Server
+	conn     net.Conn
+	clientId string
+	version  byte
+
+	// identity is the Client as authenticated by the Authenticator
+	identity *auth.Identity
+
+	// keepAlive is the Keep Alive of the Client, zero if it is turned off
+	keepAlive time.Duration
+
+	// maxPacketSize is the Maximum Packet Size the Client was given, zero for none
+	maxPacketSize int
+
+	out       chan packet
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+
+	// nextID is the last Packet Identifier used for a PUBLISH Packet to the Client
+	nextID uint16
+
+	// sent are QoS 1 and QoS 2 messages sent to the Client by Packet Identifier
+	sent map[uint16]*inflight
+
+	// received are Packet Identifiers of QoS 2 messages received from the Client
+	// and waiting for PUBREL
+	received map[uint16]bool
+
+	// filters are the Topic Filters the Client is subscribed to
+	filters map[string]byte
+}
+
+func newSession(s *Server, c net.Conn, connect *message.ConnectMessage, id *auth.Identity, l Limits) *session {
+	size := l.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	ss := &session{
+		server:        s,
+		conn:          c,
+		clientId:      string(connect.ClientId()),
+		identity:      id,
+		version:       connect.Version(),
+		keepAlive:     time.Duration(connect.KeepAlive()) * time.Second,
+		maxPacketSize: l.MaxPacketSize,
+		out:           make(chan packet, size),
+		done:          make(chan struct{}),
+		sent:          map[uint16]*inflight{},
+		received:      map[uint16]bool{},
+		filters:       map[string]byte{},
+	}
+	go ss.writeLoop()
+	return ss
+}
+
+// serve handles the packets sent after CONNECT until the Client disconnects
+func (ss *session) serve() error {
+	if exp := ss.identity.Expires; !exp.IsZero() {
+		t := time.AfterFunc(time.Until(exp), ss.expire)
+		defer t.Stop()
+	}
+
+	for {
+		// If the Keep Alive value is non-zero and the Server does not receive a
+		// Control Packet from the Client within one and a half times the Keep
+		// Alive time period, it MUST disconnect the Network Connection to the
+		// Client as if the network had failed [MQTT-3.1.2-24]
+		if ss.keepAlive > 0 {
+			ss.conn.SetReadDeadline(time.Now().Add(ss.keepAlive * 3 / 2))
+		}
+
+		m, buf, err := message.ReadPacketBufferLimit(ss.conn, ss.version, ss.maxPacketSize)
+		if err != nil {
+			return err
+		}
+
+		err = ss.handle(m, buf)
+		buf.Release()
+		if err != nil {
+			return err
+		}
+		if _, ok := m.(*message.DisconnectMessage); ok {
+			return nil
+		}
+	}
+}
+
+// handle processes one packet from the Client. buf holds the bytes m refers to and
+// is released by the caller.
+func (ss *session) handle(m message.Message, buf *message.Buffer) error {
+	switch m := m.(type) {
+	case *message.PublishMessage:
+		return ss.handlePublish(m, buf)
+	case *message.PubackMessage:
+		ss.acknowledge(m.PacketID())
+	case *message.PubrecMessage:
+		rel := message.NewPubrelMessage()
+		rel.SetPacketID(copyID(m.PacketID()))
+		ss.send(rel)
+	case *message.PubcompMessage:
+		ss.acknowledge(m.PacketID())
+	case *message.PubrelMessage:
+		ss.mu.Lock()
+		delete(ss.received, binary.BigEndian.Uint16(m.PacketID()))
+		ss.mu.Unlock()
+		comp := message.NewPubcompMessage()
+		comp.SetPacketID(copyID(m.PacketID()))
+		ss.send(comp)
+	case *message.SubscribeMessage:
+		ss.handleSubscribe(m)
+	case *message.UnsubscribeMessage:
+		ss.handleUnsubscribe(m)
+	case *message.PingeqMessage:
+		ss.send(message.NewPingrespMessage())
+	case *message.ConnectMessage:
+		// The Server MUST process a second CONNECT Packet sent from a Client as a
+		// protocol violation and disconnect the Client [MQTT-3.1.0-2]
+		return ErrSecondConnect
+	}
+	return nil
+}
+
+// handlePublish routes an Application Message from the Client and acknowledges it
+func (ss *session) handlePublish(p *message.PublishMessage, buf *message.Buffer) error {
+	// A message the Client may not publish is dropped but acknowledged as usual,
+	// with Reason Code 0x87 (Not authorized) for a 5.0 Client. 3.1.1 has no Reason
+	// Codes in acknowledgements.
+	allowed := ss.authorize(auth.Publish, p.TopicName())
+
+	switch p.QoS() {
+	case 0:
+		if allowed {
+			ss.server.publish(p, buf)
+		}
+	case 1:
+		ack := message.NewPubackMessage()
+		ack.SetPacketID(copyID(p.PacketID()))
+		if allowed {
+			ss.server.publish(p, buf)
+		} else {
+			ack.SetReasonCode(message.NotAuthorized)
+		}
+		ss.send(ack)
+	case 2:
+		if !allowed {
+			rec := message.NewPubrecMessage()
+			rec.SetPacketID(copyID(p.PacketID()))
+			rec.SetReasonCode(message.NotAuthorized)
+			ss.send(rec)
+			return nil
+		}
+
+		// The receiver MUST NOT cause the message to be onward delivered to any
+		// subsequent recipients again until it has received the matching PUBREL
+		// [MQTT-4.3.3-2]
+		id := binary.BigEndian.Uint16(p.PacketID())
+		ss.mu.Lock()
+		dup := ss.received[id]
+		ss.received[id] = true
+		ss.mu.Unlock()
+		if !dup {
+			ss.server.publish(p, buf)
+		}
+		rec := message.NewPubrecMessage()
+		rec.SetPacketID(copyID(p.PacketID()))
+		ss.send(rec)
+	}
+	return nil
+}
+
+// handleSubscribe adds Subscriptions and answers with SUBACK
+func (ss *session) handleSubscribe(m *message.SubscribeMessage) {
+	ack := message.NewSubackMessage()
+	// The return codes allowed depend on the version
+	ack.SetVersion(ss.version)
+	ack.SetPacketID(copyID(m.PacketID()))
+	for i, f := range m.Topics() {
+		qos := m.QoS()[i] & 0x03
+		if topic.ValidFilter(f) && !ss.authorize(auth.Subscribe, f) {
+			ack.AddReturnCode(subackDenied(ss.version))
+			continue
+		}
+		if err := ss.server.topics.Subscribe(f, ss, qos); err != nil {
+			ack.AddReturnCode(subackFailure(ss.version))
+			continue
+		}
+		ss.mu.Lock()
+		ss.filters[string(f)] = qos
+		ss.mu.Unlock()
+		ack.AddReturnCode(qos)
+	}
+	ss.send(ack)
+}
+
+// handleUnsubscribe removes Subscriptions and answers with UNSUBACK
+func (ss *session) handleUnsubscribe(m *message.UnsubscribeMessage) {
+	ack := message.NewUnsubackMessage()
+	ack.SetPacketID(copyID(m.PacketID()))
+	for _, f := range m.Topics() {
+		ss.mu.Lock()
+		delete(ss.filters, string(f))
+		ss.mu.Unlock()
+		if ss.server.topics.Unsubscribe(f, ss) {
+			ack.AddReasonCode(message.Success)
+		} else {
+			ack.AddReasonCode(message.NoSubscriptionExisted)
+		}
+	}
+	ss.send(ack)
+}
+
+// authorize asks the Authorizer of the Server whether the Client may do action on
+// the Topic name
+func (ss *session) authorize(action auth.Action, name []byte) bool {
+	err := ss.server.authorizer().Authorize(ss.server.ctx, ss.identity, action, string(name))
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, auth.ErrNotAuthorized):
+		ss.server.logger().Debug("not authorized", "client", ss.clientId, "remote", ss.conn.RemoteAddr().String(), "action", action, "topic", string(name))
+	default:
+		ss.server.logger().Error("authorization failed", "client", ss.clientId, "remote", ss.conn.RemoteAddr().String(), "action", action, "topic", string(name), "err", err)
+	}
+	return false
+}
+
+// deliver queues a PUBLISH Packet to the Client, assigning a Packet Identifier for
+// QoS 1 and QoS 2. buf holds the bytes p refers to and is retained while p is used.
+func (ss *session) deliver(p *message.PublishMessage, buf *message.Buffer) {
+	if p.QoS() == 0 {
+		buf.Retain()
+		select {
+		case ss.out <- packet{msg: p, buf: buf}:
+		default:
+			// QoS 0 messages are dropped rather than holding up the publisher
+			// when the Client does not keep up
+			buf.Release()
+		}
+		return
+	}
+
+	ss.mu.Lock()
+	id := ss.packetID()
+	pid := make([]byte, 2)
+	binary.BigEndian.PutUint16(pid, id)
+	p.SetPacketID(pid)
+	buf.Retain()
+	ss.sent[id] = &inflight{msg: p, buf: buf}
+	ss.mu.Unlock()
+
+	buf.Retain()
+	select {
+	case ss.out <- packet{msg: p, buf: buf}:
+	case <-ss.done:
+		buf.Release()
+	default:
+		// QoS 1 and QoS 2 messages cannot be dropped, so a Client which does
+		// not keep up is disconnected rather than holding up the publisher
+		buf.Release()
+		ss.server.logger().Info("queue full", "client", ss.clientId, "remote", ss.conn.RemoteAddr().String())
+		ss.close()
+	}
+}
+
+// packetID returns an unused Packet Identifier. It is called with ss.mu held.
+func (ss *session) packetID() uint16 {
+	for {
+		ss.nextID++
+		if ss.nextID == 0 {
+			continue
+		}
+		if _, ok := ss.sent[ss.nextID]; !ok {
+			return ss.nextID
+		}
+	}
+}
+
+// acknowledge ends the delivery of a QoS 1 or QoS 2 message to the Client
+func (ss *session) acknowledge(pid []byte) {
+	id := binary.BigEndian.Uint16(pid)
+	ss.mu.Lock()
+	f := ss.sent[id]
+	delete(ss.sent, id)
+	ss.mu.Unlock()
+
+	if f != nil {
+		f.buf.Release()
+	}
+}
+
+// send queues a packet which does not refer to a Buffer
+func (ss *session) send(m message.Message) {
+	m.SetVersion(ss.version)
+	select {
+	case ss.out <- packet{msg: m}:
+	case <-ss.done:
+	}
+}
+
+// writeLoop writes queued packets to the Client until the session is closed
+func (ss *session) writeLoop() {
+	for {
+		select {
+		case p := <-ss.out:
+			ss.conn.SetWriteDeadline(time.Now().Add(ss.server.writeTimeout()))
+			err := message.WritePacket(ss.conn, p.msg)
+			if p.buf != nil {
+				p.buf.Release()
+			}
+			if err != nil || p.last {
+				ss.close()
+				return
+			}
+		case <-ss.done:
+			return
+		}
+	}
+}
+
+// expire disconnects the Client once its credentials expired. A Client of MQTT
+// 5.0 is sent DISCONNECT with Reason Code 0xA0 (Maximum connect time) first.
+func (ss *session) expire() {
+	ss.server.logger().Info("credentials expired", "client", ss.clientId, "remote", ss.conn.RemoteAddr().String())
+	if ss.version != message.Version5 {
+		ss.close()
+		return
+	}
+
+	d := message.NewDisconnectMessage()
+	d.SetVersion(ss.version)
+	d.SetReasonCode(message.MaximumConnectTime)
+	select {
+	case ss.out <- packet{msg: d, last: true}:
+	case <-ss.done:
+	default:
+		ss.close()
+	}
+}
+
+// close ends the session and its Network Connection
+func (ss *session) close() {
+	ss.closeOnce.Do(func() {
+		close(ss.done)
+		ss.conn.Close()
+	})
+}
+
+// subscriptions returns the Topic Filters the Client is subscribed to
+func (ss *session) subscriptions() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	filters := make([]string, 0, len(ss.filters))
+	for f := range ss.filters {
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+// releaseInflight drops the Buffers held by unacknowledged messages
+func (ss *session) releaseInflight() {
+	ss.mu.Lock()
+	sent := ss.sent
+	ss.sent = map[uint16]*inflight{}
+	ss.mu.Unlock()
+
+	for _, f := range sent {
+		f.buf.Release()
+	}
+}
+
+// copyID copies a Packet Identifier out of the Buffer it was decoded from
+func copyID(pid []byte) []byte {
+	return []byte{pid[0], pid[1]}
+}
+
+// subackDenied returns the SUBACK return code of a Subscription the Client is not
+// authorized to
+func subackDenied(version byte) byte {
+	if version == message.Version5 {
+		return message.NotAuthorized
+	}
+	return 0x80
+}
+
+// subackFailure returns the SUBACK return code of a rejected Subscription
+func subackFailure(version byte) byte {
+	if version == message.Version5 {
+		return message.TopicFilterInvalid
+	}
+	return 0x80
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/message"
+)
+
+// countConn is a net.Conn which discards writes and reports every one of them
+type countConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes sync.WaitGroup
+	last   []byte
+	keep   bool
+}
+
+func (c *countConn) Write(b []byte) (int, error) {
+	if c.keep {
+		c.mu.Lock()
+		c.last = append(c.last[:0], b...)
+		c.mu.Unlock()
+	}
+	c.writes.Done()
+	return len(b), nil
+}
+
+func (c *countConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *countConn) Close() error {
+	return nil
+}
+
+// stalledConn is a net.Conn whose writes block until it is closed
+type stalledConn struct {
+	net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *stalledConn) Write(b []byte) (int, error) {
+	<-c.closed
+	return 0, net.ErrClosed
+}
+
+func (c *stalledConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *stalledConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *stalledConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func newTestSession(s *Server, cid string, version byte) (*session, *countConn) {
+	s.init()
+	c := &countConn{}
+	connect := message.NewConnectMessage()
+	connect.SetVersion(version)
+	connect.SetClientId([]byte(cid))
+	ss := newSession(s, c, connect, &auth.Identity{ClientID: cid}, s.limits())
+	s.register(ss)
+	return ss, c
+}
+
+func encodePublish(t testing.TB, topic string, qos byte, payload []byte) []byte {
+	p := message.NewPublishMessage()
+	p.SetTopicName([]byte(topic))
+	p.SetQoS(qos)
+	if qos > 0 {
+		p.SetPacketID([]byte{0, 1})
+	}
+	p.SetPayload(payload)
+	buf := make([]byte, p.Len())
+	if _, err := p.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestServerPublish(t *testing.T) {
+	s := &Server{}
+	sub, subConn := newTestSession(s, "sub", message.Version311)
+	subConn.keep = true
+	defer s.unregister(sub)
+	pub, pubConn := newTestSession(s, "pub", message.Version311)
+	defer s.unregister(pub)
+
+	subscribe := message.NewSubscribeMessage()
+	subscribe.SetPacketID([]byte{0, 1})
+	subscribe.Add([]byte("sensors/+/temp"), 1)
+	subConn.writes.Add(1)
+	sub.handle(subscribe, nil)
+	subConn.writes.Wait()
+
+	src := encodePublish(t, "sensors/room1/temp", 1, []byte("21.5"))
+	m, buf, err := message.ReadPacketBuffer(bytes.NewReader(src), message.Version311)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subConn.writes.Add(1)
+	pubConn.writes.Add(1)
+	pub.handle(m, buf)
+	buf.Release()
+	subConn.writes.Wait()
+	pubConn.writes.Wait()
+
+	subConn.mu.Lock()
+	got, err := message.ReadPacket(bytes.NewReader(subConn.last), message.Version311)
+	subConn.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := got.(*message.PublishMessage)
+	if string(p.TopicName()) != "sensors/room1/temp" || string(p.Payload()) != "21.5" || p.QoS() != 1 {
+		t.Errorf("unexpected PUBLISH %q %q QoS %d", p.TopicName(), p.Payload(), p.QoS())
+	}
+
+	sub.mu.Lock()
+	inflight := len(sub.sent)
+	sub.mu.Unlock()
+	if inflight != 1 {
+		t.Fatalf("expected 1 message in flight, got %d", inflight)
+	}
+	ack := message.NewPubackMessage()
+	ack.SetPacketID(p.PacketID())
+	sub.handle(ack, nil)
+	sub.mu.Lock()
+	inflight = len(sub.sent)
+	sub.mu.Unlock()
+	if inflight != 0 {
+		t.Error("PUBACK should end the delivery")
+	}
+}
+
+func TestServerRegisterTakesOver(t *testing.T) {
+	s := &Server{}
+	old, _ := newTestSession(s, "device", message.Version311)
+	ss, _ := newTestSession(s, "device", message.Version311)
+	defer s.unregister(ss)
+
+	select {
+	case <-old.done:
+	case <-time.After(time.Second):
+		t.Error("existing session should be closed")
+	}
+}
+
+func TestServerPublishStalledSubscriber(t *testing.T) {
+	s := &Server{}
+	s.init()
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("stalled"))
+	stalled := newSession(s, &stalledConn{closed: make(chan struct{})}, connect, &auth.Identity{ClientID: "stalled"}, Limits{QueueSize: 1})
+	s.register(stalled)
+	defer s.unregister(stalled)
+	s.topics.Subscribe([]byte("sensors/+/temp"), stalled, 1)
+	live, liveConn := newTestSession(s, "live", message.Version311)
+	defer s.unregister(live)
+	s.topics.Subscribe([]byte("sensors/+/temp"), live, 1)
+
+	// The stalled subscriber holds one message in writeLoop and one in its queue
+	const n = 3
+	liveConn.writes.Add(n)
+	published := make(chan struct{})
+	go func() {
+		src := encodePublish(t, "sensors/room1/temp", 1, []byte("21.5"))
+		for i := 0; i < n; i++ {
+			m, buf, err := message.ReadPacketBuffer(bytes.NewReader(src), message.Version311)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			s.publish(m.(*message.PublishMessage), buf)
+			buf.Release()
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("the stalled subscriber holds up the publisher")
+	}
+	liveConn.writes.Wait()
+	select {
+	case <-stalled.done:
+	case <-time.After(time.Second):
+		t.Error("the stalled subscriber should be disconnected")
+	}
+}
+
+// benchmarkFanout measures decoding a 1 KiB PUBLISH, routing it to subscribers
+// subscribers and encoding it for each of them
+func benchmarkFanout(b *testing.B, subscribers int, qos byte) {
+	s := &Server{}
+	s.init()
+	var sessions []*session
+	var conns []*countConn
+	for i := 0; i < subscribers; i++ {
+		ss, c := newTestSession(s, fmt.Sprintf("sub%d", i), message.Version311)
+		defer s.unregister(ss)
+		s.topics.Subscribe([]byte("sensors/+/temp"), ss, qos)
+		sessions = append(sessions, ss)
+		conns = append(conns, c)
+	}
+
+	src := encodePublish(b, "sensors/room1/temp", qos, make([]byte, 1024))
+	r := bytes.NewReader(src)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, c := range conns {
+			c.writes.Add(1)
+		}
+
+		r.Reset(src)
+		m, buf, err := message.ReadPacketBuffer(r, message.Version311)
+		if err != nil {
+			b.Fatal(err)
+		}
+		s.publish(m.(*message.PublishMessage), buf)
+		buf.Release()
+
+		for _, c := range conns {
+			c.writes.Wait()
+		}
+		if qos > 0 {
+			b.StopTimer()
+			for _, ss := range sessions {
+				ss.releaseInflight()
+			}
+			b.StartTimer()
+		}
+	}
+}
+
+func BenchmarkFanout1000QoS0(b *testing.B) {
+	benchmarkFanout(b, 1000, 0)
+}
+
+func BenchmarkFanout1000QoS1(b *testing.B) {
+	benchmarkFanout(b, 1000, 1)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+// ErrClientCAInvalid indicates a Client CA file without any PEM encoded certificate
+var ErrClientCAInvalid = errors.New("no certificate in client CA file")
+
+// TLSOptions configures a TLS listener
+type TLSOptions struct {
+	// CertFile and KeyFile are the PEM encoded certificate chain and private key the
+	// Server presents. KeyFile may be empty if CertFile holds the key too.
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the lowest TLS version accepted, such as tls.VersionTLS12. Zero
+	// means TLS 1.2.
+	MinVersion uint16
+
+	// CipherSuites are the cipher suites of TLS 1.2 and below the Server accepts,
+	// in order of preference. Nil means the defaults of crypto/tls. The cipher
+	// suites of TLS 1.3 cannot be chosen.
+	CipherSuites []uint16
+
+	// ClientAuth tells whether Clients must present a certificate:
+	// tls.NoClientCert, tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert.
+	ClientAuth tls.ClientAuthType
+
+	// ClientCAFile holds the PEM encoded certificates of the authorities Client
+	// certificates are verified against. It is required when ClientAuth asks for
+	// certificates.
+	ClientCAFile string
+}
+
+// TLS holds the certificates of a TLS listener, which Reload reads again while
+// the Server runs
+type TLS struct {
+	opts   TLSOptions
+	config atomic.Pointer[tls.Config]
+}
+
+// NewTLS returns a TLS reading the files of opts
+func NewTLS(opts TLSOptions) (*TLS, error) {
+	t := &TLS{opts: opts}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reads the certificate, key and Client CA files again. Network Connections
+// accepted afterwards use them, the ones established keep their session. The files
+// read before are kept if it fails.
+func (t *TLS) Reload() error {
+	config, err := t.Load()
+	if err != nil {
+		return err
+	}
+	t.Store(config)
+	return nil
+}
+
+// Load reads the certificate, key and Client CA files and returns the
+// configuration they make, without putting it in use. Together with Store it
+// lets several TLS be reloaded at once, or none of them.
+func (t *TLS) Load() (*tls.Config, error) {
+	keyFile := t.opts.KeyFile
+	if keyFile == "" {
+		keyFile = t.opts.CertFile
+	}
+	cert, err := tls.LoadX509KeyPair(t.opts.CertFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   t.opts.MinVersion,
+		CipherSuites: t.opts.CipherSuites,
+		ClientAuth:   t.opts.ClientAuth,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if t.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: %w", t.opts.ClientCAFile, ErrClientCAInvalid)
+		}
+	} else if t.opts.ClientAuth >= tls.VerifyClientCertIfGiven {
+		return nil, fmt.Errorf("client certificates cannot be verified: %w", ErrClientCAInvalid)
+	}
+	return config, nil
+}
+
+// Store puts config, returned by Load, in use for the Network Connections
+// accepted afterwards
+func (t *TLS) Store(config *tls.Config) {
+	t.config.Store(config)
+}
+
+// Config returns a tls.Config serving the files read by the last successful
+// Reload
+func (t *TLS) Config() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.config.Load(), nil
+		},
+	}
+}
+
+// ServeTLS accepts Network Connections on ln like Serve, and runs MQTT over TLS on
+// them with config. A Client failing the TLS handshake is disconnected before its
+// CONNECT Packet is read.
+func (s *Server) ServeTLS(ln net.Listener, config *tls.Config) error {
+	return s.Serve(tls.NewListener(ln, config))
+}
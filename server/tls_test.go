@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/auth"
+	"github.com/Den3/mammoth/client"
+	"github.com/Den3/mammoth/message"
+)
+
+// testCert is a certificate generated for a test, written to CertFile and KeyFile
+type testCert struct {
+	CertFile string
+	KeyFile  string
+
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert generates a certificate for cn into dir, signed by parent or
+// self-signed if parent is nil. A certificate without parent is a CA.
+func newTestCert(t testing.TB, dir, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCert{
+		CertFile: filepath.Join(dir, cn+".crt"),
+		KeyFile:  filepath.Join(dir, cn+".key"),
+		cert:     cert,
+		key:      key,
+	}
+	os.WriteFile(c.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(c.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	return c
+}
+
+// pool returns a CertPool holding c
+func (c *testCert) pool() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(c.cert)
+	return p
+}
+
+// tlsCertificate returns c for a tls.Config
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// startTLSServer serves s over TLS with t on a free port of the loopback
+// interface
+func startTLSServer(tb testing.TB, s *Server, t *TLS) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go s.ServeTLS(ln, t.Config())
+	tb.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// connectTLS connects a Client to the TLS listener at addr with config
+func connectTLS(addr string, config *tls.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := &client.Client{TLSConfig: config}
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("tls"))
+	if _, err := c.Connect(ctx, "tls://"+addr, connect); err != nil {
+		return err
+	}
+	return c.Disconnect(ctx)
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "localhost", ca)
+	clientCert := newTestCert(t, dir, "device-1", ca)
+	other := newTestCert(t, dir, "other-ca", nil)
+	stranger := newTestCert(t, dir, "stranger", other)
+
+	opts := TLSOptions{CertFile: serverCert.CertFile, KeyFile: serverCert.KeyFile}
+	open, err := NewTLS(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.ClientAuth = tls.RequireAndVerifyClientCert
+	opts.ClientCAFile = ca.CertFile
+	opts.MinVersion = tls.VersionTLS13
+	strict, err := NewTLS(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{}
+	openAddr := startTLSServer(t, s, open)
+	strictAddr := startTLSServer(t, s, strict)
+
+	tests := []struct {
+		name   string
+		addr   string
+		config *tls.Config
+		ok     bool
+	}{
+		{"server certificate verified", openAddr, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}, true},
+		{"unknown server CA", openAddr, &tls.Config{RootCAs: other.pool(), ServerName: "localhost"}, false},
+		{"client certificate", strictAddr, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost", Certificates: []tls.Certificate{clientCert.tlsCertificate()}}, true},
+		{"no client certificate", strictAddr, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}, false},
+		{"unknown client CA", strictAddr, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost", Certificates: []tls.Certificate{stranger.tlsCertificate()}}, false},
+		{"TLS 1.2 below MinVersion", strictAddr, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost", Certificates: []tls.Certificate{clientCert.tlsCertificate()}, MaxVersion: tls.VersionTLS12}, false},
+	}
+	for _, tt := range tests {
+		err := connectTLS(tt.addr, tt.config)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Connect() error = %v, want success %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	cert := newTestCert(t, dir, "localhost", ca)
+	tlsCert, err := NewTLS(TLSOptions{CertFile: cert.CertFile, KeyFile: cert.KeyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSServer(t, &Server{}, tlsCert)
+
+	// The certificate is replaced by one of another CA under the same name
+	other := t.TempDir()
+	renewed := newTestCert(t, other, "ca", nil)
+	next := newTestCert(t, other, "localhost", renewed)
+	os.Rename(next.CertFile, cert.CertFile)
+	os.Rename(next.KeyFile, cert.KeyFile)
+
+	if err := connectTLS(addr, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}); err != nil {
+		t.Errorf("Connect() before Reload() = %v", err)
+	}
+	if err := tlsCert.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := connectTLS(addr, &tls.Config{RootCAs: renewed.pool(), ServerName: "localhost"}); err != nil {
+		t.Errorf("Connect() after Reload() = %v", err)
+	}
+
+	// A broken file keeps the certificate in use
+	os.WriteFile(cert.CertFile, []byte("garbage"), 0600)
+	if err := tlsCert.Reload(); err == nil {
+		t.Error("Reload() of a broken certificate succeeded")
+	}
+	if err := connectTLS(addr, &tls.Config{RootCAs: renewed.pool(), ServerName: "localhost"}); err != nil {
+		t.Errorf("Connect() after a failed Reload() = %v", err)
+	}
+}
+
+func TestNewTLSErrors(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCert(t, dir, "localhost", nil)
+	garbage := filepath.Join(dir, "garbage.pem")
+	os.WriteFile(garbage, []byte("garbage"), 0600)
+
+	tests := []struct {
+		opts TLSOptions
+		err  error
+	}{
+		{TLSOptions{CertFile: cert.CertFile, KeyFile: cert.KeyFile, ClientAuth: tls.RequireAndVerifyClientCert}, ErrClientCAInvalid},
+		{TLSOptions{CertFile: cert.CertFile, KeyFile: cert.KeyFile, ClientAuth: tls.VerifyClientCertIfGiven, ClientCAFile: garbage}, ErrClientCAInvalid},
+		{TLSOptions{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: cert.KeyFile}, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		if _, err := NewTLS(tt.opts); !errors.Is(err, tt.err) {
+			t.Errorf("NewTLS(%+v) error = %v, want %v", tt.opts, err, tt.err)
+		}
+	}
+}
+
+func TestTLSIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "localhost", ca)
+	device := newTestCert(t, dir, "device1", ca)
+	tlsCert, err := NewTLS(TLSOptions{
+		CertFile:     serverCert.CertFile,
+		KeyFile:      serverCert.KeyFile,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAFile: ca.CertFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl, err := auth.ParseACL("acl", strings.NewReader("all: allow subscribe devices/%u/#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSServer(t, &Server{
+		Authenticator: &auth.Certificate{UserName: auth.CommonName, MatchClientID: true, Next: &auth.Static{}},
+		Authorizer:    acl,
+	}, tlsCert)
+
+	withCert := &tls.Config{RootCAs: ca.pool(), ServerName: "localhost", Certificates: []tls.Certificate{device.tlsCertificate()}}
+	tests := []struct {
+		clientID string
+		config   *tls.Config
+		ok       bool
+	}{
+		{"device1", withCert, true},
+		{"device2", withCert, false},
+		{"device1", &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}, false},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, tt := range tests {
+		c := &client.Client{TLSConfig: tt.config}
+		connect := message.NewConnectMessage()
+		connect.SetClientId([]byte(tt.clientID))
+		connect.SetUserName([]byte("mallory"))
+		_, err := c.Connect(ctx, "tls://"+addr, connect)
+		if (err == nil) != tt.ok {
+			t.Errorf("Connect(%s, %d certificates) error = %v, want success %v", tt.clientID, len(tt.config.Certificates), err, tt.ok)
+		}
+		if err != nil {
+			continue
+		}
+
+		// The ACL knows the Client by the Common Name of its certificate
+		sub := message.NewSubscribeMessage()
+		sub.Add([]byte("devices/device1/#"), 0)
+		sub.Add([]byte("devices/mallory/#"), 0)
+		suback, err := c.Subscribe(ctx, sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rc := suback.ReturnCodes(); !bytes.Equal(rc, []byte{0x00, 0x80}) {
+			t.Errorf("SUBACK return codes = %#v, want [0x00 0x80]", rc)
+		}
+		c.Disconnect(ctx)
+	}
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// WebSocketSubprotocol is the WebSocket subprotocol of MQTT. The Client MUST
+	// include "mqtt" in the list of WebSocket Sub Protocols it offers
+	// [MQTT-6.0.0-3].
+	WebSocketSubprotocol = "mqtt"
+
+	// DefaultWebSocketPath is the path Clients upgrade to WebSocket on when
+	// ServeWebSocket is given none
+	DefaultWebSocketPath = "/mqtt"
+
+	// websocketGUID is appended to Sec-WebSocket-Key to compute
+	// Sec-WebSocket-Accept [RFC 6455 section 1.3]
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// maxControlPayload is the length of the longest control frame payload
+	maxControlPayload = 125
+)
+
+// WebSocket opcodes [RFC 6455 section 5.2]
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// ErrWebSocketFrame indicates a WebSocket frame which breaks RFC 6455, or a data
+// frame which is not binary
+var ErrWebSocketFrame = errors.New("invalid websocket frame")
+
+// ServeWebSocket accepts MQTT over WebSocket connections on ln until it is closed,
+// like Serve. Clients upgrade an HTTP GET request on path, DefaultWebSocketPath if
+// empty, with the subprotocol "mqtt". With config not nil the HTTP server runs over
+// TLS, for wss:// URLs.
+//
+// The Control Packets are carried by binary frames, a packet may span several
+// frames and a frame may hold several packets.
+func (s *Server) ServeWebSocket(ln net.Listener, path string, config *tls.Config) error {
+	if path == "" {
+		path = DefaultWebSocketPath
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgradeWebSocket(w, r)
+		if err != nil {
+			s.logger().Debug("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		if !s.addConn(c) {
+			c.Close()
+			return
+		}
+		defer s.handlers.Done()
+		s.handleConn(c)
+	})
+	hs := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          slog.NewLogLogger(s.logger().Handler(), slog.LevelDebug),
+	}
+
+	// Close stops the HTTP server, which closes ln and the connections not upgraded
+	hl := &httpListener{Listener: ln, server: hs}
+	if !s.addListener(hl) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.removeListener(hl)
+
+	err := hs.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		return s.closedErr(err)
+	}
+	return err
+}
+
+// httpListener is a listener served by an HTTP server. Closing it closes the
+// server.
+type httpListener struct {
+	net.Listener
+	server *http.Server
+}
+
+func (l *httpListener) Close() error {
+	return l.server.Close()
+}
+
+// upgradeWebSocket completes the opening handshake of a WebSocket connection
+// [RFC 6455 section 4.2] and takes the connection over from the HTTP server. It
+// answers requests it cannot upgrade with an HTTP error.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	fail := func(status int, msg string) (*wsConn, error) {
+		http.Error(w, msg, status)
+		return nil, errors.New(msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "websocket: method not GET")
+	}
+	if !headerHas(r.Header, "Connection", "upgrade", true) || !headerHas(r.Header, "Upgrade", "websocket", true) {
+		return fail(http.StatusBadRequest, "websocket: not an upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return fail(http.StatusBadRequest, "websocket: invalid Sec-WebSocket-Key")
+	}
+	if !headerHas(r.Header, "Sec-WebSocket-Protocol", WebSocketSubprotocol, false) {
+		return fail(http.StatusBadRequest, "websocket: subprotocol mqtt not offered")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "websocket: connection cannot be taken over")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n" +
+		"Sec-WebSocket-Protocol: " + WebSocketSubprotocol + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newWSConn(conn, brw.Reader, false), nil
+}
+
+// headerHas reports whether the comma separated list of header name holds token
+func headerHas(h http.Header, name, token string, foldCase bool) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if t == token || foldCase && strings.EqualFold(t, token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn carries a byte stream in the binary frames of a WebSocket connection
+type wsConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// client tells the Client end of the connection, which masks the frames it
+	// sends, from the Server end, which requires masked frames
+	client bool
+
+	// wmu serializes frames written by Write, Close and the answers to pings.
+	// closeSent tells that a close frame was written.
+	wmu       sync.Mutex
+	closeSent bool
+
+	// remaining is the length of the payload of the current data frame left to
+	// read, unmasked with mask from maskPos on
+	remaining uint64
+	mask      [4]byte
+	maskPos   int
+}
+
+// newWSConn returns the end of a WebSocket connection over c, read through r
+func newWSConn(c net.Conn, r *bufio.Reader, client bool) *wsConn {
+	return &wsConn{Conn: c, r: r, client: client}
+}
+
+// NetConn returns the connection the WebSocket connection runs over
+func (c *wsConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Read reads the payload of data frames, answering the control frames read in
+// between. It returns io.EOF once the peer closed the WebSocket connection.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		op, err := c.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case opBinary, opContinuation:
+		case opClose, opPing, opPong:
+			payload := make([]byte, c.remaining)
+			for n := 0; n < len(payload); {
+				k, err := c.readPayload(payload[n:])
+				if err != nil {
+					return 0, err
+				}
+				n += k
+			}
+			if op == opPing {
+				if err := c.writeFrame(opPong, payload); err != nil {
+					return 0, err
+				}
+			}
+			if op == opClose {
+				// The status code of the peer is echoed
+				if len(payload) > 2 {
+					payload = payload[:2]
+				}
+				c.writeFrame(opClose, payload)
+				return 0, io.EOF
+			}
+		default:
+			// MQTT Control Packets MUST be sent in WebSocket binary data frames. If
+			// any other type of data frame is received the recipient MUST close the
+			// Network Connection [MQTT-6.0.0-1]
+			return 0, ErrWebSocketFrame
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	return c.readPayload(p)
+}
+
+// nextFrame reads the header of the next frame and returns its opcode
+func (c *wsConn) nextFrame() (byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return 0, err
+	}
+	fin, rsv, op := h[0]&0x80 != 0, h[0]&0x70, h[0]&0x0f
+	masked, n := h[1]&0x80 != 0, uint64(h[1]&0x7f)
+
+	// A client MUST mask all frames that it sends to the server, and a server MUST
+	// NOT mask any frames that it sends to the client [RFC 6455 section 5.1]
+	if rsv != 0 || masked == c.client {
+		return 0, ErrWebSocketFrame
+	}
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return 0, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return 0, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+		if n>>63 != 0 {
+			return 0, ErrWebSocketFrame
+		}
+	}
+	// Control frames MUST have a payload length of 125 bytes or less and MUST NOT
+	// be fragmented [RFC 6455 section 5.5]
+	if op >= opClose && (!fin || n > maxControlPayload) {
+		return 0, ErrWebSocketFrame
+	}
+
+	c.mask = [4]byte{}
+	if masked {
+		if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+			return 0, err
+		}
+	}
+	c.remaining, c.maskPos = n, 0
+	return op, nil
+}
+
+// readPayload reads up to len(p) bytes of the payload of the current frame
+func (c *wsConn) readPayload(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := c.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= c.mask[(c.maskPos+i)&3]
+	}
+	c.maskPos = (c.maskPos + n) & 3
+	c.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Write sends p in one binary frame
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends one unfragmented frame. Nothing is sent after a close frame.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := c.frame(op, payload)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	c.closeSent = op == opClose
+	_, err := frame.WriteTo(c.Conn)
+	return err
+}
+
+// frame encodes a frame, masked for the Client end
+func (c *wsConn) frame(op byte, payload []byte) net.Buffers {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | op
+	switch n := len(payload); {
+	case n <= maxControlPayload:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	if !c.client {
+		return net.Buffers{header, payload}
+	}
+	header[1] |= 0x80
+	var mask [4]byte
+	rand.Read(mask[:])
+	header = append(header, mask[:]...)
+	data := make([]byte, len(payload))
+	for i, b := range payload {
+		data[i] = b ^ mask[i&3]
+	}
+	return net.Buffers{header, data}
+}
+
+// Close sends a close frame, unless one was sent or a frame is being written, and
+// closes the connection
+func (c *wsConn) Close() error {
+	if c.wmu.TryLock() {
+		if !c.closeSent {
+			c.closeSent = true
+			c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+			frame := c.frame(opClose, nil)
+			frame.WriteTo(c.Conn)
+		}
+		c.wmu.Unlock()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Den3/mammoth/message"
+)
+
+// startWebSocketServer serves s over WebSocket on a free port of the loopback
+// interface, with TLS if config is not nil. It returns the address and the
+// result of ServeWebSocket.
+func startWebSocketServer(t *testing.T, s *Server, config *tls.Config) (string, chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.ServeWebSocket(ln, "", config) }()
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String(), served
+}
+
+// dialWebSocket sends an upgrade request to addr, changed by edit if it is not
+// nil, and returns the response and the Client end of the WebSocket connection
+func dialWebSocket(t *testing.T, addr string, config *tls.Config, edit func(*http.Request)) (*http.Response, *wsConn, *bufio.Reader) {
+	var c net.Conn
+	var err error
+	if config != nil {
+		c, err = tls.Dial("tcp", addr, config)
+	} else {
+		c, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+DefaultWebSocketPath, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", "mqttv3.1, mqtt")
+	if edit != nil {
+		edit(req)
+	}
+	if err := req.Write(c); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, newWSConn(c, br, true), br
+}
+
+// maskedFrame returns a frame sent by a Client, b0 holding the FIN bit and the
+// opcode
+func maskedFrame(b0 byte, payload []byte) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{b0, 0x80 | byte(len(payload))}, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// encode returns the bytes of m
+func encode(t *testing.T, m message.Message) []byte {
+	var b bytes.Buffer
+	if err := message.WritePacket(&b, m); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	addr, _ := startWebSocketServer(t, &Server{}, nil)
+
+	tests := []struct {
+		name   string
+		edit   func(*http.Request)
+		status int
+	}{
+		{"valid", nil, http.StatusSwitchingProtocols},
+		{"no mqtt subprotocol", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "mqttv3.1") }, http.StatusBadRequest},
+		{"old version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired},
+		{"no upgrade", func(r *http.Request) { r.Header.Del("Upgrade") }, http.StatusBadRequest},
+		{"short key", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=") }, http.StatusBadRequest},
+		{"POST", func(r *http.Request) { r.Method = http.MethodPost }, http.StatusMethodNotAllowed},
+		{"other path", func(r *http.Request) { r.URL.Path = "/" }, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, _, _ := dialWebSocket(t, addr, nil, tt.edit)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+
+	resp, _, _ := dialWebSocket(t, addr, nil, nil)
+	// The example of RFC 6455 section 1.3
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %s", got)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "mqtt" {
+		t.Errorf("Sec-WebSocket-Protocol = %s, want mqtt", got)
+	}
+}
+
+func TestServeWebSocket(t *testing.T) {
+	s := &Server{}
+	addr, served := startWebSocketServer(t, s, nil)
+	_, ws, br := dialWebSocket(t, addr, nil, nil)
+	c := ws.Conn
+
+	// CONNECT is split across a fragmented message
+	connect := message.NewConnectMessage()
+	connect.SetVersion(message.Version5)
+	connect.SetClientId([]byte("browser"))
+	b := encode(t, connect)
+	c.Write(maskedFrame(opBinary, b[:3]))
+	c.Write(maskedFrame(0x80|opContinuation, b[3:]))
+	expectPacket(t, ws, message.Version5, "CONNACK(sp=0, rc=0x00)")
+
+	// A ping is answered between packets
+	c.Write(maskedFrame(0x80|opPing, []byte("hi")))
+	pong := make([]byte, 4)
+	if _, err := io.ReadFull(br, pong); err != nil || !bytes.Equal(pong, []byte{0x80 | opPong, 2, 'h', 'i'}) {
+		t.Errorf("pong = %q, %v", pong, err)
+	}
+
+	// SUBSCRIBE and PUBLISH are packed in one frame
+	sub := message.NewSubscribeMessage()
+	sub.SetVersion(message.Version5)
+	sub.SetPacketID([]byte{0, 1})
+	sub.Add([]byte("dashboard"), 0)
+	pub := message.NewPublishMessage()
+	pub.SetVersion(message.Version5)
+	pub.SetTopicName([]byte("dashboard"))
+	pub.SetPayload([]byte("42"))
+	c.Write(maskedFrame(0x80|opBinary, append(encode(t, sub), encode(t, pub)...)))
+	expectPacket(t, ws, message.Version5, "SUBACK(id=1, [0x00])")
+	expectPacket(t, ws, message.Version5, `PUBLISH(q0, r0, d0, topic="dashboard", 2B)`)
+
+	// Text frames close the Network Connection
+	c.Write(maskedFrame(0x80|opText, encode(t, pub)))
+	if m, err := message.ReadPacket(ws, message.Version5); err == nil {
+		t.Errorf("received %v after a text frame", m)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("ServeWebSocket() = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestServeWebSocketTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	cert := newTestCert(t, dir, "localhost", ca)
+	tlsCert, err := NewTLS(TLSOptions{CertFile: cert.CertFile, KeyFile: cert.KeyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := startWebSocketServer(t, &Server{}, tlsCert.Config())
+
+	resp, ws, _ := dialWebSocket(t, addr, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}, nil)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	connect := message.NewConnectMessage()
+	connect.SetClientId([]byte("wss"))
+	if err := message.WritePacket(ws, connect); err != nil {
+		t.Fatal(err)
+	}
+	expectPacket(t, ws, message.Version311, "CONNACK(sp=0, rc=0x00)")
+}
@@ -0,0 +1,315 @@
+package topic
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	// ErrTopicFilterInvalid indicates a Topic Filter uses wildcard characters wrongly
+	ErrTopicFilterInvalid = errors.New("invalid Topic Filter")
+)
+
+const (
+	// Separator is the Topic level separator
+	Separator = '/'
+
+	// MultiLevel is the multi-level wildcard which matches any number of levels
+	MultiLevel = '#'
+
+	// SingleLevel is the single-level wildcard which matches only one level
+	SingleLevel = '+'
+)
+
+// ValidName reports whether name can be used as a Topic Name. Topic Names are at
+// least one character long and MUST NOT contain wildcard characters [MQTT-4.7.3-1]
+// [MQTT-3.3.2-2].
+func ValidName(name []byte) bool {
+	return len(name) > 0 && utf8.Valid(name) && bytes.IndexAny(name, "+#") < 0
+}
+
+// ValidFilter reports whether filter can be used as a Topic Filter.
+//
+// The multi-level wildcard character MUST be specified either on its own or
+// following a topic level separator. In either case it MUST be the last
+// character specified in the Topic Filter [MQTT-4.7.1-2].
+//
+// The single-level wildcard can be used at any level in the Topic Filter, including
+// first and last levels. Where it is used it MUST occupy an entire level of the
+// filter [MQTT-4.7.1-3].
+func ValidFilter(filter []byte) bool {
+	if len(filter) == 0 || !utf8.Valid(filter) {
+		return false
+	}
+	for rest, done := filter, false; !done; {
+		var level []byte
+		level, rest, done = split(rest)
+		if bytes.IndexAny(level, "+#") >= 0 && len(level) != 1 {
+			return false
+		}
+		if len(level) == 1 && level[0] == MultiLevel && !done {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether Topic Name name matches Topic Filter filter.
+//
+// The Server MUST NOT match Topic Filters starting with a wildcard character with
+// Topic Names beginning with a $ character [MQTT-4.7.2-1].
+func Match(filter, name []byte) bool {
+	if len(name) > 0 && name[0] == '$' && len(filter) > 0 &&
+		(filter[0] == MultiLevel || filter[0] == SingleLevel) {
+		return false
+	}
+
+	for {
+		fl, frest, fdone := split(filter)
+		if len(fl) == 1 && fl[0] == MultiLevel {
+			return true
+		}
+
+		nl, nrest, ndone := split(name)
+		if !(len(fl) == 1 && fl[0] == SingleLevel) && !bytes.Equal(fl, nl) {
+			return false
+		}
+
+		switch {
+		case fdone:
+			return ndone
+		case ndone:
+			// "sport/#" also matches the parent level "sport"
+			return len(frest) == 1 && frest[0] == MultiLevel
+		}
+		filter, name = frest, nrest
+	}
+}
+
+// Covers reports whether every Topic Name matching Topic Filter sub also matches
+// Topic Filter filter, so that a Client allowed to receive the messages of filter
+// may subscribe to sub
+func Covers(filter, sub []byte) bool {
+	if dollarWildcard(filter, sub) {
+		return false
+	}
+
+	for {
+		fl, frest, fdone := split(filter)
+		if isWildcard(fl, MultiLevel) {
+			return true
+		}
+
+		sl, srest, sdone := split(sub)
+		switch {
+		case isWildcard(sl, MultiLevel):
+			return false
+		case isWildcard(fl, SingleLevel):
+		case isWildcard(sl, SingleLevel) || !bytes.Equal(fl, sl):
+			return false
+		}
+
+		switch {
+		case fdone:
+			return sdone
+		case sdone:
+			return isWildcard(frest, MultiLevel)
+		}
+		filter, sub = frest, srest
+	}
+}
+
+// Overlap reports whether some Topic Name matches both Topic Filters a and b
+func Overlap(a, b []byte) bool {
+	if dollarWildcard(a, b) || dollarWildcard(b, a) {
+		return false
+	}
+
+	for {
+		al, arest, adone := split(a)
+		bl, brest, bdone := split(b)
+		switch {
+		case isWildcard(al, MultiLevel) || isWildcard(bl, MultiLevel):
+			return true
+		case isWildcard(al, SingleLevel) || isWildcard(bl, SingleLevel):
+		case !bytes.Equal(al, bl):
+			return false
+		}
+
+		switch {
+		case adone && bdone:
+			return true
+		case adone:
+			return isWildcard(brest, MultiLevel)
+		case bdone:
+			return isWildcard(arest, MultiLevel)
+		}
+		a, b = arest, brest
+	}
+}
+
+// dollarWildcard reports whether filter starts with a wildcard character while
+// other starts with $, which never match [MQTT-4.7.2-1]
+func dollarWildcard(filter, other []byte) bool {
+	return len(filter) > 0 && (filter[0] == MultiLevel || filter[0] == SingleLevel) &&
+		len(other) > 0 && other[0] == '$'
+}
+
+// isWildcard reports whether level is the wildcard character w on its own
+func isWildcard(level []byte, w byte) bool {
+	return len(level) == 1 && level[0] == w
+}
+
+// split returns the first level of a Topic, the rest of it and whether it was the
+// last level
+func split(t []byte) ([]byte, []byte, bool) {
+	i := bytes.IndexByte(t, Separator)
+	if i < 0 {
+		return t, nil, true
+	}
+	return t[:i], t[i+1:], false
+}
+
+// Subscription is a Subscriber together with the maximum QoS granted to it
+type Subscription struct {
+	Subscriber interface{}
+	QoS        byte
+}
+
+// Tree keeps Subscriptions by Topic Filter level so that the Subscriptions matching
+// a Topic Name can be found without comparing it to every Topic Filter
+type Tree struct {
+	mu   sync.RWMutex
+	root *node
+}
+
+type node struct {
+	children map[string]*node
+	subs     map[interface{}]byte
+}
+
+// NewTree returns an empty Tree
+func NewTree() *Tree {
+	return &Tree{root: &node{}}
+}
+
+// Subscribe adds or replaces the Subscription of sub to filter
+func (t *Tree) Subscribe(filter []byte, sub interface{}, qos byte) error {
+	if !ValidFilter(filter) {
+		return ErrTopicFilterInvalid
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := t.root
+	for rest, done := filter, false; !done; {
+		var level []byte
+		level, rest, done = split(rest)
+		child := n.children[string(level)]
+		if child == nil {
+			child = &node{}
+			if n.children == nil {
+				n.children = map[string]*node{}
+			}
+			n.children[string(level)] = child
+		}
+		n = child
+	}
+	if n.subs == nil {
+		n.subs = map[interface{}]byte{}
+	}
+	n.subs[sub] = qos
+	return nil
+}
+
+// Unsubscribe removes the Subscription of sub to filter and reports whether it existed
+func (t *Tree) Unsubscribe(filter []byte, sub interface{}) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.root.unsubscribe(filter, false, sub)
+}
+
+func (n *node) unsubscribe(rest []byte, done bool, sub interface{}) bool {
+	if done {
+		if _, ok := n.subs[sub]; !ok {
+			return false
+		}
+		delete(n.subs, sub)
+		return true
+	}
+
+	level, rest, done := split(rest)
+	child := n.children[string(level)]
+	if child == nil {
+		return false
+	}
+	ok := child.unsubscribe(rest, done, sub)
+	if len(child.subs) == 0 && len(child.children) == 0 {
+		delete(n.children, string(level))
+	}
+	return ok
+}
+
+// Match appends the Subscriptions matching Topic Name name to subs and returns the
+// extended slice. A Subscriber matching through several Topic Filters is returned
+// once with the highest QoS of them.
+func (t *Tree) Match(name []byte, subs []Subscription) []Subscription {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var nodes []*node
+	t.root.match(name, false, true, &nodes)
+
+	start := len(subs)
+	for _, n := range nodes {
+		for sub, qos := range n.subs {
+			subs = append(subs, Subscription{Subscriber: sub, QoS: qos})
+		}
+	}
+	if len(nodes) < 2 {
+		return subs
+	}
+
+	seen := make(map[interface{}]int, len(subs)-start)
+	out := subs[:start]
+	for _, s := range subs[start:] {
+		if i, ok := seen[s.Subscriber]; ok {
+			if s.QoS > out[i].QoS {
+				out[i].QoS = s.QoS
+			}
+			continue
+		}
+		seen[s.Subscriber] = len(out)
+		out = append(out, s)
+	}
+	return out
+}
+
+func (n *node) match(rest []byte, done bool, first bool, nodes *[]*node) {
+	if done {
+		if len(n.subs) > 0 {
+			*nodes = append(*nodes, n)
+		}
+		if c := n.children[string(MultiLevel)]; c != nil && len(c.subs) > 0 {
+			*nodes = append(*nodes, c)
+		}
+		return
+	}
+
+	level, rest, done := split(rest)
+	if !first || len(level) == 0 || level[0] != '$' {
+		if c := n.children[string(MultiLevel)]; c != nil && len(c.subs) > 0 {
+			*nodes = append(*nodes, c)
+		}
+		if c := n.children[string(SingleLevel)]; c != nil {
+			c.match(rest, done, false, nodes)
+		}
+	}
+	if c := n.children[string(level)]; c != nil {
+		c.match(rest, done, false, nodes)
+	}
+}
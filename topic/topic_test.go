@@ -0,0 +1,182 @@
+package topic
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestValidFilter(t *testing.T) {
+	testCases := []struct {
+		filter string
+		valid  bool
+	}{
+		{filter: "sport/tennis/player1", valid: true},
+		{filter: "sport/tennis/#", valid: true},
+		{filter: "#", valid: true},
+		{filter: "+", valid: true},
+		{filter: "+/tennis/#", valid: true},
+		{filter: "sport/+/player1", valid: true},
+		{filter: "/", valid: true},
+		{filter: "", valid: false},
+		{filter: "sport/tennis#", valid: false},
+		{filter: "sport/tennis/#/ranking", valid: false},
+		{filter: "sport+", valid: false},
+	}
+
+	for _, tc := range testCases {
+		if ValidFilter([]byte(tc.filter)) != tc.valid {
+			t.Errorf("%q: expected %v", tc.filter, tc.valid)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		filter string
+		name   string
+		match  bool
+	}{
+		{filter: "sport/tennis/player1/#", name: "sport/tennis/player1", match: true},
+		{filter: "sport/tennis/player1/#", name: "sport/tennis/player1/ranking", match: true},
+		{filter: "sport/tennis/player1/#", name: "sport/tennis/player1/score/wimbledon", match: true},
+		{filter: "sport/#", name: "sport", match: true},
+		{filter: "#", name: "sport/tennis", match: true},
+		{filter: "sport/tennis/+", name: "sport/tennis/player1", match: true},
+		{filter: "sport/tennis/+", name: "sport/tennis/player1/ranking", match: false},
+		{filter: "sport/+", name: "sport", match: false},
+		{filter: "sport/+", name: "sport/", match: true},
+		{filter: "+/+", name: "/finance", match: true},
+		{filter: "/+", name: "/finance", match: true},
+		{filter: "+", name: "/finance", match: false},
+		{filter: "a/b", name: "a/b", match: true},
+		{filter: "a/b", name: "a/b/c", match: false},
+		{filter: "a/b/c", name: "a/b", match: false},
+		{filter: "#", name: "$SYS/uptime", match: false},
+		{filter: "+/monitor/Clients", name: "$SYS/monitor/Clients", match: false},
+		{filter: "$SYS/#", name: "$SYS/uptime", match: true},
+	}
+
+	for _, tc := range testCases {
+		if Match([]byte(tc.filter), []byte(tc.name)) != tc.match {
+			t.Errorf("%q %q: expected %v", tc.filter, tc.name, tc.match)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	testCases := []struct {
+		filter string
+		sub    string
+		covers bool
+	}{
+		{filter: "#", sub: "sport/tennis/#", covers: true},
+		{filter: "sport/#", sub: "sport", covers: true},
+		{filter: "sport/#", sub: "sport/+/player1", covers: true},
+		{filter: "sport/#", sub: "#", covers: false},
+		{filter: "sport/+", sub: "sport/tennis", covers: true},
+		{filter: "sport/+", sub: "sport/+", covers: true},
+		{filter: "sport/+", sub: "sport/#", covers: false},
+		{filter: "sport/+", sub: "sport/tennis/player1", covers: false},
+		{filter: "sport/tennis", sub: "sport/+", covers: false},
+		{filter: "sport/tennis", sub: "sport/tennis", covers: true},
+		{filter: "sport/tennis/#", sub: "sport", covers: false},
+		{filter: "#", sub: "$SYS/uptime", covers: false},
+		{filter: "$SYS/#", sub: "$SYS/+", covers: true},
+	}
+
+	for _, tc := range testCases {
+		if Covers([]byte(tc.filter), []byte(tc.sub)) != tc.covers {
+			t.Errorf("%q %q: expected %v", tc.filter, tc.sub, tc.covers)
+		}
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	testCases := []struct {
+		a, b    string
+		overlap bool
+	}{
+		{a: "#", b: "secret/#", overlap: true},
+		{a: "+/b", b: "a/+", overlap: true},
+		{a: "a/#", b: "a", overlap: true},
+		{a: "a/+", b: "a", overlap: false},
+		{a: "a/b", b: "a/c", overlap: false},
+		{a: "a/b/c", b: "a/b", overlap: false},
+		{a: "a/+/c", b: "a/b/+", overlap: true},
+		{a: "#", b: "$SYS/#", overlap: false},
+		{a: "$SYS/#", b: "$SYS/uptime", overlap: true},
+	}
+
+	for _, tc := range testCases {
+		if Overlap([]byte(tc.a), []byte(tc.b)) != tc.overlap || Overlap([]byte(tc.b), []byte(tc.a)) != tc.overlap {
+			t.Errorf("%q %q: expected %v", tc.a, tc.b, tc.overlap)
+		}
+	}
+}
+
+func TestTreeMatch(t *testing.T) {
+	tree := NewTree()
+	subs := map[string][]string{
+		"a": {"sport/tennis/+", "sport/#"},
+		"b": {"sport/tennis/player1"},
+		"c": {"#"},
+		"d": {"+/+/+/ranking"},
+		"e": {"$SYS/#"},
+	}
+	for sub, filters := range subs {
+		for i, f := range filters {
+			if err := tree.Subscribe([]byte(f), sub, byte(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		expected []string
+	}{
+		{name: "sport/tennis/player1", expected: []string{"a", "b", "c"}},
+		{name: "sport", expected: []string{"a", "c"}},
+		{name: "sport/tennis/player1/ranking", expected: []string{"a", "c", "d"}},
+		{name: "news", expected: []string{"c"}},
+		{name: "$SYS/uptime", expected: []string{"e"}},
+	}
+
+	for _, tc := range testCases {
+		matched := tree.Match([]byte(tc.name), nil)
+		var got []string
+		for _, s := range matched {
+			got = append(got, s.Subscriber.(string))
+			if s.Subscriber == "a" && tc.name == "sport/tennis/player1" && s.QoS != 1 {
+				t.Errorf("%q: expected the highest QoS 1, got %d", tc.name, s.QoS)
+			}
+		}
+		sort.Strings(got)
+		if len(got) != len(tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.name, tc.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("%q: expected %v, got %v", tc.name, tc.expected, got)
+			}
+		}
+	}
+}
+
+func TestTreeUnsubscribe(t *testing.T) {
+	tree := NewTree()
+	tree.Subscribe([]byte("a/+/c"), "x", 0)
+	if !tree.Unsubscribe([]byte("a/+/c"), "x") {
+		t.Error("Subscription should exist")
+	}
+	if tree.Unsubscribe([]byte("a/+/c"), "x") {
+		t.Error("Subscription should be removed")
+	}
+	if len(tree.root.children) != 0 {
+		t.Error("empty levels should be removed")
+	}
+	if len(tree.Match([]byte("a/b/c"), nil)) != 0 {
+		t.Error("removed Subscription should not match")
+	}
+}